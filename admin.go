@@ -0,0 +1,131 @@
+package peerhub
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+)
+
+var (
+	ErrAdminDisabled         = errors.New("administrative operations are disabled")
+	ErrInvalidMasterPassword = errors.New("invalid master password")
+)
+
+type Stats struct {
+	AnsweringPeers       int `json:"answeringpeers"`
+	OnlineAnsweringPeers int `json:"onlineansweringpeers"`
+	OfferingPeers        int `json:"offeringpeers"`
+	PendingOffers        int `json:"pendingoffers"`
+	AnsweredOffers       int `json:"answeredoffers"`
+}
+
+// AuthorizeAdmin checks the master password, transports use it to guard their own administrative operations
+func (h *Hub) AuthorizeAdmin(password string) error {
+	if h.masterPassword == "" {
+		return ErrAdminDisabled
+	}
+
+	if subtle.ConstantTimeCompare([]byte(h.masterPassword), []byte(password)) != 1 {
+		return ErrInvalidMasterPassword
+	}
+
+	return nil
+}
+
+func (h *Hub) GetOfferingPeers(ctx context.Context, req AdminRequest) ([]OfferingPeer, error) {
+	if err := h.AuthorizeAdmin(req.MasterPassword); err != nil {
+		return nil, err
+	}
+
+	ops, err := h.peerSvc.GetOfferingPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// target access keys are credentials of the answering peers, they never leave the hub
+	for i := range ops {
+		ops[i].TargetAccessKey = ""
+	}
+
+	return ops, nil
+}
+
+// ForceDeleteAnsweringPeer deletes answering peer regardless of its management key
+func (h *Hub) ForceDeleteAnsweringPeer(ctx context.Context, req ForceDeletePeerRequest) error {
+	if err := h.AuthorizeAdmin(req.MasterPassword); err != nil {
+		return err
+	}
+
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	return h.deleteAnsweringPeer(ctx, ap)
+}
+
+// ForceDeleteOfferingPeer deletes offering peer regardless of its management key
+func (h *Hub) ForceDeleteOfferingPeer(ctx context.Context, req ForceDeletePeerRequest) error {
+	if err := h.AuthorizeAdmin(req.MasterPassword); err != nil {
+		return err
+	}
+
+	op, err := h.peerSvc.GetOfferingPeer(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	return h.deleteOfferingPeer(ctx, op)
+}
+
+func (h *Hub) Stats(ctx context.Context, req AdminRequest) (Stats, error) {
+	if err := h.AuthorizeAdmin(req.MasterPassword); err != nil {
+		return Stats{}, err
+	}
+
+	aps, err := h.peerSvc.GetAnsweringPeers(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	ops, err := h.peerSvc.GetOfferingPeers(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	offers, err := h.dealSvc.GetOffers(ctx)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		AnsweringPeers: len(aps),
+		OfferingPeers:  len(ops),
+	}
+
+	for _, ap := range aps {
+		if ap.Online {
+			stats.OnlineAnsweringPeers++
+		}
+	}
+
+	for _, offer := range offers {
+		switch offer.State {
+		case OfferStatePending:
+			stats.PendingOffers++
+		case OfferStateAnswered:
+			stats.AnsweredOffers++
+		}
+	}
+
+	return stats, nil
+}
+
+type AdminRequest struct {
+	MasterPassword string `json:"masterpassword"`
+}
+
+type ForceDeletePeerRequest struct {
+	MasterPassword string `json:"masterpassword"`
+	Name           string `json:"name"`
+}
@@ -0,0 +1,56 @@
+package peerhub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+func TestAdminOperationsRequireTheMasterPassword(t *testing.T) {
+	tests := []struct {
+		name           string
+		masterPassword string
+		password       string
+		want           error
+	}{
+		{name: "disabled", password: "", want: peerhub.ErrAdminDisabled},
+		{name: "disabled with a password", password: "secret", want: peerhub.ErrAdminDisabled},
+		{name: "wrong password", masterPassword: "secret", password: "wrong", want: peerhub.ErrInvalidMasterPassword},
+		{name: "no password", masterPassword: "secret", want: peerhub.ErrInvalidMasterPassword},
+		{name: "master password", masterPassword: "secret", password: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := peerhub.NewHub(peerhub.HubConfig{
+				PeerService:    peer.NewInMemoryService(),
+				SignalService:  sig.NewInMemoryService(),
+				MasterPassword: tt.masterPassword,
+			})
+			ctx := context.Background()
+			if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap", ManagementKey: "mk"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := h.Stats(ctx, peerhub.AdminRequest{MasterPassword: tt.password}); !errors.Is(err, tt.want) {
+				t.Errorf("stats returned %v, want %v", err, tt.want)
+			}
+			if _, err := h.GetOfferingPeers(ctx, peerhub.AdminRequest{MasterPassword: tt.password}); !errors.Is(err, tt.want) {
+				t.Errorf("offering peers returned %v, want %v", err, tt.want)
+			}
+
+			err := h.ForceDeleteAnsweringPeer(ctx, peerhub.ForceDeletePeerRequest{MasterPassword: tt.password, Name: "ap"})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("force delete returned %v, want %v", err, tt.want)
+			}
+			_, err = h.AuthorizeAnsweringPeer(ctx, peerhub.PeerCredentials{Name: "ap", ManagementKey: "mk"})
+			if deleted := errors.Is(err, peerhub.ErrAnsweringPeerNotFound); deleted != (tt.want == nil) {
+				t.Errorf("peer deleted %v, err %v", deleted, err)
+			}
+		})
+	}
+}
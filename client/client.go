@@ -0,0 +1,506 @@
+// Package client speaks the websocket protocol of the peerhub server, it correlates replies with requests,
+// delivers messages pushed by the hub over channels and reconnects when the connection drops
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultTimeout           = 10 * time.Second
+	DefaultReconnectInterval = time.Second
+	DefaultEventBuffer       = 64
+)
+
+var (
+	ErrClosed       = errors.New("client closed")
+	ErrNotConnected = errors.New("not connected to the hub")
+	ErrNoSession    = errors.New("hub did not start a session")
+	// ErrOfferPending is returned by Offer if the answering peer isn't registered yet,
+	// the offer is delivered over OffersCreated once it registers
+	ErrOfferPending = errors.New("offer is pending until the answering peer registers")
+)
+
+type Config struct {
+	// URL is the websocket endpoint of the hub, as in ws://localhost:54321/hub
+	URL string
+	// Timeout bounds the time a request waits for its reply, defaults to DefaultTimeout
+	Timeout time.Duration
+	// ReconnectInterval is the time between reconnect attempts, defaults to DefaultReconnectInterval
+	ReconnectInterval time.Duration
+	// EventBuffer is the capacity of event channels, defaults to DefaultEventBuffer
+	EventBuffer int
+	// Dialer dials the hub, defaults to websocket.DefaultDialer
+	Dialer *websocket.Dialer
+}
+
+// Client is a connection to the hub shared by any number of peers, when the connection drops the client
+// resumes its session and if the session is gone it registers its peers again
+//
+// Pushed messages are delivered over buffered channels, the client stops reading from the hub while a channel
+// is full, so every channel has to be drained
+type Client struct {
+	cfg    Config
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// wmu serializes writes to the websocket
+	wmu sync.Mutex
+	// emitters are goroutines emitting events besides run, event channels are closed once they're done
+	emitters sync.WaitGroup
+	mu       sync.Mutex
+	ws       *websocket.Conn
+	// token and seq resume the session, seq is the last sequence number received
+	token   string
+	seq     uint64
+	pending map[string]*call
+	// aps and ops are requests of peers registered again when the session is lost
+	aps map[string]peerhub.CreateAnsweringPeerRequest
+	ops map[string]peerhub.CreateOfferingPeerRequest
+
+	offers     chan peerhub.Offer
+	created    chan peerhub.Offer
+	answers    chan peerhub.Answer
+	candidates chan peerhub.Candidate
+	failures   chan *Failure
+	errs       chan error
+}
+
+// call waits for the ack or error ending a request, the first reply of a wanted type is its result
+type call struct {
+	want   []messageType
+	result chan message
+	done   chan message
+	// taken is set once the result was replied
+	taken bool
+}
+
+// take reports whether the reply is the result of the call
+func (c *call) take(mt messageType) bool {
+	if c.taken {
+		return false
+	}
+	for _, t := range c.want {
+		if t == mt {
+			c.taken = true
+			return true
+		}
+	}
+	return false
+}
+
+// Dial connects to the hub and starts a session
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = DefaultReconnectInterval
+	}
+	if cfg.EventBuffer <= 0 {
+		cfg.EventBuffer = DefaultEventBuffer
+	}
+	if cfg.Dialer == nil {
+		cfg.Dialer = websocket.DefaultDialer
+	}
+
+	c := &Client{
+		cfg:        cfg,
+		pending:    map[string]*call{},
+		aps:        map[string]peerhub.CreateAnsweringPeerRequest{},
+		ops:        map[string]peerhub.CreateOfferingPeerRequest{},
+		offers:     make(chan peerhub.Offer, cfg.EventBuffer),
+		created:    make(chan peerhub.Offer, cfg.EventBuffer),
+		answers:    make(chan peerhub.Answer, cfg.EventBuffer),
+		candidates: make(chan peerhub.Candidate, cfg.EventBuffer),
+		failures:   make(chan *Failure, cfg.EventBuffer),
+		errs:       make(chan error, cfg.EventBuffer),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	ws, _, err := c.connect(ctx)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+
+	go c.run(ws)
+
+	return c, nil
+}
+
+// Close ends the session, event channels are closed once the client stops
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	ws := c.ws
+	c.ws = nil
+	c.mu.Unlock()
+
+	if ws == nil {
+		return nil
+	}
+	return ws.Close()
+}
+
+// Offers delivers offers made to answering peers of the client
+func (c *Client) Offers() <-chan peerhub.Offer {
+	return c.offers
+}
+
+// OffersCreated delivers offers made by offering peers of the client after they registered,
+// once answering peers they target register
+func (c *Client) OffersCreated() <-chan peerhub.Offer {
+	return c.created
+}
+
+// Answers delivers answers to offers made by offering peers of the client
+func (c *Client) Answers() <-chan peerhub.Answer {
+	return c.answers
+}
+
+// Candidates delivers ICE candidates sent to peers of the client, the last one of a peer has EndOfCandidates set
+func (c *Client) Candidates() <-chan peerhub.Candidate {
+	return c.candidates
+}
+
+// Failures delivers offers of or to peers of the client which failed, expired or were rejected
+func (c *Client) Failures() <-chan *Failure {
+	return c.failures
+}
+
+// Errors delivers errors the hub sent without a request waiting for them and errors of reconnecting
+func (c *Client) Errors() <-chan error {
+	return c.errs
+}
+
+// connect dials the hub, resuming the session if there is one, the session is started anew if the hub
+// can't resume it, it reports whether the session was resumed
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, bool, error) {
+	c.mu.Lock()
+	token, seq := c.token, c.seq
+	c.mu.Unlock()
+
+	if token != "" {
+		ws, err := c.dial(ctx, token, seq)
+		if err == nil {
+			return ws, true, nil
+		}
+		var hubErr *Error
+		if !errors.As(err, &hubErr) {
+			return nil, false, err
+		}
+	}
+
+	ws, err := c.dial(ctx, "", 0)
+	return ws, false, err
+}
+
+// dial opens a websocket and reads the session message the hub starts every websocket with
+func (c *Client) dial(ctx context.Context, token string, seq uint64) (*websocket.Conn, error) {
+	u, err := url.Parse(c.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		q := u.Query()
+		q.Set("resume", token)
+		q.Set("seq", strconv.FormatUint(seq, 10))
+		u.RawQuery = q.Encode()
+	}
+
+	ws, _, err := c.cfg.Dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message{}
+	if err := ws.ReadJSON(&msg); err != nil {
+		ws.Close()
+		return nil, err
+	}
+
+	switch msg.Type {
+	case messageTypeSession:
+		session, err := decode[sessionData](msg)
+		if err != nil {
+			ws.Close()
+			return nil, err
+		}
+		c.mu.Lock()
+		c.ws = ws
+		if session.Token != c.token {
+			c.token = session.Token
+			c.seq = 0
+		}
+		c.mu.Unlock()
+		return ws, nil
+	case messageTypeError:
+		ws.Close()
+		return nil, decodeError(msg)
+	}
+
+	ws.Close()
+	return nil, ErrNoSession
+}
+
+// run reads the websocket and reconnects once it drops, until the client is closed
+func (c *Client) run(ws *websocket.Conn) {
+	defer func() {
+		// emitters stop once the client is closed
+		c.emitters.Wait()
+		c.closeEvents()
+	}()
+
+	for {
+		err := c.read(ws)
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.emitError(fmt.Errorf("connection to the hub lost: %w", err))
+
+		if ws = c.reconnect(); ws == nil {
+			return
+		}
+	}
+}
+
+// reconnect retries connecting until it succeeds or the client is closed, in which case it returns nil
+func (c *Client) reconnect() *websocket.Conn {
+	c.mu.Lock()
+	c.ws = nil
+	c.mu.Unlock()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+		case <-time.After(c.cfg.ReconnectInterval):
+		}
+
+		ws, resumed, err := c.connect(c.ctx)
+		if err != nil {
+			c.emitError(fmt.Errorf("error reconnecting: %w", err))
+			continue
+		}
+		if c.ctx.Err() != nil {
+			ws.Close()
+			return nil
+		}
+
+		if !resumed {
+			// replies are read by the caller, registering has to wait for them in the background
+			c.emitters.Add(1)
+			go func() {
+				defer c.emitters.Done()
+				c.register()
+			}()
+		}
+		return ws
+	}
+}
+
+// register registers peers of the client again in a new session
+func (c *Client) register() {
+	c.mu.Lock()
+	aps := make([]peerhub.CreateAnsweringPeerRequest, 0, len(c.aps))
+	for _, req := range c.aps {
+		aps = append(aps, req)
+	}
+	ops := make([]peerhub.CreateOfferingPeerRequest, 0, len(c.ops))
+	for _, req := range c.ops {
+		ops = append(ops, req)
+	}
+	c.mu.Unlock()
+
+	for _, req := range aps {
+		if err := c.RegisterAnsweringPeer(c.ctx, req); err != nil {
+			c.emitError(fmt.Errorf("error registering answering peer %s again: %w", req.Name, err))
+		}
+	}
+
+	for _, req := range ops {
+		offer, err := c.Offer(c.ctx, req)
+		var failure *Failure
+		switch {
+		case errors.Is(err, ErrOfferPending):
+		case errors.As(err, &failure):
+			emit(c, c.failures, failure)
+		case err != nil:
+			c.emitError(fmt.Errorf("error registering offering peer %s again: %w", req.Name, err))
+		default:
+			emit(c, c.created, offer)
+		}
+	}
+}
+
+func (c *Client) read(ws *websocket.Conn) error {
+	for {
+		msg := message{}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		if msg.Seq > c.seq {
+			c.seq = msg.Seq
+		}
+		cl, ok := c.pending[msg.Conv]
+		var reply chan message
+		switch {
+		case !ok:
+		case msg.Type == messageTypeAck || msg.Type == messageTypeError:
+			delete(c.pending, msg.Conv)
+			reply = cl.done
+		case cl.take(msg.Type):
+			reply = cl.result
+		}
+		c.mu.Unlock()
+
+		if reply != nil {
+			reply <- msg
+			continue
+		}
+		if msg.Type == messageTypeAck {
+			// acks of requests nobody waits for anymore
+			continue
+		}
+
+		if err := c.dispatch(msg); err != nil {
+			c.emitError(err)
+		}
+	}
+}
+
+// dispatch delivers a message nobody waits for to its event channel
+func (c *Client) dispatch(msg message) error {
+	switch msg.Type {
+	case messageTypeOffer:
+		offer, err := decode[peerhub.Offer](msg)
+		if err != nil {
+			return err
+		}
+		emit(c, c.offers, offer)
+	case messageTypeOfferCreated:
+		offer, err := decode[peerhub.Offer](msg)
+		if err != nil {
+			return err
+		}
+		emit(c, c.created, offer)
+	case messageTypeOfferAnswer, messageTypeAnswer:
+		answer, err := decode[peerhub.Answer](msg)
+		if err != nil {
+			return err
+		}
+		emit(c, c.answers, answer)
+	case messageTypeICECandidate, messageTypeEndOfCandidates:
+		cand, err := decode[peerhub.Candidate](msg)
+		if err != nil {
+			return err
+		}
+		emit(c, c.candidates, cand)
+	case messageTypeOfferFailed, messageTypeOfferExpired, messageTypeAnsweringPeerDisconnected,
+		messageTypeDealAnswerRejected, messageTypeDealAnswerError:
+		failure, err := decodeFailure(msg)
+		if err != nil {
+			return err
+		}
+		emit(c, c.failures, failure)
+	case messageTypeError:
+		return decodeError(msg)
+	}
+
+	return nil
+}
+
+// emit waits until the event is taken from the channel or the client is closed
+func emit[T any](c *Client, ch chan T, v T) {
+	select {
+	case ch <- v:
+	case <-c.ctx.Done():
+	}
+}
+
+func (c *Client) emitError(err error) {
+	emit(c, c.errs, err)
+}
+
+func (c *Client) closeEvents() {
+	close(c.offers)
+	close(c.created)
+	close(c.answers)
+	close(c.candidates)
+	close(c.failures)
+	close(c.errs)
+}
+
+// send writes a message to the hub
+func (c *Client) send(mt messageType, conv string, data any) error {
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	if ws == nil {
+		return ErrNotConnected
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return ws.WriteJSON(outMessage{Type: mt, Conv: conv, Data: data})
+}
+
+// request sends a message and waits until the hub acks it or replies with an error, it returns the first
+// reply of one of the wanted types, other replies to the request are delivered as events
+func (c *Client) request(ctx context.Context, mt messageType, data any, want ...messageType) (message, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	conv := uuid.NewString()
+	cl := &call{want: want, result: make(chan message, 1), done: make(chan message, 1)}
+
+	c.mu.Lock()
+	c.pending[conv] = cl
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, conv)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(mt, conv, data); err != nil {
+		return message{}, false, err
+	}
+
+	select {
+	case msg := <-cl.done:
+		if msg.Type == messageTypeError {
+			return msg, false, decodeError(msg)
+		}
+	case <-ctx.Done():
+		return message{}, false, ctx.Err()
+	case <-c.ctx.Done():
+		return message{}, false, ErrClosed
+	}
+
+	// the result is read before the ack so it's already there
+	select {
+	case msg := <-cl.result:
+		return msg, true, nil
+	default:
+		return message{}, false, nil
+	}
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/gorilla/websocket"
+)
+
+// fakeHub speaks the websocket protocol of the hub, it acks every request unless reply answers it
+type fakeHub struct {
+	t        *testing.T
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+	// reply writes replies to a request and reports whether it did
+	reply func(ws *websocket.Conn, msg message) bool
+
+	mu       sync.Mutex
+	conns    []*websocket.Conn
+	sessions int
+	requests []message
+}
+
+func newFakeHub(t *testing.T) *fakeHub {
+	t.Helper()
+	h := &fakeHub{t: t}
+	h.srv = httptest.NewServer(http.HandlerFunc(h.serve))
+	t.Cleanup(h.srv.Close)
+	return h
+}
+
+func (h *fakeHub) url() string {
+	return "ws" + strings.TrimPrefix(h.srv.URL, "http")
+}
+
+func (h *fakeHub) dial(t *testing.T) *Client {
+	t.Helper()
+	c, err := Dial(context.Background(), Config{URL: h.url(), Timeout: time.Second, ReconnectInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func (h *fakeHub) serve(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	h.mu.Lock()
+	h.conns = append(h.conns, ws)
+	h.sessions++
+	token := "session" + strconv.Itoa(h.sessions)
+	h.mu.Unlock()
+
+	if r.URL.Query().Get("resume") != "" {
+		// sessions never survive a dropped connection
+		write(ws, message{Type: messageTypeError}, peerhub.ErrorData{Code: peerhub.CodeSessionNotFound, Message: "session not found"})
+		return
+	}
+	write(ws, message{Type: messageTypeSession}, sessionData{Token: token})
+
+	for {
+		msg := message{}
+		if err := ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		h.mu.Lock()
+		h.requests = append(h.requests, msg)
+		reply := h.reply
+		h.mu.Unlock()
+
+		if reply == nil || !reply(ws, msg) {
+			write(ws, message{Type: messageTypeAck, Conv: msg.Conv}, nil)
+		}
+	}
+}
+
+// dropConns closes all websockets of the hub
+func (h *fakeHub) dropConns() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, ws := range h.conns {
+		ws.Close()
+	}
+	h.conns = nil
+}
+
+// requestsOf returns requests of the type the hub received
+func (h *fakeHub) requestsOf(mt messageType) []message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msgs := []message{}
+	for _, msg := range h.requests {
+		if msg.Type == mt {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func write(ws *websocket.Conn, msg message, data any) {
+	msg.Data, _ = json.Marshal(data)
+	ws.WriteJSON(msg)
+}
+
+func TestRequestReplies(t *testing.T) {
+	h := newFakeHub(t)
+	offer := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	h.reply = func(ws *websocket.Conn, msg message) bool {
+		switch msg.Type {
+		case messageTypeCreateAnsweringPeer:
+			write(ws, message{Type: messageTypeError, Conv: msg.Conv}, peerhub.NewErrorData(peerhub.ErrAnsweringPeerAlreadyExists))
+			return true
+		case messageTypeCreateOfferingPeer:
+			write(ws, message{Type: messageTypeOfferCreated, Conv: msg.Conv}, offer)
+		}
+		return false
+	}
+	c := h.dial(t)
+
+	err := c.RegisterAnsweringPeer(context.Background(), peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	if !errors.Is(err, peerhub.ErrAnsweringPeerAlreadyExists) {
+		t.Errorf("registering returned %v, want the hub's error", err)
+	}
+
+	created, err := c.Offer(context.Background(), peerhub.CreateOfferingPeerRequest{Name: "op"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != offer.ID {
+		t.Errorf("offer returned %+v, want %+v", created, offer)
+	}
+}
+
+func TestPushedMessagesAreEmitted(t *testing.T) {
+	h := newFakeHub(t)
+	offer := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	h.reply = func(ws *websocket.Conn, msg message) bool {
+		write(ws, message{Type: messageTypeAck, Conv: msg.Conv}, nil)
+		// pushed by the hub later, without the conv of a request
+		write(ws, message{Type: messageTypeOffer, Seq: 1}, offer)
+		return true
+	}
+	c := h.dial(t)
+
+	if err := c.RegisterAnsweringPeer(context.Background(), peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-c.Offers():
+		if got.ID != offer.ID {
+			t.Errorf("emitted %+v, want %+v", got, offer)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pushed offer wasn't emitted")
+	}
+}
+
+func TestPeersAreRegisteredAgainInNewSession(t *testing.T) {
+	h := newFakeHub(t)
+	c := h.dial(t)
+
+	if err := c.RegisterAnsweringPeer(context.Background(), peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	h.dropConns()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(h.requestsOf(messageTypeCreateAnsweringPeer)) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("peer wasn't registered again after the session was lost")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseWhileRegisteringAgain(t *testing.T) {
+	h := newFakeHub(t)
+	c := h.dial(t)
+
+	for _, name := range []string{"ap1", "ap2", "ap3"} {
+		if err := c.RegisterAnsweringPeer(context.Background(), peerhub.CreateAnsweringPeerRequest{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// registering again fails and emits errors nobody reads
+	h.mu.Lock()
+	h.reply = func(ws *websocket.Conn, msg message) bool {
+		write(ws, message{Type: messageTypeError, Conv: msg.Conv}, peerhub.NewErrorData(errors.New("unavailable")))
+		return true
+	}
+	h.mu.Unlock()
+	h.dropConns()
+
+	for len(h.requestsOf(messageTypeCreateAnsweringPeer)) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	c.Close()
+
+	// every channel is closed once the client stops
+	timeout := time.After(2 * time.Second)
+	for range c.Errors() {
+		select {
+		case <-timeout:
+			t.Fatal("errors weren't closed")
+		default:
+		}
+	}
+}
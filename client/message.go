@@ -0,0 +1,163 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+)
+
+type messageType string
+
+const (
+	// Outbound
+	messageTypeCreateOfferingPeer  messageType = "create_offering_peer"
+	messageTypeCreateAnsweringPeer messageType = "create_answering_peer"
+	messageTypeDeleteOfferingPeer  messageType = "delete_offering_peer"
+	messageTypeDeleteAnsweringPeer messageType = "delete_answering_peer"
+	messageTypeOfferAnswer         messageType = "offer_answer"
+	messageTypeDealAnswerRejected  messageType = "deal_answer_rejected"
+	messageTypeDealAnswerError     messageType = "deal_answer_error"
+
+	// Outbound and inbound
+	messageTypeICECandidate    messageType = "ice_candidate"
+	messageTypeEndOfCandidates messageType = "end_of_candidates"
+
+	// Inbound
+	messageTypeOffer                     messageType = "offer"
+	messageTypeOfferCreated              messageType = "offer_created"
+	messageTypeOfferFailed               messageType = "offer_failed"
+	messageTypeOfferExpired              messageType = "offer_expired"
+	messageTypeAnswer                    messageType = "answer"
+	messageTypeAnsweringPeerDisconnected messageType = "answering_peer_disconnected"
+	messageTypeSession                   messageType = "session"
+	messageTypeAck                       messageType = "ack"
+	messageTypeError                     messageType = "error"
+)
+
+// message is the envelope of every message exchanged with the hub, replies carry the conv of their request,
+// every request ends with an ack or error reply
+type message struct {
+	Type messageType     `json:"type"`
+	ID   string          `json:"id"`
+	Conv string          `json:"conv"`
+	Data json.RawMessage `json:"data"`
+	Seq  uint64          `json:"seq,omitempty"`
+}
+
+type outMessage struct {
+	Type messageType `json:"type"`
+	Conv string      `json:"conv"`
+	Data any         `json:"data"`
+}
+
+func decode[T any](msg message) (T, error) {
+	var v T
+	if err := json.Unmarshal(msg.Data, &v); err != nil {
+		return v, fmt.Errorf("error decoding %s message: %w", msg.Type, err)
+	}
+	return v, nil
+}
+
+type sessionData struct {
+	Token string `json:"token"`
+	Seq   uint64 `json:"seq"`
+}
+
+// Error is an error reply of the hub, Code names the error, Details hold optional fields specific to the code
+type Error struct {
+	Code    peerhub.ErrorCode
+	Message string
+	Details map[string]any
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches errors of the hub by their code, so errors.Is(err, peerhub.ErrOfferNotFound) works with replies
+func (e *Error) Is(target error) bool {
+	err, ok := peerhub.ErrorOf(e.Code)
+	return ok && err == target
+}
+
+func decodeError(msg message) error {
+	data, err := decode[peerhub.ErrorData](msg)
+	if err != nil {
+		return err
+	}
+	return &Error{Code: data.Code, Message: data.Message, Details: data.Details}
+}
+
+// Failure reports an offer that won't lead to a connection, either because it couldn't be made, expired,
+// its answering peer disconnected or the answering peer rejected it
+type Failure struct {
+	// Type is the type of the message reporting the failure
+	Type          string
+	OfferID       string
+	OfferingPeer  string
+	AnsweringPeer string
+	// Code names the error of offers which couldn't be made
+	Code peerhub.ErrorCode
+	// Reason is set for rejected offers, Message for rejected offers and offers which couldn't be made
+	Reason  peerhub.RejectReason
+	Message string
+	// RetryAfter is set if the offering peer is blocked from offering to the answering peer until then
+	RetryAfter *time.Time
+}
+
+func (f *Failure) Error() string {
+	msg := fmt.Sprintf("offer of %s to %s failed: %s", f.OfferingPeer, f.AnsweringPeer, f.Type)
+	if f.Message != "" {
+		msg += ": " + f.Message
+	}
+	return msg
+}
+
+// Is matches errors of the hub by the code of the failure
+func (f *Failure) Is(target error) bool {
+	err, ok := peerhub.ErrorOf(f.Code)
+	return ok && err == target
+}
+
+// failureData holds fields of every message reporting a failure, offers name their ID id and the rest offerid
+type failureData struct {
+	ID            string               `json:"id"`
+	OfferID       string               `json:"offerid"`
+	OfferingPeer  string               `json:"offeringpeer"`
+	AnsweringPeer string               `json:"answeringpeer"`
+	Reason        peerhub.RejectReason `json:"reason"`
+	Message       string               `json:"message"`
+	RetryAfter    *time.Time           `json:"retryafter"`
+	// Error is the reason of offers which couldn't be made
+	Error *peerhub.ErrorData `json:"error"`
+}
+
+func decodeFailure(msg message) (*Failure, error) {
+	data, err := decode[failureData](msg)
+	if err != nil {
+		return nil, err
+	}
+
+	offerID := data.OfferID
+	if offerID == "" {
+		offerID = data.ID
+	}
+
+	failure := &Failure{
+		Type:          string(msg.Type),
+		OfferID:       offerID,
+		OfferingPeer:  data.OfferingPeer,
+		AnsweringPeer: data.AnsweringPeer,
+		Reason:        data.Reason,
+		Message:       data.Message,
+		RetryAfter:    data.RetryAfter,
+	}
+	if data.Error != nil {
+		failure.Code = data.Error.Code
+		failure.Message = data.Error.Message
+	}
+
+	return failure, nil
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestDecodeFailedOffer(t *testing.T) {
+	data, err := json.Marshal(peerhub.NewFailedOffer("op", "ap", peerhub.ErrInvalidAccessKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure, err := decodeFailure(message{Type: messageTypeOfferFailed, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure.OfferingPeer != "op" || failure.AnsweringPeer != "ap" || failure.Code != peerhub.CodeInvalidAccessKey {
+		t.Errorf("decoded %+v", failure)
+	}
+	if !errors.Is(failure, peerhub.ErrInvalidAccessKey) {
+		t.Errorf("failure %v doesn't match the error of the hub", failure)
+	}
+}
+
+func TestDecodeRejectedOffer(t *testing.T) {
+	data, err := json.Marshal(peerhub.RejectedOffer{OfferID: "o", Reason: peerhub.RejectReasonDeclined, Message: "busy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failure, err := decodeFailure(message{Type: messageTypeDealAnswerRejected, Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failure.OfferID != "o" || failure.Reason != peerhub.RejectReasonDeclined || failure.Message != "busy" || failure.Code != "" {
+		t.Errorf("decoded %+v", failure)
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"github.com/H3Cki/peerhub"
+)
+
+var errNoResult = errors.New("hub acked the request without its result")
+
+// RegisterAnsweringPeer registers an answering peer, offers made to it are delivered over Offers,
+// the peer is registered again if the session is lost
+func (c *Client) RegisterAnsweringPeer(ctx context.Context, req peerhub.CreateAnsweringPeerRequest) error {
+	if _, _, err := c.request(ctx, messageTypeCreateAnsweringPeer, req); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.aps[req.Name] = req
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Offer registers an offering peer and returns the offer it made to its target, a *Failure is returned
+// if the offer couldn't be made and ErrOfferPending if its target isn't registered yet,
+// the peer is registered again if the session is lost
+func (c *Client) Offer(ctx context.Context, req peerhub.CreateOfferingPeerRequest) (peerhub.Offer, error) {
+	msg, ok, err := c.request(ctx, messageTypeCreateOfferingPeer, req, messageTypeOfferCreated, messageTypeOfferFailed)
+	if err != nil {
+		return peerhub.Offer{}, err
+	}
+
+	c.mu.Lock()
+	c.ops[req.Name] = req
+	c.mu.Unlock()
+
+	if !ok {
+		return peerhub.Offer{}, ErrOfferPending
+	}
+
+	if msg.Type == messageTypeOfferFailed {
+		failure, err := decodeFailure(msg)
+		if err != nil {
+			return peerhub.Offer{}, err
+		}
+		return peerhub.Offer{}, failure
+	}
+
+	return decode[peerhub.Offer](msg)
+}
+
+// Answer answers an offer made to an answering peer of the client, req.PeerName names that peer
+func (c *Client) Answer(ctx context.Context, req peerhub.CreateAnswerRequest) (peerhub.Answer, error) {
+	msg, ok, err := c.request(ctx, messageTypeOfferAnswer, req, messageTypeAnswer)
+	if err != nil {
+		return peerhub.Answer{}, err
+	}
+	if !ok {
+		return peerhub.Answer{}, errNoResult
+	}
+	return decode[peerhub.Answer](msg)
+}
+
+// Reject rejects an offer made to an answering peer of the client, req.PeerName names that peer,
+// RejectReasonError reports that the peer failed to answer it
+func (c *Client) Reject(ctx context.Context, req peerhub.RejectOfferRequest) error {
+	mt := messageTypeDealAnswerRejected
+	if req.Reason == peerhub.RejectReasonError {
+		mt = messageTypeDealAnswerError
+	}
+	_, _, err := c.request(ctx, mt, req)
+	return err
+}
+
+// SendCandidate sends an ICE candidate of a peer of the client to the other peer of the offer
+func (c *Client) SendCandidate(ctx context.Context, req peerhub.CreateCandidateRequest) error {
+	mt := messageTypeICECandidate
+	if req.EndOfCandidates {
+		mt = messageTypeEndOfCandidates
+	}
+	_, _, err := c.request(ctx, mt, req)
+	return err
+}
+
+// DeleteAnsweringPeer deletes an answering peer of the client
+func (c *Client) DeleteAnsweringPeer(ctx context.Context, req peerhub.DeleteAnsweringPeerRequest) error {
+	if _, _, err := c.request(ctx, messageTypeDeleteAnsweringPeer, req); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.aps, req.Name)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// DeleteOfferingPeer deletes an offering peer of the client
+func (c *Client) DeleteOfferingPeer(ctx context.Context, req peerhub.DeleteOfferingPeerRequest) error {
+	if _, _, err := c.request(ctx, messageTypeDeleteOfferingPeer, req); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.ops, req.Name)
+	c.mu.Unlock()
+
+	return nil
+}
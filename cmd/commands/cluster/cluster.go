@@ -0,0 +1,361 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/google/uuid"
+)
+
+// secretHeader carries the cluster secret of node-to-node requests
+const secretHeader = "X-Cluster-Secret"
+
+var (
+	ErrNodeUnavailable = errors.New("node unavailable")
+	ErrInvalidSecret   = errors.New("invalid cluster secret")
+)
+
+type Config struct {
+	// Self is the address other nodes reach this node at, host:port is reached over http,
+	// https://host:port over https (through a proxy terminating TLS in front of the node)
+	Self string
+	// Nodes are addresses of all nodes of the cluster, Self may be included
+	Nodes []string
+	// Secret authenticates node-to-node requests, it's sent in plain text to nodes reached over http,
+	// so such clusters have to run on a trusted network
+	Secret string
+	// HealthInterval is the interval of checking which nodes are up
+	HealthInterval time.Duration
+}
+
+// Node is a member of a cluster of hubs, it implements mailbox.Relay: every peer name is owned by one node
+// (picked by consistent hashing) which records the node holding the peer's mailbox, messages for the peer
+// are routed through the owner to the holder
+type Node struct {
+	mu     sync.Mutex
+	cfg    Config
+	client *http.Client
+	// id tells restarts of this node apart, it's reported by health checks
+	id    string
+	alive []string
+	// ids are ids of alive nodes as of the last health check
+	ids  map[string]string
+	ring *ring
+	// unclaimed are owners which failed to take claims of local mailboxes, the claims are re-asserted
+	// on the next health check
+	unclaimed map[string]bool
+	// claims are mailbox holders of peers owned by this node
+	claims map[mailbox.Peer]string
+	// local are peers whose mailboxes this node holds
+	local   map[mailbox.Peer]bool
+	receive func(mailbox.Envelope)
+}
+
+func NewNode(cfg Config) *Node {
+	if !slices.Contains(cfg.Nodes, cfg.Self) {
+		cfg.Nodes = append(cfg.Nodes, cfg.Self)
+	}
+
+	return &Node{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		id:        uuid.NewString(),
+		alive:     []string{cfg.Self},
+		ids:       map[string]string{},
+		ring:      newRing([]string{cfg.Self}),
+		unclaimed: map[string]bool{},
+		claims:    map[mailbox.Peer]string{},
+		local:     map[mailbox.Peer]bool{},
+	}
+}
+
+// Run checks health of the nodes until ctx is done, the ring is rebuilt whenever a node joins or leaves,
+// claims of local mailboxes are re-asserted at owners which changed, including owners which restarted
+// between checks
+func (n *Node) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.cfg.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		n.checkNodes()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkNodes pings all nodes at once and rebalances claims if the ring changed or an owner restarted
+func (n *Node) checkNodes() {
+	ids := make([]string, len(n.cfg.Nodes))
+	wg := sync.WaitGroup{}
+	for i, node := range n.cfg.Nodes {
+		if node == n.cfg.Self {
+			ids[i] = n.id
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i] = n.ping(node)
+		}()
+	}
+	wg.Wait()
+
+	alive := []string{}
+	nodeIDs := map[string]string{}
+	for i, node := range n.cfg.Nodes {
+		if ids[i] != "" {
+			alive = append(alive, node)
+			nodeIDs[node] = ids[i]
+		}
+	}
+	slices.Sort(alive)
+
+	n.mu.Lock()
+	changed := !slices.Equal(alive, n.alive)
+	if changed {
+		n.alive = alive
+		n.ring = newRing(alive)
+	}
+	// restarted owners lost the claims they recorded
+	owners := map[string]bool{}
+	for node, id := range nodeIDs {
+		if prev, ok := n.ids[node]; (ok && prev != id) || n.unclaimed[node] {
+			owners[node] = true
+		}
+	}
+	n.ids = nodeIDs
+	n.unclaimed = map[string]bool{}
+	n.mu.Unlock()
+
+	if changed {
+		fmt.Printf("cluster nodes changed: %v\n", alive)
+	}
+	if changed || len(owners) > 0 {
+		n.rebalance(changed, owners)
+	}
+}
+
+// rebalance drops claims this node no longer owns or whose holder left and re-asserts claims of local mailboxes
+// at their current owners, all of them if the ring changed and those at the given owners otherwise,
+// claims are sent in one request per owner
+func (n *Node) rebalance(all bool, owners map[string]bool) {
+	n.mu.Lock()
+	for p, holder := range n.claims {
+		if n.ring.owner(p.Name) != n.cfg.Self || !slices.Contains(n.alive, holder) {
+			delete(n.claims, p)
+		}
+	}
+	batches := map[string][]mailbox.Peer{}
+	for p := range n.local {
+		owner := n.ring.owner(p.Name)
+		if all || owners[owner] {
+			batches[owner] = append(batches[owner], p)
+		}
+	}
+	n.mu.Unlock()
+
+	for owner, peers := range batches {
+		if err := n.claimAt(owner, peers); err != nil {
+			fmt.Println(fmt.Errorf("error claiming %d mailboxes: %w", len(peers), err))
+		}
+	}
+}
+
+func (n *Node) owner(name string) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ring.owner(name)
+}
+
+func (n *Node) Claim(p mailbox.Peer) error {
+	n.mu.Lock()
+	n.local[p] = true
+	n.mu.Unlock()
+
+	return n.claim(p)
+}
+
+func (n *Node) claim(p mailbox.Peer) error {
+	return n.claimAt(n.owner(p.Name), []mailbox.Peer{p})
+}
+
+// claimAt records this node as the holder of the peers' mailboxes at their owner, an owner which fails
+// gets the claims again on the next health check
+func (n *Node) claimAt(owner string, peers []mailbox.Peer) error {
+	req := claimsRequest{Peers: peers, Holder: n.cfg.Self}
+	if owner == n.cfg.Self {
+		n.recordClaims(req)
+		return nil
+	}
+
+	err := n.post(owner, "/cluster/claims", req, nil)
+	if err != nil {
+		n.mu.Lock()
+		n.unclaimed[owner] = true
+		n.mu.Unlock()
+	}
+	return err
+}
+
+func (n *Node) Release(p mailbox.Peer) error {
+	n.mu.Lock()
+	delete(n.local, p)
+	n.mu.Unlock()
+
+	owner := n.owner(p.Name)
+	if owner == n.cfg.Self {
+		n.releaseClaim(claimRequest{Peer: p, Holder: n.cfg.Self})
+		return nil
+	}
+	return n.post(owner, "/cluster/release", claimRequest{Peer: p, Holder: n.cfg.Self}, nil)
+}
+
+func (n *Node) Forward(env mailbox.Envelope) (bool, error) {
+	owner := n.owner(env.To.Name)
+	if owner == n.cfg.Self {
+		return n.route(env)
+	}
+
+	resp := routeResponse{}
+	if err := n.post(owner, "/cluster/route", env, &resp); err != nil {
+		return false, err
+	}
+	return resp.Delivered, nil
+}
+
+func (n *Node) Listen(fn func(mailbox.Envelope)) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.receive = fn
+	return nil
+}
+
+func (n *Node) recordClaims(req claimsRequest) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, p := range req.Peers {
+		n.claims[p] = req.Holder
+	}
+}
+
+// releaseClaim removes the claim unless the peer's mailbox moved to another node in the meantime
+func (n *Node) releaseClaim(req claimRequest) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.claims[req.Peer] == req.Holder {
+		delete(n.claims, req.Peer)
+	}
+}
+
+// route delivers the envelope to the node holding recipient's mailbox, the recipient must be owned by this node
+func (n *Node) route(env mailbox.Envelope) (bool, error) {
+	n.mu.Lock()
+	holder, ok := n.claims[env.To]
+	receive := n.receive
+	n.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+
+	if holder == n.cfg.Self {
+		if receive == nil {
+			return false, nil
+		}
+		receive(env)
+		return true, nil
+	}
+
+	if err := n.post(holder, "/cluster/deliver", env, nil); err != nil {
+		// the holder is gone, the message gets queued instead
+		n.releaseClaim(claimRequest{Peer: env.To, Holder: holder})
+		fmt.Println(err)
+		return false, nil
+	}
+	return true, nil
+}
+
+func (n *Node) deliver(env mailbox.Envelope) {
+	n.mu.Lock()
+	receive := n.receive
+	n.mu.Unlock()
+
+	if receive != nil {
+		receive(env)
+	}
+}
+
+// ping returns the id of the node or an empty string if it's down
+func (n *Node) ping(node string) string {
+	req, err := http.NewRequest(http.MethodGet, nodeURL(node, "/cluster/health"), nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set(secretHeader, n.cfg.Secret)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	health := healthResponse{}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&health) != nil {
+		return ""
+	}
+	return health.ID
+}
+
+// nodeURL returns the url of the path at the node, nodes given without a scheme are reached over http
+func nodeURL(node, path string) string {
+	if strings.HasPrefix(node, "http://") || strings.HasPrefix(node, "https://") {
+		return node + path
+	}
+	return "http://" + node + path
+}
+
+func (n *Node) post(node, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, nodeURL(node, path), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(secretHeader, n.cfg.Secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrNodeUnavailable, node, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s responded with %s", ErrNodeUnavailable, node, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (n *Node) authorized(r *http.Request) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(n.cfg.Secret)) == 1
+}
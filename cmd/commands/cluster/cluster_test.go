@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/google/uuid"
+)
+
+// startNodes starts a node per address of the cluster behind a test server
+func startNodes(t *testing.T, count int) []*Node {
+	t.Helper()
+
+	muxes := make([]*http.ServeMux, count)
+	addrs := make([]string, count)
+	for i := range count {
+		muxes[i] = http.NewServeMux()
+		srv := httptest.NewServer(muxes[i])
+		t.Cleanup(srv.Close)
+		addrs[i] = strings.TrimPrefix(srv.URL, "http://")
+	}
+
+	nodes := make([]*Node, count)
+	for i := range count {
+		nodes[i] = NewNode(Config{Self: addrs[i], Nodes: addrs, Secret: "secret", HealthInterval: time.Second})
+		nodes[i].RegisterHandlers(muxes[i])
+	}
+	for _, n := range nodes {
+		n.checkNodes()
+	}
+	return nodes
+}
+
+// peerOwnedBy returns a peer whose name the ring assigns to the node
+func peerOwnedBy(t *testing.T, n *Node) mailbox.Peer {
+	t.Helper()
+	return peersOwnedBy(t, n, 1)[0]
+}
+
+// peersOwnedBy returns count peers whose names the ring assigns to the node
+func peersOwnedBy(t *testing.T, n *Node, count int) []mailbox.Peer {
+	t.Helper()
+	peers := []mailbox.Peer{}
+	for i := 0; i < 1000 && len(peers) < count; i++ {
+		name := "peer" + strconv.Itoa(i)
+		if n.owner(name) == n.cfg.Self {
+			peers = append(peers, mailbox.Peer{Role: mailbox.Answering, Name: name})
+		}
+	}
+	if len(peers) < count {
+		t.Fatalf("%d peers are owned by the node, want %d", len(peers), count)
+	}
+	return peers
+}
+
+// restart makes the node forget its claims and report a new id, as if it restarted
+func restart(n *Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.claims = map[mailbox.Peer]string{}
+	n.id = uuid.NewString()
+}
+
+// claimCounter counts claim requests sent through it
+type claimCounter struct {
+	requests atomic.Int64
+}
+
+func (c *claimCounter) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Path == "/cluster/claims" {
+		c.requests.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestForwardThroughOwner(t *testing.T) {
+	nodes := startNodes(t, 2)
+	owner, holder := nodes[0], nodes[1]
+	p := peerOwnedBy(t, owner)
+
+	received := make(chan mailbox.Envelope, 1)
+	holder.Listen(func(env mailbox.Envelope) { received <- env })
+
+	if err := holder.Claim(p); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := owner.Forward(mailbox.Envelope{To: p, Type: "mail"})
+	if !ok || err != nil {
+		t.Fatalf("forward returned %v %v", ok, err)
+	}
+	if env := <-received; env.Type != "mail" {
+		t.Errorf("received %+v", env)
+	}
+
+	if err := holder.Release(p); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := owner.Forward(mailbox.Envelope{To: p}); ok {
+		t.Error("forwarded to a released mailbox")
+	}
+}
+
+func TestClaimsAreReassertedAfterOwnerRestart(t *testing.T) {
+	nodes := startNodes(t, 2)
+	owner, holder := nodes[0], nodes[1]
+	p := peerOwnedBy(t, owner)
+
+	holder.Listen(func(mailbox.Envelope) {})
+	if err := holder.Claim(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner restarted between two health checks of the holder, the ring didn't change
+	restart(owner)
+	if ok, _ := owner.Forward(mailbox.Envelope{To: p}); ok {
+		t.Fatal("restarted owner remembers the claim")
+	}
+
+	holder.checkNodes()
+	if ok, err := owner.Forward(mailbox.Envelope{To: p}); !ok || err != nil {
+		t.Errorf("claim wasn't re-asserted, forward returned %v %v", ok, err)
+	}
+}
+
+func TestClaimsAreReassertedOnlyAfterChanges(t *testing.T) {
+	nodes := startNodes(t, 2)
+	owner, holder := nodes[0], nodes[1]
+	peers := peersOwnedBy(t, owner, 3)
+
+	holder.Listen(func(mailbox.Envelope) {})
+	for _, p := range peers {
+		if err := holder.Claim(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counter := &claimCounter{}
+	holder.client.Transport = counter
+	holder.checkNodes()
+	holder.checkNodes()
+	if n := counter.requests.Load(); n != 0 {
+		t.Errorf("%d claim requests were sent while nothing changed, want 0", n)
+	}
+
+	// claims are sent to the restarted owner in one request
+	restart(owner)
+	holder.checkNodes()
+	if n := counter.requests.Load(); n != 1 {
+		t.Errorf("%d claim requests were sent to the restarted owner, want 1", n)
+	}
+	for _, p := range peers {
+		if ok, err := owner.Forward(mailbox.Envelope{To: p}); !ok || err != nil {
+			t.Errorf("claim of %s wasn't re-asserted, forward returned %v %v", p.Name, ok, err)
+		}
+	}
+}
+
+func TestNodeURL(t *testing.T) {
+	tests := []struct {
+		node string
+		want string
+	}{
+		{node: "10.0.0.1:8080", want: "http://10.0.0.1:8080/cluster/health"},
+		{node: "http://10.0.0.1:8080", want: "http://10.0.0.1:8080/cluster/health"},
+		{node: "https://hub.example.com", want: "https://hub.example.com/cluster/health"},
+	}
+
+	for _, tt := range tests {
+		if got := nodeURL(tt.node, "/cluster/health"); got != tt.want {
+			t.Errorf("url of %s is %s, want %s", tt.node, got, tt.want)
+		}
+	}
+}
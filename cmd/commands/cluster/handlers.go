@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"net/http"
+
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+type claimRequest struct {
+	Peer   mailbox.Peer `json:"peer"`
+	Holder string       `json:"holder"`
+}
+
+type claimsRequest struct {
+	Peers  []mailbox.Peer `json:"peers"`
+	Holder string         `json:"holder"`
+}
+
+type healthResponse struct {
+	ID string `json:"id"`
+}
+
+type routeResponse struct {
+	Delivered bool `json:"delivered"`
+}
+
+// RegisterHandlers registers the node-to-node endpoints
+func (n *Node) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /cluster/health", n.authorize(n.health))
+	mux.HandleFunc("POST /cluster/claims", n.authorize(n.handleClaims))
+	mux.HandleFunc("POST /cluster/release", n.authorize(n.handleRelease))
+	mux.HandleFunc("POST /cluster/route", n.authorize(n.handleRoute))
+	mux.HandleFunc("POST /cluster/deliver", n.authorize(n.handleDeliver))
+}
+
+func (n *Node) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !n.authorized(r) {
+			commands.WriteErrorStatus(w, http.StatusUnauthorized, ErrInvalidSecret)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (n *Node) health(w http.ResponseWriter, r *http.Request) {
+	commands.WriteJSON(w, http.StatusOK, healthResponse{ID: n.id})
+}
+
+func (n *Node) handleClaims(w http.ResponseWriter, r *http.Request) {
+	req := claimsRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	n.recordClaims(req)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *Node) handleRelease(w http.ResponseWriter, r *http.Request) {
+	req := claimRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	n.releaseClaim(req)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (n *Node) handleRoute(w http.ResponseWriter, r *http.Request) {
+	env := mailbox.Envelope{}
+	if err := commands.DecodeJSON(r.Body, &env); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	delivered, err := n.route(env)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	commands.WriteJSON(w, http.StatusOK, routeResponse{Delivered: delivered})
+}
+
+func (n *Node) handleDeliver(w http.ResponseWriter, r *http.Request) {
+	env := mailbox.Envelope{}
+	if err := commands.DecodeJSON(r.Body, &env); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	n.deliver(env)
+	w.WriteHeader(http.StatusOK)
+}
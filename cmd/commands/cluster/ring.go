@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points each node has on the ring, more points spread peers more evenly
+const virtualNodes = 64
+
+// ring assigns peer names to nodes with consistent hashing, so a node joining or leaving
+// moves only the names of the ring segments it takes over or gives up
+type ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+func newRing(nodes []string) *ring {
+	r := &ring{
+		points: []uint32{},
+		owners: map[uint32]string{},
+	}
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			p := hash(node + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.points = append(r.points, p)
+			r.owners[p] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the node responsible for the name
+func (r *ring) owner(name string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(name)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -1,35 +1,188 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 
 	"github.com/H3Cki/peerhub"
 )
 
-func AnsweringsHandler(h *peerhub.Hub) func(w http.ResponseWriter, r *http.Request) {
+// AnsweringsHandler lists answering peers of the hub followed by those listed by remotes
+func AnsweringsHandler(h *peerhub.Hub, remotes ...func(context.Context) []peerhub.AnsweringPeerPreview) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			WriteError(w, ErrMethodNotAllowed)
 			return
 		}
 
-		aps, err := h.GetAnsweringPeersPrevies()
+		aps, err := h.GetAnsweringPeersPrevies(r.Context())
 		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
-		b, err := json.Marshal(aps)
+		for _, remote := range remotes {
+			aps = append(aps, remote(r.Context())...)
+		}
+
+		WriteJSON(w, http.StatusOK, aps)
+	}
+}
+
+var (
+	// ErrInvalidRequest wraps errors of decoding requests, including bodies cut short
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+// DecodeJSON decodes a JSON request body, errors wrap ErrInvalidRequest
+func DecodeJSON(r io.Reader, v any) error {
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes JSON data of a request, errors wrap ErrInvalidRequest
+func UnmarshalJSON(data []byte, v any) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// MasterPassword reads the master password from the bearer Authorization header
+func MasterPassword(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// ErrorStatus maps an error returned by the hub to a response status
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidRequest),
+		errors.Is(err, peerhub.ErrInvalidPeerName),
+		errors.Is(err, peerhub.ErrTooManyAccessKeys):
+		return http.StatusBadRequest
+	case errors.Is(err, peerhub.ErrInvalidMasterPassword):
+		return http.StatusUnauthorized
+	case errors.Is(err, peerhub.ErrAdminDisabled),
+		errors.Is(err, peerhub.ErrInvalidAccessKey),
+		errors.Is(err, peerhub.ErrInvalidManagementKey),
+		errors.Is(err, peerhub.ErrPeerNotInOffer):
+		return http.StatusForbidden
+	case errors.Is(err, peerhub.ErrAnsweringPeerNotFound),
+		errors.Is(err, peerhub.ErrOfferingPeerNotFound),
+		errors.Is(err, peerhub.ErrOfferNotFound),
+		errors.Is(err, peerhub.ErrAnswerNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, peerhub.ErrAnsweringPeerAlreadyExists),
+		errors.Is(err, peerhub.ErrOfferingPeerAlreadyExists),
+		errors.Is(err, peerhub.ErrAnsweringPeerConflict),
+		errors.Is(err, peerhub.ErrOfferingPeerConflict),
+		errors.Is(err, peerhub.ErrOfferAlreadyAnswered):
+		return http.StatusConflict
+	case errors.Is(err, ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, peerhub.ErrOfferCooldown):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ErrorData returns the serializable form of an error returned by the hub, errors decoding requests
+// are CodeInvalidRequest
+func ErrorData(err error) peerhub.ErrorData {
+	if errors.Is(err, ErrInvalidRequest) {
+		return peerhub.ErrorData{Code: peerhub.CodeInvalidRequest, Message: err.Error()}
+	}
+	return peerhub.NewErrorData(err)
+}
+
+// statusCodes name errors without a code of their own by the status they are responded with
+var statusCodes = map[int]peerhub.ErrorCode{
+	http.StatusBadRequest:       peerhub.CodeInvalidRequest,
+	http.StatusUnauthorized:     peerhub.CodeUnauthorized,
+	http.StatusForbidden:        peerhub.CodeForbidden,
+	http.StatusNotFound:         peerhub.CodeNotFound,
+	http.StatusConflict:         peerhub.CodeConflict,
+	http.StatusMethodNotAllowed: peerhub.CodeInvalidRequest,
+}
+
+// errorBody keeps the message under error, clients written before codes were added read it from there
+type errorBody struct {
+	Error   string            `json:"error"`
+	Code    peerhub.ErrorCode `json:"code"`
+	Details map[string]any    `json:"details,omitempty"`
+}
+
+// WriteError writes the error as a JSON body with a status derived from it
+func WriteError(w http.ResponseWriter, err error) {
+	WriteErrorStatus(w, ErrorStatus(err), err)
+}
+
+func WriteErrorStatus(w http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError {
+		fmt.Println(err)
+	}
+
+	data := ErrorData(err)
+	if code, ok := statusCodes[status]; ok && data.Code == peerhub.CodeInternal {
+		data.Code = code
+	}
+
+	WriteJSON(w, status, errorBody{Error: data.Message, Code: data.Code, Details: data.Details})
+}
+
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func AdminOfferingsHandler(h *peerhub.Hub) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, ErrMethodNotAllowed)
+			return
+		}
+
+		ops, err := h.GetOfferingPeers(r.Context(), peerhub.AdminRequest{MasterPassword: MasterPassword(r)})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			WriteError(w, err)
 			return
 		}
 
-		if _, err := w.Write(b); err != nil {
-			fmt.Println(err)
+		WriteJSON(w, http.StatusOK, ops)
+	}
+}
+
+func AdminStatsHandler(h *peerhub.Hub) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			WriteError(w, ErrMethodNotAllowed)
+			return
+		}
+
+		stats, err := h.Stats(r.Context(), peerhub.AdminRequest{MasterPassword: MasterPassword(r)})
+		if err != nil {
+			WriteError(w, err)
+			return
 		}
+
+		WriteJSON(w, http.StatusOK, stats)
 	}
 }
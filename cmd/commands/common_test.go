@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestDecodeErrorsAreInvalidRequests(t *testing.T) {
+	for _, body := range []string{"", `{"name":`, `{"name":1}`, `[`} {
+		v := struct {
+			Name string `json:"name"`
+		}{}
+		err := DecodeJSON(strings.NewReader(body), &v)
+		if status := ErrorStatus(err); status != http.StatusBadRequest {
+			t.Errorf("body %q responded with %d, want 400", body, status)
+		}
+		if code := ErrorData(err).Code; code != peerhub.CodeInvalidRequest {
+			t.Errorf("body %q responded with code %s, want %s", body, code, peerhub.CodeInvalidRequest)
+		}
+	}
+}
+
+func TestWrongMethodIsAnInvalidRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	AdminStatsHandler(nil)(w, httptest.NewRequest(http.MethodPost, "/admin/stats", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("responded with %d, want 405", w.Code)
+	}
+	body := errorBody{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response isn't an error body: %v", err)
+	}
+	if body.Code != peerhub.CodeInvalidRequest {
+		t.Errorf("responded with code %s, want %s", body.Code, peerhub.CodeInvalidRequest)
+	}
+}
@@ -0,0 +1,62 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrInvalidConfig = errors.New("invalid federation config")
+
+type Config struct {
+	// Name is the hub name other hubs address peers of this hub with, as in name@hub
+	Name string `json:"name"`
+	// Hubs are the hubs this hub federates with
+	Hubs []Partner `json:"hubs"`
+}
+
+// Partner is a hub this hub federates with, both hubs have to configure each other with the same secret
+type Partner struct {
+	Name string `json:"name"`
+	// URL is the base URL the partner serves at
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Expose lets the partner list answering peers of this hub
+	Expose bool `json:"expose"`
+}
+
+// LoadConfig reads the JSON config file
+func LoadConfig(path string) (Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg := Config{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return Config{}, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg Config) validate() error {
+	if cfg.Name == "" || strings.Contains(cfg.Name, "@") {
+		return fmt.Errorf("%w: invalid hub name %q", ErrInvalidConfig, cfg.Name)
+	}
+
+	seen := map[string]bool{cfg.Name: true}
+	for _, p := range cfg.Hubs {
+		if p.Name == "" || strings.Contains(p.Name, "@") || seen[p.Name] {
+			return fmt.Errorf("%w: invalid or duplicate partner name %q", ErrInvalidConfig, p.Name)
+		}
+		if p.URL == "" || p.Secret == "" {
+			return fmt.Errorf("%w: partner %s needs url and secret", ErrInvalidConfig, p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return nil
+}
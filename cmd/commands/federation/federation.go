@@ -0,0 +1,247 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+// previewTimeout bounds listing answering peers of partners, the listing waits for the slowest of them
+const previewTimeout = 2 * time.Second
+
+// headers authenticating hub-to-hub requests
+const (
+	hubHeader    = "X-Federation-Hub"
+	secretHeader = "X-Federation-Secret"
+)
+
+var (
+	ErrHubUnavailable = errors.New("hub unavailable")
+	ErrInvalidSecret  = errors.New("invalid federation hub or secret")
+	ErrNotExposed     = errors.New("answering peers are not exposed to the hub")
+	// ErrUnexpectedMessageType is returned for messages partners have no reason to send
+	ErrUnexpectedMessageType = errors.New("unexpected message type")
+)
+
+// Federation connects the hub to partner hubs: offering peers of this hub reach answering peers of partners
+// addressed as name@hub, offers are made at the hub of the answering peer and further messages of the offer
+// are sent between the hubs, it implements peerhub.Federation and mailbox.Remote
+type Federation struct {
+	cfg    Config
+	hubs   map[string]Partner
+	client *http.Client
+	hub    *peerhub.Hub
+	boxes  *mailbox.Registry
+}
+
+func New(cfg Config) (*Federation, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	hubs := map[string]Partner{}
+	for _, p := range cfg.Hubs {
+		p.URL = strings.TrimSuffix(p.URL, "/")
+		hubs[p.Name] = p
+	}
+
+	return &Federation{
+		cfg:    cfg,
+		hubs:   hubs,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (f *Federation) Remote(name string) bool {
+	_, ok := f.partner(name)
+	return ok
+}
+
+// partner returns the hub the name addresses
+func (f *Federation) partner(name string) (Partner, bool) {
+	_, hub, ok := splitAddress(name)
+	if !ok {
+		return Partner{}, false
+	}
+	p, ok := f.hubs[hub]
+	return p, ok
+}
+
+type offerRequest struct {
+	OfferingPeer    string `json:"offeringpeer"`
+	TargetName      string `json:"targetname"`
+	TargetAccessKey string `json:"targetaccesskey"`
+	SDP             string `json:"sdp"`
+	IgnoreNotFound  bool   `json:"ignorenotfound"`
+}
+
+type offerResponse struct {
+	Offer       *peerhub.Offer       `json:"offer,omitempty"`
+	FailedOffer *peerhub.FailedOffer `json:"failedoffer,omitempty"`
+}
+
+func (f *Federation) Offer(ctx context.Context, op peerhub.OfferingPeer) (offer peerhub.Offer, failedOffer peerhub.FailedOffer, isOffer, isFailed bool, err error) {
+	p, ok := f.partner(op.TargetName)
+	if !ok {
+		return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, peerhub.ErrAnsweringPeerNotFound
+	}
+
+	req := offerRequest{
+		OfferingPeer:    op.Name,
+		TargetName:      op.TargetName,
+		TargetAccessKey: op.TargetAccessKey,
+		SDP:             op.SDP,
+		IgnoreNotFound:  op.IgnoreNotFound,
+	}
+	resp := offerResponse{}
+	status, err := f.do(ctx, p, http.MethodPost, "/federation/offers", req, &resp)
+	if status == http.StatusNotFound {
+		return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, fmt.Errorf("%w: %w", peerhub.ErrAnsweringPeerNotFound, err)
+	}
+	if err != nil {
+		return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, err
+	}
+
+	if resp.Offer != nil {
+		offer = *resp.Offer
+		if offer.OfferingPeer, err = f.localName(offer.OfferingPeer, p.Name); err != nil {
+			return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, err
+		}
+		if offer.AnsweringPeer, err = f.localName(offer.AnsweringPeer, p.Name); err != nil {
+			return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, err
+		}
+		return offer, peerhub.FailedOffer{}, true, false, nil
+	}
+
+	if resp.FailedOffer != nil {
+		failedOffer = *resp.FailedOffer
+		failedOffer.OfferingPeer = op.Name
+		failedOffer.AnsweringPeer = op.TargetName
+		return peerhub.Offer{}, failedOffer, false, true, nil
+	}
+
+	return peerhub.Offer{}, peerhub.FailedOffer{}, false, false, nil
+}
+
+type deliverResponse struct {
+	Queued bool `json:"queued"`
+}
+
+func (f *Federation) Send(ctx context.Context, env mailbox.Envelope) (bool, error) {
+	p, ok := f.partner(env.To.Name)
+	if !ok {
+		return false, mailbox.ErrNotFound
+	}
+
+	resp := deliverResponse{}
+	status, err := f.do(ctx, p, http.MethodPost, "/federation/deliver", env, &resp)
+	if status == http.StatusNotFound {
+		if env.To.Role == mailbox.Offering {
+			// the offering peer is gone from its hub, offers to local peers must not be made to it anymore
+			if err := f.hub.DeleteRemoteOfferingPeer(ctx, env.To.Name); err != nil && !errors.Is(err, peerhub.ErrOfferingPeerNotFound) {
+				fmt.Println(fmt.Errorf("error deleting remote offering peer: %w", err))
+			}
+		}
+		return false, mailbox.ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Queued, nil
+}
+
+// Previews lists answering peers partners expose to this hub, named name@hub, partners are asked at once
+// and those which don't expose them or don't respond within previewTimeout are skipped
+func (f *Federation) Previews(ctx context.Context) []peerhub.AnsweringPeerPreview {
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	lists := make([][]peerhub.AnsweringPeerPreview, len(f.cfg.Hubs))
+	wg := sync.WaitGroup{}
+	for i, p := range f.cfg.Hubs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lists[i] = f.partnerPreviews(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	previews := []peerhub.AnsweringPeerPreview{}
+	for _, list := range lists {
+		previews = append(previews, list...)
+	}
+	return previews
+}
+
+func (f *Federation) partnerPreviews(ctx context.Context, p Partner) []peerhub.AnsweringPeerPreview {
+	apps := []peerhub.AnsweringPeerPreview{}
+	status, err := f.do(ctx, p, http.MethodGet, "/federation/answerings", nil, &apps)
+	if status == http.StatusForbidden {
+		return nil
+	}
+	if err != nil {
+		fmt.Println(fmt.Errorf("error listing answering peers of %s: %w", p.Name, err))
+		return nil
+	}
+
+	for i := range apps {
+		apps[i].Name = apps[i].Name + "@" + p.Name
+	}
+	return apps
+}
+
+type errorBody struct {
+	Error string            `json:"error"`
+	Code  peerhub.ErrorCode `json:"code"`
+}
+
+// do sends a request to the partner and decodes the response into out, it returns the response status
+// along with an error for anything other than 200
+func (f *Federation) do(ctx context.Context, p Partner, method, path string, body, out any) (int, error) {
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(hubHeader, f.cfg.Name)
+	req.Header.Set(secretHeader, p.Secret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %w", ErrHubUnavailable, p.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		eb := errorBody{}
+		json.NewDecoder(resp.Body).Decode(&eb)
+		if known, ok := peerhub.ErrorOf(eb.Code); ok {
+			return resp.StatusCode, fmt.Errorf("%w: %s responded with %s: %s", known, p.Name, resp.Status, eb.Error)
+		}
+		return resp.StatusCode, fmt.Errorf("%s responded with %s: %s", p.Name, resp.Status, eb.Error)
+	}
+
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
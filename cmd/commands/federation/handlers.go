@@ -0,0 +1,261 @@
+package federation
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+// message types the federation keeps copies of remote offers in sync with, matching the websocket protocol
+const (
+	messageTypeOffer                     = "offer"
+	messageTypeOfferCreated              = "offer_created"
+	messageTypeOfferAnswer               = "offer_answer"
+	messageTypeOfferExpired              = "offer_expired"
+	messageTypeAnsweringPeerDisconnected = "answering_peer_disconnected"
+	messageTypeDealAnswerRejected        = "deal_answer_rejected"
+	messageTypeDealAnswerError           = "deal_answer_error"
+)
+
+// message types partners relay to local peers as they are
+const (
+	messageTypeOfferFailed     = "offer_failed"
+	messageTypeICECandidate    = "ice_candidate"
+	messageTypeEndOfCandidates = "end_of_candidates"
+	messageTypeDeliveryReceipt = "delivery_receipt"
+)
+
+// partnerMessageTypes are the message types hubs send each other about offers between their peers,
+// partners can't deliver anything else to local peers
+var partnerMessageTypes = map[string]bool{
+	messageTypeOffer:                     true,
+	messageTypeOfferCreated:              true,
+	messageTypeOfferAnswer:               true,
+	messageTypeOfferExpired:              true,
+	messageTypeAnsweringPeerDisconnected: true,
+	messageTypeDealAnswerRejected:        true,
+	messageTypeDealAnswerError:           true,
+	messageTypeOfferFailed:               true,
+	messageTypeICECandidate:              true,
+	messageTypeEndOfCandidates:           true,
+	messageTypeDeliveryReceipt:           true,
+}
+
+type partnerKey struct{}
+
+// RegisterHandlers registers the hub-to-hub endpoints, the federation serves and sends messages through the hub
+// and the registry from now on
+func (f *Federation) RegisterHandlers(mux *http.ServeMux, hub *peerhub.Hub, boxes *mailbox.Registry) {
+	f.hub = hub
+	f.boxes = boxes
+
+	mux.HandleFunc("POST /federation/offers", f.authorize(f.handleOffer))
+	mux.HandleFunc("POST /federation/deliver", f.authorize(f.handleDeliver))
+	mux.HandleFunc("GET /federation/answerings", f.authorize(f.handleAnswerings))
+}
+
+func (f *Federation) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := f.hubs[r.Header.Get(hubHeader)]
+		if !ok || subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(p.Secret)) != 1 {
+			commands.WriteErrorStatus(w, http.StatusUnauthorized, ErrInvalidSecret)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), partnerKey{}, p)))
+	}
+}
+
+func partnerOf(r *http.Request) Partner {
+	return r.Context().Value(partnerKey{}).(Partner)
+}
+
+// handleOffer makes an offer from an offering peer of the partner to a local answering peer
+func (f *Federation) handleOffer(w http.ResponseWriter, r *http.Request) {
+	p := partnerOf(r)
+
+	req := offerRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	_, _, remote := splitAddress(req.OfferingPeer)
+	opName, opErr := f.localName(req.OfferingPeer, p.Name)
+	apName, apErr := f.localName(req.TargetName, p.Name)
+	if remote || opErr != nil || apErr != nil || f.Remote(apName) {
+		commands.WriteErrorStatus(w, http.StatusNotFound, peerhub.ErrAnsweringPeerNotFound)
+		return
+	}
+
+	offer, failed, isOffer, isFailed, err := f.hub.OfferFromRemotePeer(r.Context(), peerhub.OfferingPeer{
+		Name:            opName,
+		TargetName:      apName,
+		TargetAccessKey: req.TargetAccessKey,
+		SDP:             req.SDP,
+		IgnoreNotFound:  req.IgnoreNotFound,
+	})
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	resp := offerResponse{}
+	if isOffer {
+		resp.Offer = &offer
+	}
+	if isFailed {
+		resp.FailedOffer = &failed
+	}
+
+	commands.WriteJSON(w, http.StatusOK, resp)
+}
+
+// handleDeliver delivers a message from the partner to a local peer
+func (f *Federation) handleDeliver(w http.ResponseWriter, r *http.Request) {
+	p := partnerOf(r)
+
+	env := mailbox.Envelope{}
+	if err := commands.DecodeJSON(r.Body, &env); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	env, err := f.localEnvelope(env, p.Name)
+	if err != nil {
+		commands.WriteErrorStatus(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deliver, err := f.track(r.Context(), env)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	queued := false
+	if deliver {
+		queued, err = f.boxes.DeliverEnvelope(r.Context(), env)
+		if errors.Is(err, mailbox.ErrNotFound) {
+			commands.WriteErrorStatus(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			commands.WriteError(w, err)
+			return
+		}
+	}
+
+	commands.WriteJSON(w, http.StatusOK, deliverResponse{Queued: queued})
+}
+
+// localEnvelope renames peers of the envelope the way this hub names them, the recipient has to be a local peer
+// and the sender either a peer of the partner or the partner itself
+func (f *Federation) localEnvelope(env mailbox.Envelope, from string) (mailbox.Envelope, error) {
+	var err error
+	if env.To.Name, err = f.localName(env.To.Name, from); err != nil {
+		return mailbox.Envelope{}, err
+	}
+	if _, _, remote := splitAddress(env.To.Name); remote || env.To.Name == "" {
+		return mailbox.Envelope{}, fmt.Errorf("%w: %s", ErrForeignPeer, env.To.Name)
+	}
+
+	if _, _, remote := splitAddress(env.From.Name); remote {
+		return mailbox.Envelope{}, fmt.Errorf("%w: %s", ErrForeignPeer, env.From.Name)
+	}
+	if env.From.Name, err = f.localName(env.From.Name, from); err != nil {
+		return mailbox.Envelope{}, err
+	}
+
+	if env.Data, err = f.localData(env.Data, from); err != nil {
+		return mailbox.Envelope{}, err
+	}
+
+	return env, nil
+}
+
+// track keeps local offers and copies of offers made at partners in sync with messages of the partner,
+// it reports whether the message should be delivered
+func (f *Federation) track(ctx context.Context, env mailbox.Envelope) (bool, error) {
+	if !partnerMessageTypes[env.Type] {
+		return false, fmt.Errorf("%w: %w: %s", commands.ErrInvalidRequest, ErrUnexpectedMessageType, env.Type)
+	}
+
+	switch env.Type {
+	case messageTypeOffer:
+		// offers reach local peers only if they were made here
+		offer := peerhub.Offer{}
+		if err := commands.UnmarshalJSON(env.Data, &offer); err != nil {
+			return false, err
+		}
+		stored, err := f.hub.GetOffer(ctx, peerhub.GetOfferRequest{OfferID: offer.ID, PeerName: env.To.Name})
+		if err != nil {
+			return false, err
+		}
+		if stored.OfferingPeer != env.From.Name {
+			return false, peerhub.ErrPeerNotInOffer
+		}
+	case messageTypeOfferCreated:
+		offer := peerhub.Offer{}
+		if err := commands.UnmarshalJSON(env.Data, &offer); err != nil {
+			return false, err
+		}
+		if offer.OfferingPeer != env.To.Name {
+			return false, peerhub.ErrPeerNotInOffer
+		}
+		return true, f.hub.MirrorRemoteOffer(ctx, offer)
+	case messageTypeOfferAnswer:
+		answer := peerhub.Answer{}
+		if err := commands.UnmarshalJSON(env.Data, &answer); err != nil {
+			return false, err
+		}
+		err := f.hub.AnswerRemoteOffer(ctx, answer)
+		if err != nil && !errors.Is(err, peerhub.ErrOfferNotFound) {
+			return false, err
+		}
+	case messageTypeOfferExpired:
+		offer := peerhub.Offer{}
+		if err := commands.UnmarshalJSON(env.Data, &offer); err != nil {
+			return false, err
+		}
+		// the peer was already notified if the copy expired here first
+		return f.hub.ForgetRemoteOffer(ctx, offer.ID, offer.AnsweringPeer)
+	case messageTypeAnsweringPeerDisconnected:
+		offer := peerhub.Offer{}
+		if err := commands.UnmarshalJSON(env.Data, &offer); err != nil {
+			return false, err
+		}
+		_, err := f.hub.ForgetRemoteOffer(ctx, offer.ID, offer.AnsweringPeer)
+		return err == nil, err
+	case messageTypeDealAnswerRejected, messageTypeDealAnswerError:
+		rejected := peerhub.RejectedOffer{}
+		if err := commands.UnmarshalJSON(env.Data, &rejected); err != nil {
+			return false, err
+		}
+		_, err := f.hub.ForgetRemoteOffer(ctx, rejected.OfferID, rejected.AnsweringPeer)
+		return err == nil, err
+	}
+
+	return true, nil
+}
+
+// handleAnswerings lists answering peers of this hub to the partner if they are exposed to it
+func (f *Federation) handleAnswerings(w http.ResponseWriter, r *http.Request) {
+	if !partnerOf(r).Expose {
+		commands.WriteErrorStatus(w, http.StatusForbidden, ErrNotExposed)
+		return
+	}
+
+	apps, err := f.hub.GetAnsweringPeersPrevies(r.Context())
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	commands.WriteJSON(w, http.StatusOK, apps)
+}
@@ -0,0 +1,26 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+func TestPartnersCanOnlySendOfferMessages(t *testing.T) {
+	f := &Federation{}
+	for _, mt := range []string{"session", "admin_kick", "create_answering_peer", ""} {
+		env := mailbox.Envelope{To: mailbox.Peer{Role: mailbox.Answering, Name: "ap"}, Type: mt, Data: []byte(`{}`)}
+		deliver, err := f.track(context.Background(), env)
+		if deliver || !errors.Is(err, ErrUnexpectedMessageType) || !errors.Is(err, commands.ErrInvalidRequest) {
+			t.Errorf("message of type %q was accepted, deliver %v, err %v", mt, deliver, err)
+		}
+	}
+
+	env := mailbox.Envelope{To: mailbox.Peer{Role: mailbox.Answering, Name: "ap"}, Type: messageTypeICECandidate, Data: []byte(`{}`)}
+	if deliver, err := f.track(context.Background(), env); !deliver || err != nil {
+		t.Errorf("candidate wasn't accepted, deliver %v, err %v", deliver, err)
+	}
+}
@@ -0,0 +1,71 @@
+package federation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrForeignPeer = errors.New("peer belongs to neither of the hubs")
+
+// peerFields are fields of message data which hold peer names
+var peerFields = []string{"offeringpeer", "answeringpeer", "from", "to", "sender", "recipient"}
+
+// splitAddress splits name@hub, ok is false for local names
+func splitAddress(name string) (peer, hub string, ok bool) {
+	i := strings.LastIndex(name, "@")
+	if i < 0 {
+		return name, "", false
+	}
+	return name[:i], name[i+1:], true
+}
+
+// localName renames a peer the way hub from names it to the way this hub does: local names of hub from
+// get its name appended and names addressing this hub lose it, empty name stands for the hub itself
+func (f *Federation) localName(name, from string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	peer, hub, ok := splitAddress(name)
+	if !ok {
+		return name + "@" + from, nil
+	}
+	if hub == f.cfg.Name {
+		return peer, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrForeignPeer, name)
+}
+
+// localData renames peers in top level fields of message data, data which is not an object is left intact
+func (f *Federation) localData(data json.RawMessage, from string) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return data, nil
+	}
+
+	for _, field := range peerFields {
+		raw, ok := fields[field]
+		if !ok {
+			continue
+		}
+
+		name := ""
+		if err := json.Unmarshal(raw, &name); err != nil {
+			continue
+		}
+
+		local, err := f.localName(name, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields[field], err = json.Marshal(local); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(fields)
+}
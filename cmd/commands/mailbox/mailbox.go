@@ -0,0 +1,417 @@
+package mailbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+)
+
+var (
+	ErrNotFound = errors.New("peer not found")
+	ErrFull     = errors.New("mailbox is full")
+	ErrClosed   = errors.New("mailbox closed")
+)
+
+// maxQueued is the number of undelivered messages a mailbox holds before rejecting new ones
+const maxQueued = 256
+
+// messageTypeDeliveryReceipt is pushed to the sender once its queued message is written to the recipient
+const messageTypeDeliveryReceipt = "delivery_receipt"
+
+type Role = peerhub.PeerRole
+
+const (
+	Answering = peerhub.PeerRoleAnswering
+	Offering  = peerhub.PeerRoleOffering
+)
+
+// Peer identifies the sender of a message, zero Peer stands for the hub itself
+type Peer struct {
+	Role Role   `json:"role"`
+	Name string `json:"name"`
+}
+
+// Message is a push addressed to a peer, it has the same shape as websocket messages
+type Message struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+	from Peer
+	// expiresAt is when the message becomes useless, zero if it doesn't expire
+	expiresAt time.Time
+	// mail is the queued mail the message was taken from, its sender gets a receipt once the message is written
+	mail *peerhub.Mail
+}
+
+type key struct {
+	role Role
+	name string
+}
+
+// Registry holds mailboxes of peers, messages are pushed to a mailbox and consumed by whichever transport
+// (websocket, server-sent events or long-polling) the peer currently uses,
+// messages for registered peers without a mailbox are queued in the hub until they open one
+type Registry struct {
+	mu    sync.Mutex
+	hub   *peerhub.Hub
+	boxes map[key]*Mailbox
+	// relay forwards messages to mailboxes held by other hub instances, nil for a single instance
+	relay Relay
+	// remote sends messages to peers of other hubs, nil if the hub is not federated
+	remote Remote
+}
+
+func NewRegistry(hub *peerhub.Hub) *Registry {
+	return &Registry{
+		mu:    sync.Mutex{},
+		hub:   hub,
+		boxes: map[key]*Mailbox{},
+	}
+}
+
+// Open returns peer's mailbox, creating it if it doesn't exist, mail queued for the peer is moved into it
+func (r *Registry) Open(ctx context.Context, role Role, name string) *Mailbox {
+	r.mu.Lock()
+	k := key{role: role, name: name}
+	box, ok := r.boxes[k]
+	if !ok {
+		box = newMailbox(func(msgs []Message) { r.requeue(role, name, msgs) }, r.sendReceipts)
+		r.boxes[k] = box
+	}
+	relay := r.relay
+	r.mu.Unlock()
+
+	if !ok && relay != nil {
+		if err := relay.Claim(Peer{Role: role, Name: name}); err != nil {
+			fmt.Println(fmt.Errorf("error claiming mailbox: %w", err))
+		}
+	}
+
+	r.flushMail(ctx, box, role, name)
+
+	return box
+}
+
+// flushMail pushes mail queued for the peer into its mailbox, senders get delivery receipts once consumers
+// write the messages
+func (r *Registry) flushMail(ctx context.Context, box *Mailbox, role Role, name string) {
+	mails, err := r.hub.TakeMail(ctx, role, name)
+	if err != nil {
+		fmt.Println(fmt.Errorf("error taking mail: %w", err))
+		return
+	}
+
+	for _, m := range mails {
+		msg := Message{
+			Type:      m.Type,
+			Data:      m.Data,
+			from:      Peer{Role: m.SenderRole, Name: m.Sender},
+			expiresAt: m.ExpiresAt,
+			mail:      &m,
+		}
+		if err := box.Push(msg); err != nil {
+			fmt.Println(fmt.Errorf("error delivering mail %s: %w", m.ID, err))
+		}
+	}
+}
+
+// sendReceipts sends delivery receipts of written messages taken from queued mail
+func (r *Registry) sendReceipts(msgs []Message) {
+	for _, msg := range msgs {
+		if msg.mail == nil || msg.mail.Sender == "" {
+			continue
+		}
+
+		// the receipt outlives the request of whichever transport wrote the message
+		err := r.Deliver(context.Background(), msg.mail.SenderRole, msg.mail.Sender, messageTypeDeliveryReceipt, peerhub.NewDeliveryReceipt(*msg.mail))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Println(fmt.Errorf("error sending delivery receipt: %w", err))
+		}
+	}
+}
+
+func (r *Registry) Get(role Role, name string) (*Mailbox, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	box, ok := r.boxes[key{role: role, name: name}]
+	return box, ok
+}
+
+// Close removes peer's mailbox and stops its consumer, messages which were not consumed yet are queued
+// in the hub again, unless the peer was deleted
+func (r *Registry) Close(role Role, name string) {
+	r.mu.Lock()
+	k := key{role: role, name: name}
+	box, ok := r.boxes[k]
+	if ok {
+		delete(r.boxes, k)
+	}
+	relay := r.relay
+	r.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if relay != nil {
+		if err := relay.Release(Peer{Role: role, Name: name}); err != nil {
+			fmt.Println(fmt.Errorf("error releasing mailbox: %w", err))
+		}
+	}
+
+	r.requeue(role, name, box.close())
+}
+
+// requeue queues messages of a closed mailbox in the hub
+func (r *Registry) requeue(role Role, name string, msgs []Message) {
+	// the peer is gone, so is the context of whatever closed its mailbox
+	for _, msg := range msgs {
+		if _, err := r.queue(context.Background(), msg.from, role, name, msg.Type, msg.Data, msg.expiresAt); err != nil && !errors.Is(err, ErrNotFound) {
+			fmt.Println(fmt.Errorf("error queueing undelivered message: %w", err))
+		}
+	}
+}
+
+// Deliver pushes a message from the hub to peer's mailbox, the message is queued if the peer is offline,
+// ErrNotFound is returned if the peer doesn't exist
+func (r *Registry) Deliver(ctx context.Context, role Role, name, mt string, data any) error {
+	_, err := r.DeliverFrom(ctx, Peer{}, role, name, mt, data)
+	return err
+}
+
+// DeliverFrom pushes a message from another peer to peer's mailbox, if the peer is offline the message is queued
+// and the sender gets a delivery receipt once it's delivered, messages for peers of other hubs are sent there
+func (r *Registry) DeliverFrom(ctx context.Context, from Peer, role Role, name, mt string, data any) (queued bool, err error) {
+	return r.deliver(ctx, from, role, name, mt, data, expiryOf(data))
+}
+
+// DeliverEnvelope delivers a message another hub sent like DeliverFrom, the message expires with the envelope
+func (r *Registry) DeliverEnvelope(ctx context.Context, env Envelope) (queued bool, err error) {
+	return r.deliver(ctx, env.From, env.To.Role, env.To.Name, env.Type, env.Data, env.ExpiresAt)
+}
+
+func (r *Registry) deliver(ctx context.Context, from Peer, role Role, name, mt string, data any, expiresAt time.Time) (bool, error) {
+	if r.IsRemote(name) {
+		return r.send(ctx, from, role, name, mt, data, expiresAt)
+	}
+
+	box, ok := r.Get(role, name)
+	if !ok {
+		return r.forward(ctx, from, role, name, mt, data, expiresAt)
+	}
+
+	err := box.Push(Message{Type: mt, Data: data, from: from, expiresAt: expiresAt})
+	if errors.Is(err, ErrClosed) {
+		// the mailbox was closed after we got it
+		return r.queue(ctx, from, role, name, mt, data, expiresAt)
+	}
+
+	return false, err
+}
+
+// forward sends the message to another hub instance holding peer's mailbox, the message is queued if there is none
+func (r *Registry) forward(ctx context.Context, from Peer, role Role, name, mt string, data any, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	relay := r.relay
+	r.mu.Unlock()
+
+	if relay != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return false, err
+		}
+		forwarded, err := relay.Forward(Envelope{From: from, To: Peer{Role: role, Name: name}, Type: mt, Data: raw, ExpiresAt: expiresAt})
+		if err != nil {
+			// the message is queued, the peer gets it once it reconnects
+			fmt.Println(fmt.Errorf("error forwarding message: %w", err))
+		}
+		if forwarded {
+			return false, nil
+		}
+	}
+
+	return r.queue(ctx, from, role, name, mt, data, expiresAt)
+}
+
+// queue stores the message in the hub until the peer opens its mailbox, mail expires with the message,
+// messages which already expired are dropped
+func (r *Registry) queue(ctx context.Context, from Peer, role Role, name, mt string, data any, expiresAt time.Time) (bool, error) {
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+		if ttl <= 0 {
+			return false, nil
+		}
+	}
+
+	_, err := r.hub.QueueMail(ctx, peerhub.QueueMailRequest{
+		RecipientRole: role,
+		Recipient:     name,
+		SenderRole:    from.Role,
+		Sender:        from.Name,
+		Type:          mt,
+		Data:          data,
+		TTL:           ttl,
+	})
+	if errors.Is(err, peerhub.ErrAnsweringPeerNotFound) || errors.Is(err, peerhub.ErrOfferingPeerNotFound) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// expiryOf returns when the offer or the answer the message carries expires, zero for other messages
+func expiryOf(data any) time.Time {
+	switch d := data.(type) {
+	case peerhub.Offer:
+		return d.ExpiresAt
+	case *peerhub.Offer:
+		return d.ExpiresAt
+	case peerhub.Answer:
+		return d.ExpiresAt
+	case *peerhub.Answer:
+		return d.ExpiresAt
+	}
+	return time.Time{}
+}
+
+type Mailbox struct {
+	mu     sync.Mutex
+	queue  []Message
+	notify chan struct{}
+	closed bool
+	// consumers are attached consumers, the last one gets the messages and the others wait until it detaches
+	consumers []*Consumer
+	// undelivered queues messages requeued after the mailbox was closed
+	undelivered func([]Message)
+	// delivered sends receipts of messages consumers wrote
+	delivered func([]Message)
+}
+
+func newMailbox(undelivered, delivered func([]Message)) *Mailbox {
+	return &Mailbox{
+		mu:          sync.Mutex{},
+		queue:       []Message{},
+		notify:      make(chan struct{}),
+		undelivered: undelivered,
+		delivered:   delivered,
+	}
+}
+
+func (m *Mailbox) Push(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	if len(m.queue) >= maxQueued {
+		return ErrFull
+	}
+	m.queue = append(m.queue, msg)
+	m.wake()
+	return nil
+}
+
+// wake wakes up waiting consumers, m.mu has to be held
+func (m *Mailbox) wake() {
+	close(m.notify)
+	m.notify = make(chan struct{})
+}
+
+// Consumer reads messages of a mailbox for a transport of the peer
+type Consumer struct {
+	box *Mailbox
+}
+
+// Attach makes the caller the consumer of the mailbox, the previous consumer gets no messages
+// until the new one detaches
+func (m *Mailbox) Attach() *Consumer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &Consumer{box: m}
+	m.consumers = append(m.consumers, c)
+	m.wake()
+	return c
+}
+
+// Detach removes the consumer, the consumer attached before it gets the messages again
+func (c *Consumer) Detach() {
+	m := c.box
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consumers = slices.DeleteFunc(m.consumers, func(other *Consumer) bool { return other == c })
+	if !m.closed {
+		m.wake()
+	}
+}
+
+// Wait returns all queued messages, blocking until there is at least one for this consumer or ctx is done
+func (c *Consumer) Wait(ctx context.Context) ([]Message, error) {
+	m := c.box
+	for {
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if len(m.queue) > 0 && m.consumers[len(m.consumers)-1] == c {
+			msgs := m.queue
+			m.queue = []Message{}
+			m.mu.Unlock()
+			return msgs, nil
+		}
+		notify := m.notify
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+// Requeue puts messages the consumer failed to write back in front of the queue,
+// they are queued in the hub if the mailbox was closed in the meantime
+func (c *Consumer) Requeue(msgs []Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	m := c.box
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		m.undelivered(msgs)
+		return
+	}
+	m.queue = append(slices.Clone(msgs), m.queue...)
+	m.wake()
+	m.mu.Unlock()
+}
+
+// Delivered tells the mailbox the consumer wrote the messages to the peer, senders of queued mail get receipts
+func (c *Consumer) Delivered(msgs ...Message) {
+	c.box.delivered(msgs)
+}
+
+// close stops the mailbox and returns messages which were not consumed
+func (m *Mailbox) close() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.notify)
+	msgs := m.queue
+	m.queue = []Message{}
+	return msgs
+}
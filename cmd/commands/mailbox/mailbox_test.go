@@ -0,0 +1,157 @@
+package mailbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+func waitTypes(t *testing.T, c *Consumer) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msgs, err := c.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []string{}
+	for _, msg := range msgs {
+		types = append(types, msg.Type)
+	}
+	return types
+}
+
+func TestAttachPausesPreviousConsumer(t *testing.T) {
+	box := newMailbox(nop, nop)
+	ws := box.Attach()
+	poll := box.Attach()
+
+	box.Push(Message{Type: "1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if msgs, err := ws.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("paused consumer got %v %v", msgs, err)
+	}
+	if types := waitTypes(t, poll); len(types) != 1 || types[0] != "1" {
+		t.Errorf("attached consumer got %v", types)
+	}
+
+	box.Push(Message{Type: "2"})
+	poll.Detach()
+	if types := waitTypes(t, ws); len(types) != 1 || types[0] != "2" {
+		t.Errorf("restored consumer got %v", types)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	box := newMailbox(nop, nop)
+	c := box.Attach()
+
+	box.Push(Message{Type: "1"})
+	box.Push(Message{Type: "2"})
+	msgs, _ := c.Wait(context.Background())
+	box.Push(Message{Type: "3"})
+
+	// writing the first message failed
+	c.Requeue(msgs)
+	types := waitTypes(t, c)
+	if len(types) != 3 || types[0] != "1" || types[1] != "2" || types[2] != "3" {
+		t.Errorf("got %v, want requeued messages first", types)
+	}
+}
+
+func TestRequeueAfterClose(t *testing.T) {
+	undelivered := []Message{}
+	box := newMailbox(func(msgs []Message) { undelivered = append(undelivered, msgs...) }, nop)
+	c := box.Attach()
+
+	box.Push(Message{Type: "1"})
+	msgs, _ := c.Wait(context.Background())
+	box.close()
+	if _, err := c.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("waiting on a closed mailbox returned %v", err)
+	}
+
+	c.Requeue(msgs)
+	if len(undelivered) != 1 || undelivered[0].Type != "1" {
+		t.Errorf("undelivered %v, want the requeued message", undelivered)
+	}
+}
+
+func nop([]Message) {}
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	peers := peer.NewInMemoryService()
+	for _, name := range []string{"ap", "op"} {
+		if err := peers.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+		if err := peers.CreateOfferingPeer(peerhub.OfferingPeer{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewRegistry(peerhub.NewHub(peerhub.HubConfig{PeerService: peers, SignalService: sig.NewInMemoryService()}))
+}
+
+func TestReceiptIsSentOnceMailIsWritten(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRegistry(t)
+
+	queued, err := r.DeliverFrom(ctx, Peer{Role: Offering, Name: "op"}, Answering, "ap", "msg", "hello")
+	if err != nil || !queued {
+		t.Fatalf("message for an offline peer wasn't queued, queued %v err %v", queued, err)
+	}
+
+	sender := r.Open(ctx, Offering, "op").Attach()
+	recipient := r.Open(ctx, Answering, "ap").Attach()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if msgs, err := sender.Wait(waitCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("sender got %v %v before the message was written", msgs, err)
+	}
+
+	msgs, err := recipient.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient.Delivered(msgs...)
+
+	if types := waitTypes(t, sender); len(types) != 1 || types[0] != messageTypeDeliveryReceipt {
+		t.Errorf("sender got %v, want a delivery receipt", types)
+	}
+}
+
+func TestExpiredMessagesAreNotQueued(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRegistry(t)
+
+	expired := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	if queued, err := r.DeliverFrom(ctx, Peer{}, Answering, "ap", "offer", expired); queued || err != nil {
+		t.Errorf("expired offer was queued, queued %v err %v", queued, err)
+	}
+
+	offer := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	raw, _ := json.Marshal(offer)
+	env := Envelope{To: Peer{Role: Answering, Name: "ap"}, Type: "offer", Data: raw, ExpiresAt: offer.ExpiresAt}
+	if queued, err := r.DeliverEnvelope(ctx, env); !queued || err != nil {
+		t.Fatalf("forwarded offer wasn't queued, queued %v err %v", queued, err)
+	}
+
+	mails, err := r.hub.TakeMail(ctx, Answering, "ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].ExpiresAt.Sub(offer.ExpiresAt).Abs() > 10*time.Millisecond {
+		t.Errorf("queued %+v, want mail expiring with the offer at %s", mails, offer.ExpiresAt)
+	}
+}
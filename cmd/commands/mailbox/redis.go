@@ -0,0 +1,142 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/H3Cki/peerhub/internal/resp"
+)
+
+// releaseScript deletes the claim KEYS[1] if it's still held by ARGV[1]
+const releaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
+// RedisRelay routes messages between hub instances with redis pub/sub, every instance subscribes to its own
+// channel and claims mailboxes it holds with keys naming the instance
+type RedisRelay struct {
+	c          *resp.Client
+	prefix     string
+	instanceID string
+	mu         sync.Mutex
+	// local are peers whose mailboxes this instance holds, they are claimed again after the subscription
+	// is restored because senders drop claims of instances that don't listen
+	local map[Peer]bool
+}
+
+func NewRedisRelay(c *resp.Client, prefix, instanceID string) *RedisRelay {
+	return &RedisRelay{c: c, prefix: prefix, instanceID: instanceID, local: map[Peer]bool{}}
+}
+
+func (r *RedisRelay) ownerKey(p Peer) string {
+	return r.prefix + "owners:" + string(p.Role) + ":" + p.Name
+}
+
+func (r *RedisRelay) channel(instanceID string) string {
+	return r.prefix + "instances:" + instanceID
+}
+
+func (r *RedisRelay) Claim(p Peer) error {
+	r.mu.Lock()
+	r.local[p] = true
+	r.mu.Unlock()
+
+	_, err := r.c.Do("SET", r.ownerKey(p), r.instanceID)
+	return err
+}
+
+// Release removes the claim unless the peer connected to another instance in the meantime
+func (r *RedisRelay) Release(p Peer) error {
+	r.mu.Lock()
+	delete(r.local, p)
+	r.mu.Unlock()
+
+	return r.releaseClaim(p, r.instanceID)
+}
+
+// releaseClaim removes the claim if it's held by the instance, the check and the delete are atomic
+func (r *RedisRelay) releaseClaim(p Peer, instanceID string) error {
+	_, err := r.c.Do("EVAL", releaseScript, 1, r.ownerKey(p), instanceID)
+	return err
+}
+
+// reclaim claims mailboxes this instance holds again
+func (r *RedisRelay) reclaim() {
+	r.mu.Lock()
+	local := make([]Peer, 0, len(r.local))
+	for p := range r.local {
+		local = append(local, p)
+	}
+	r.mu.Unlock()
+
+	for _, p := range local {
+		_, err := r.c.Do("SET", r.ownerKey(p), r.instanceID)
+		if err != nil {
+			// the command connection likely dropped along with the subscription, the client redials
+			// on the next command and SET is safe to repeat
+			_, err = r.c.Do("SET", r.ownerKey(p), r.instanceID)
+		}
+		if err != nil {
+			fmt.Println(fmt.Errorf("error claiming mailbox of %s: %w", p.Name, err))
+		}
+	}
+}
+
+func (r *RedisRelay) Forward(env Envelope) (bool, error) {
+	reply, err := r.c.Do("GET", r.ownerKey(env.To))
+	if errors.Is(err, resp.ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	owner := resp.String(reply)
+	if owner == r.instanceID {
+		// stale claim of this instance, the mailbox is gone
+		return false, nil
+	}
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return false, err
+	}
+	reply, err = r.c.Do("PUBLISH", r.channel(owner), data)
+	if err != nil {
+		return false, err
+	}
+	if resp.Int(reply) == 0 {
+		// nobody listens, the owner is gone without releasing its claims
+		if err := r.releaseClaim(env.To, owner); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Listen subscribes to the channel of this instance, the subscription is restored if the connection drops
+// and the mailboxes of this instance are claimed again, messages forwarded in the meantime are queued
+// by their senders
+func (r *RedisRelay) Listen(fn func(Envelope)) error {
+	_, err := r.c.Subscribe(r.channel(r.instanceID), func(payload []byte) {
+		env := Envelope{}
+		if err := json.Unmarshal(payload, &env); err != nil {
+			fmt.Println(fmt.Errorf("error decoding forwarded message: %w", err))
+			return
+		}
+		fn(env)
+	}, func(err error) {
+		if err != nil {
+			fmt.Println(fmt.Errorf("relay: %w", err))
+			return
+		}
+		fmt.Println("relay subscription restored")
+		r.reclaim()
+	})
+	return err
+}
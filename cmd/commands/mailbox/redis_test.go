@@ -0,0 +1,141 @@
+package mailbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/resp"
+	"github.com/H3Cki/peerhub/internal/resp/resptest"
+)
+
+func newRedisServer(t *testing.T) *resptest.Server {
+	t.Helper()
+	s, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.Script(releaseScript, release)
+	return s
+}
+
+// release does what releaseScript does
+func release(call func(args ...string) any, keys, argv []string) any {
+	if call("GET", keys[0]) == argv[0] {
+		return call("DEL", keys[0])
+	}
+	return 0
+}
+
+func newRedisRelay(t *testing.T, s *resptest.Server, instanceID string) *RedisRelay {
+	t.Helper()
+	c, err := resp.Dial(resp.Options{Addr: s.Addr(), Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return NewRedisRelay(c, "test:", instanceID)
+}
+
+var relayPeer = Peer{Role: peerhub.PeerRoleAnswering, Name: "ap"}
+
+func TestRedisRelayReleaseKeepsOtherClaims(t *testing.T) {
+	s := newRedisServer(t)
+	a := newRedisRelay(t, s, "a")
+	b := newRedisRelay(t, s, "b")
+
+	if err := a.Claim(relayPeer); err != nil {
+		t.Fatal(err)
+	}
+	// the peer reconnected to b before a noticed it left
+	if err := b.Claim(relayPeer); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Release(relayPeer); err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := s.Get(a.ownerKey(relayPeer)); owner != "b" {
+		t.Errorf("claim is held by %q, want b", owner)
+	}
+
+	if err := b.Release(relayPeer); err != nil {
+		t.Fatal(err)
+	}
+	if owner, ok := s.Get(a.ownerKey(relayPeer)); ok {
+		t.Errorf("claim of %q wasn't released", owner)
+	}
+}
+
+func TestRedisRelayForward(t *testing.T) {
+	s := newRedisServer(t)
+	a := newRedisRelay(t, s, "a")
+	b := newRedisRelay(t, s, "b")
+
+	received := make(chan Envelope, 1)
+	if err := b.Listen(func(env Envelope) { received <- env }); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := a.Forward(Envelope{To: relayPeer, Type: "mail"}); ok || err != nil {
+		t.Errorf("forwarded to an unclaimed mailbox, ok %v err %v", ok, err)
+	}
+
+	b.Claim(relayPeer)
+	ok, err := a.Forward(Envelope{To: relayPeer, Type: "mail", Data: []byte(`{}`)})
+	if !ok || err != nil {
+		t.Fatalf("forward returned %v %v", ok, err)
+	}
+	select {
+	case env := <-received:
+		if env.To != relayPeer || env.Type != "mail" {
+			t.Errorf("received %+v", env)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("forwarded message wasn't received")
+	}
+
+	if ok, _ := b.Forward(Envelope{To: relayPeer}); ok {
+		t.Error("forwarded a message to the instance's own claim")
+	}
+}
+
+func TestRedisRelayDropsClaimsOfGoneInstances(t *testing.T) {
+	s := newRedisServer(t)
+	a := newRedisRelay(t, s, "a")
+
+	// b claimed the mailbox and died without releasing it
+	s.Set(a.ownerKey(relayPeer), "b")
+	if ok, err := a.Forward(Envelope{To: relayPeer}); ok || err != nil {
+		t.Errorf("forwarded to a gone instance, ok %v err %v", ok, err)
+	}
+	if owner, ok := s.Get(a.ownerKey(relayPeer)); ok {
+		t.Errorf("claim of gone instance %q was kept", owner)
+	}
+}
+
+func TestRedisRelayReclaimsAfterReconnecting(t *testing.T) {
+	s := newRedisServer(t)
+	b := newRedisRelay(t, s, "b")
+
+	if err := b.Listen(func(Envelope) {}); err != nil {
+		t.Fatal(err)
+	}
+	b.Claim(relayPeer)
+
+	// while b is disconnected another instance finds nobody listening and drops b's claim
+	s.DropConns()
+	a := newRedisRelay(t, s, "a")
+	a.Forward(Envelope{To: relayPeer})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		owner, _ := s.Get(b.ownerKey(relayPeer))
+		if owner == "b" && s.Subscribers(b.channel("b")) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("claim is held by %q after the subscription was restored", owner)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,61 @@
+package mailbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Envelope is a message forwarded between hub instances
+type Envelope struct {
+	From Peer            `json:"from"`
+	To   Peer            `json:"to"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	// ExpiresAt is when the message becomes useless, zero if it doesn't expire
+	ExpiresAt time.Time `json:"expiresat"`
+}
+
+// Relay connects registries of hub instances sharing a store, so a message reaches the peer
+// regardless of which instance it's connected to
+type Relay interface {
+	// Claim records that this instance holds peer's mailbox
+	Claim(Peer) error
+	// Release removes the claim if this instance still holds it
+	Release(Peer) error
+	// Forward sends the envelope to the instance holding recipient's mailbox, it reports false if there is none
+	Forward(Envelope) (bool, error)
+	// Listen calls fn with envelopes forwarded to this instance
+	Listen(fn func(Envelope)) error
+}
+
+// UseRelay makes the registry forward messages for peers without a local mailbox through the relay
+// and deliver messages forwarded to this instance
+func (r *Registry) UseRelay(relay Relay) error {
+	r.mu.Lock()
+	r.relay = relay
+	r.mu.Unlock()
+
+	return relay.Listen(r.receive)
+}
+
+// receive delivers a forwarded message, the message is queued if the mailbox was closed in the meantime
+func (r *Registry) receive(env Envelope) {
+	box, ok := r.Get(env.To.Role, env.To.Name)
+	if ok {
+		err := box.Push(Message{Type: env.Type, Data: env.Data, from: env.From, expiresAt: env.ExpiresAt})
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, ErrClosed) {
+			fmt.Println(fmt.Errorf("error delivering forwarded message: %w", err))
+			return
+		}
+	}
+
+	if _, err := r.queue(context.Background(), env.From, env.To.Role, env.To.Name, env.Type, env.Data, env.ExpiresAt); err != nil && !errors.Is(err, ErrNotFound) {
+		fmt.Println(fmt.Errorf("error queueing forwarded message: %w", err))
+	}
+}
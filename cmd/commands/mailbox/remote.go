@@ -0,0 +1,45 @@
+package mailbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Remote delivers messages to peers of other hubs, they're addressed as name@hub
+type Remote interface {
+	// Remote tells if the name addresses a peer of another hub
+	Remote(name string) bool
+	// Send delivers the envelope to the hub of its recipient, it reports whether the message was queued there,
+	// ErrNotFound is returned if the recipient doesn't exist
+	Send(context.Context, Envelope) (queued bool, err error)
+}
+
+// UseRemote makes the registry send messages for peers of other hubs through the remote
+func (r *Registry) UseRemote(remote Remote) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remote = remote
+}
+
+// IsRemote tells if the name addresses a peer of another hub
+func (r *Registry) IsRemote(name string) bool {
+	r.mu.Lock()
+	remote := r.remote
+	r.mu.Unlock()
+
+	return remote != nil && remote.Remote(name)
+}
+
+func (r *Registry) send(ctx context.Context, from Peer, role Role, name, mt string, data any, expiresAt time.Time) (bool, error) {
+	r.mu.Lock()
+	remote := r.remote
+	r.mu.Unlock()
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	return remote.Send(ctx, Envelope{From: from, To: Peer{Role: role, Name: name}, Type: mt, Data: raw, ExpiresAt: expiresAt})
+}
@@ -0,0 +1,165 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 2 * time.Minute
+)
+
+func (h *Handler) answeringEvents(w http.ResponseWriter, r *http.Request) {
+	box, err := h.answeringMailbox(r)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.streamEvents(w, r, box)
+}
+
+func (h *Handler) offeringEvents(w http.ResponseWriter, r *http.Request) {
+	box, err := h.offeringMailbox(r)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.streamEvents(w, r, box)
+}
+
+func (h *Handler) answeringMessages(w http.ResponseWriter, r *http.Request) {
+	box, err := h.answeringMailbox(r)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.pollMessages(w, r, box)
+}
+
+func (h *Handler) offeringMessages(w http.ResponseWriter, r *http.Request) {
+	box, err := h.offeringMailbox(r)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.pollMessages(w, r, box)
+}
+
+func (h *Handler) answeringMailbox(r *http.Request) (*mailbox.Mailbox, error) {
+	ap, err := h.hub.AuthorizeAnsweringPeer(r.Context(), peerhub.PeerCredentials{
+		Name:          r.PathValue("name"),
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.boxes.Open(r.Context(), mailbox.Answering, ap.Name), nil
+}
+
+func (h *Handler) offeringMailbox(r *http.Request) (*mailbox.Mailbox, error) {
+	op, err := h.hub.AuthorizeOfferingPeer(r.Context(), peerhub.PeerCredentials{
+		Name:          r.PathValue("name"),
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.boxes.Open(r.Context(), mailbox.Offering, op.Name), nil
+}
+
+// streamEvents writes mailbox messages as server-sent events until the client goes away
+// and pauses while the peer uses another transport
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request, box *mailbox.Mailbox) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		commands.WriteErrorStatus(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	c := box.Attach()
+	defer c.Detach()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		msgs, err := c.Wait(r.Context())
+		if err != nil {
+			return
+		}
+
+		for i, msg := range msgs {
+			b, err := json.Marshal(msg.Data)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Type, b); err != nil {
+				fmt.Println(err)
+				c.Requeue(msgs[i:])
+				return
+			}
+		}
+		flusher.Flush()
+		c.Delivered(msgs...)
+	}
+}
+
+// pollMessages waits for mailbox messages up to the timeout query parameter and returns them,
+// an empty list is returned if nothing arrived
+func (h *Handler) pollMessages(w http.ResponseWriter, r *http.Request, box *mailbox.Mailbox) {
+	timeout := defaultPollTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d < 0 {
+			commands.WriteErrorStatus(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", t))
+			return
+		}
+		timeout = min(d, maxPollTimeout)
+	}
+
+	c := box.Attach()
+	defer c.Detach()
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	msgs, err := c.Wait(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		msgs = []mailbox.Message{}
+	} else if err != nil {
+		commands.WriteErrorStatus(w, http.StatusConflict, err)
+		return
+	}
+
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		c.Requeue(msgs)
+		commands.WriteErrorStatus(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		fmt.Println(err)
+		c.Requeue(msgs)
+		return
+	}
+	c.Delivered(msgs...)
+}
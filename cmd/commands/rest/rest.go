@@ -0,0 +1,287 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+// managementKeyHeader carries the management key of the peer named in the path or the peer query parameter
+const managementKeyHeader = "X-Management-Key"
+
+// message types of pushes, matching the websocket protocol
+const (
+	pushOffer        = "offer"
+	pushOfferCreated = "offer_created"
+	pushOfferFailed  = "offer_failed"
+	pushOfferAnswer  = "offer_answer"
+)
+
+// Handler exposes the hub over plain HTTP, peers receive pushes through server-sent events or long-polling
+type Handler struct {
+	hub   *peerhub.Hub
+	boxes *mailbox.Registry
+}
+
+func NewHandler(hub *peerhub.Hub, boxes *mailbox.Registry) *Handler {
+	return &Handler{hub: hub, boxes: boxes}
+}
+
+func (h *Handler) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/answerings", h.createAnsweringPeer)
+	mux.HandleFunc("PUT /api/answerings/{name}", h.createAnsweringPeer)
+	mux.HandleFunc("DELETE /api/answerings/{name}", h.deleteAnsweringPeer)
+	mux.HandleFunc("GET /api/answerings/{name}/offers", h.getPendingOffers)
+	mux.HandleFunc("GET /api/answerings/{name}/events", h.answeringEvents)
+	mux.HandleFunc("GET /api/answerings/{name}/messages", h.answeringMessages)
+
+	mux.HandleFunc("POST /api/offerings", h.createOfferingPeer)
+	mux.HandleFunc("PUT /api/offerings/{name}", h.createOfferingPeer)
+	mux.HandleFunc("DELETE /api/offerings/{name}", h.deleteOfferingPeer)
+	mux.HandleFunc("GET /api/offerings/{name}/events", h.offeringEvents)
+	mux.HandleFunc("GET /api/offerings/{name}/messages", h.offeringMessages)
+
+	mux.HandleFunc("GET /api/offers/{id}", h.getOffer)
+
+	mux.HandleFunc("POST /api/answers", h.createAnswer)
+	mux.HandleFunc("GET /api/answers/{id}", h.getAnswer)
+}
+
+type createAnsweringPeerResponse struct {
+	Name         string                `json:"name"`
+	Offers       []peerhub.Offer       `json:"offers"`
+	FailedOffers []peerhub.FailedOffer `json:"failedoffers"`
+}
+
+// createAnsweringPeer creates or updates answering peer and returns offers made to it
+func (h *Handler) createAnsweringPeer(w http.ResponseWriter, r *http.Request) {
+	req := peerhub.CreateAnsweringPeerRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	if name := r.PathValue("name"); name != "" {
+		req.Name = name
+	}
+
+	ap, err := h.hub.CreateAnsweringPeer(r.Context(), req)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.boxes.Open(r.Context(), mailbox.Answering, ap.Name)
+
+	offers, fOffers, err := h.hub.OffersForAnsweringPeer(r.Context(), ap)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	for _, offer := range offers {
+		// let the offering peer know the offer id so it can start trickling candidates
+		h.push(r.Context(), mailbox.Peer{}, mailbox.Offering, offer.OfferingPeer, pushOfferCreated, offer)
+	}
+	for _, fo := range fOffers {
+		// the offering peer learns its offer failed, not only the answering peer
+		h.push(r.Context(), mailbox.Peer{}, mailbox.Offering, fo.OfferingPeer, pushOfferFailed, fo)
+	}
+
+	commands.WriteJSON(w, http.StatusOK, createAnsweringPeerResponse{
+		Name:         ap.Name,
+		Offers:       offers,
+		FailedOffers: fOffers,
+	})
+}
+
+func (h *Handler) deleteAnsweringPeer(w http.ResponseWriter, r *http.Request) {
+	err := h.hub.DeleteAnsweringPeer(r.Context(), peerhub.DeleteAnsweringPeerRequest{
+		Name:          r.PathValue("name"),
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	// the peer may have been deleted by another hub, its mailbox here is gone either way
+	if err == nil || errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		h.boxes.Close(mailbox.Answering, r.PathValue("name"))
+	}
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) getPendingOffers(w http.ResponseWriter, r *http.Request) {
+	offers, err := h.hub.GetPendingOffers(r.Context(), peerhub.GetPendingOffersRequest{
+		Name:          r.PathValue("name"),
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	commands.WriteJSON(w, http.StatusOK, offers)
+}
+
+type createOfferingPeerResponse struct {
+	Name        string               `json:"name"`
+	Offer       *peerhub.Offer       `json:"offer,omitempty"`
+	FailedOffer *peerhub.FailedOffer `json:"failedoffer,omitempty"`
+}
+
+// createOfferingPeer creates or updates offering peer and returns the offer made to its target
+func (h *Handler) createOfferingPeer(w http.ResponseWriter, r *http.Request) {
+	req := peerhub.CreateOfferingPeerRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	if name := r.PathValue("name"); name != "" {
+		req.Name = name
+	}
+
+	op, err := h.hub.CreateOfferingPeer(r.Context(), req)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.boxes.Open(r.Context(), mailbox.Offering, op.Name)
+
+	offer, failed, isOffer, isFailed, err := h.hub.OfferFromOfferingPeer(r.Context(), op)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	resp := createOfferingPeerResponse{Name: op.Name}
+	if isOffer {
+		resp.Offer = &offer
+		h.push(r.Context(), mailbox.Peer{Role: mailbox.Offering, Name: op.Name}, mailbox.Answering, offer.AnsweringPeer, pushOffer, offer)
+	}
+	if isFailed {
+		resp.FailedOffer = &failed
+	}
+
+	commands.WriteJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) deleteOfferingPeer(w http.ResponseWriter, r *http.Request) {
+	err := h.hub.DeleteOfferingPeer(r.Context(), peerhub.DeleteOfferingPeerRequest{
+		Name:          r.PathValue("name"),
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	// the peer may have been deleted by another hub, its mailbox here is gone either way
+	if err == nil || errors.Is(err, peerhub.ErrOfferingPeerNotFound) {
+		h.boxes.Close(mailbox.Offering, r.PathValue("name"))
+	}
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOffer returns the offer to one of its peers, named by the peer query parameter,
+// the offering peer polls it until the answer id is set
+func (h *Handler) getOffer(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("peer")
+	offer, err := h.hub.GetOffer(r.Context(), peerhub.GetOfferRequest{
+		OfferID:  r.PathValue("id"),
+		PeerName: name,
+	})
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	if err := h.authorizeParty(r, name, offer.OfferingPeer, offer.AnsweringPeer); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	commands.WriteJSON(w, http.StatusOK, offer)
+}
+
+// createAnswer answers the offer as its answering peer, named by the peername field and authorized
+// with its management key
+func (h *Handler) createAnswer(w http.ResponseWriter, r *http.Request) {
+	req := peerhub.CreateAnswerRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	_, err := h.hub.AuthorizeAnsweringPeer(r.Context(), peerhub.PeerCredentials{
+		Name:          req.PeerName,
+		ManagementKey: r.Header.Get(managementKeyHeader),
+	})
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	answer, offer, err := h.hub.CreateAnswer(r.Context(), req)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	h.push(r.Context(), mailbox.Peer{Role: mailbox.Answering, Name: answer.AnsweringPeer}, mailbox.Offering, offer.OfferingPeer, pushOfferAnswer, answer)
+
+	commands.WriteJSON(w, http.StatusCreated, answer)
+}
+
+// getAnswer returns the answer to one of the peers of the offer, named by the peer query parameter
+func (h *Handler) getAnswer(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("peer")
+	answer, err := h.hub.GetAnswer(r.Context(), peerhub.GetAnswerRequest{
+		AnswerID: r.PathValue("id"),
+		PeerName: name,
+	})
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	if err := h.authorizeParty(r, name, answer.OfferingPeer, answer.AnsweringPeer); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	commands.WriteJSON(w, http.StatusOK, answer)
+}
+
+// authorizeParty checks management key of the peer in the role it has in the offer,
+// a name used by both peers may be authorized as either of them
+func (h *Handler) authorizeParty(r *http.Request, name, opName, apName string) error {
+	creds := peerhub.PeerCredentials{Name: name, ManagementKey: r.Header.Get(managementKeyHeader)}
+
+	err := peerhub.ErrPeerNotInOffer
+	if name == apName {
+		if _, err = h.hub.AuthorizeAnsweringPeer(r.Context(), creds); err == nil {
+			return nil
+		}
+	}
+	if name == opName {
+		if _, err = h.hub.AuthorizeOfferingPeer(r.Context(), creds); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// push delivers a message to the peer, it's queued if the peer is offline
+func (h *Handler) push(ctx context.Context, from mailbox.Peer, role mailbox.Role, name, mt string, data any) {
+	_, err := h.boxes.DeliverFrom(ctx, from, role, name, mt, data)
+	if err != nil && !errors.Is(err, mailbox.ErrNotFound) {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,166 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+func newTestServer(t *testing.T) (*mailbox.Registry, *httptest.Server) {
+	t.Helper()
+	hub := peerhub.NewHub(peerhub.HubConfig{
+		PeerService:   peer.NewInMemoryService(),
+		SignalService: sig.NewInMemoryService(),
+	})
+	boxes := mailbox.NewRegistry(hub)
+
+	mux := http.NewServeMux()
+	NewHandler(hub, boxes).RegisterHandlers(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return boxes, srv
+}
+
+// do sends the request with the management key and decodes the response into v unless it's nil
+func do(t *testing.T, srv *httptest.Server, method, path, managementKey, body string, v any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(managementKeyHeader, managementKey)
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("response %s: %v", b, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// offer registers ap and op protected by management keys apKey and opKey and returns the offer op made to ap
+func offer(t *testing.T, srv *httptest.Server) peerhub.Offer {
+	t.Helper()
+	if status := do(t, srv, http.MethodPut, "/api/answerings/ap", "", `{"managementkey":"apKey"}`, nil); status != http.StatusOK {
+		t.Fatalf("answering peer registration responded with %d", status)
+	}
+	resp := createOfferingPeerResponse{}
+	if status := do(t, srv, http.MethodPut, "/api/offerings/op", "", `{"targetname":"ap","managementkey":"opKey","sdp":"offer"}`, &resp); status != http.StatusOK {
+		t.Fatalf("offering peer registration responded with %d", status)
+	}
+	if resp.Offer == nil {
+		t.Fatal("no offer was made")
+	}
+	return *resp.Offer
+}
+
+func TestCreateAnswer(t *testing.T) {
+	_, srv := newTestServer(t)
+	o := offer(t, srv)
+
+	tests := []struct {
+		name          string
+		peerName      string
+		managementKey string
+		want          int
+	}{
+		{name: "no management key", peerName: "ap", want: http.StatusForbidden},
+		{name: "wrong management key", peerName: "ap", managementKey: "opKey", want: http.StatusForbidden},
+		{name: "offering peer", peerName: "op", managementKey: "opKey", want: http.StatusNotFound},
+		{name: "unknown peer", peerName: "nobody", want: http.StatusNotFound},
+		{name: "answering peer", peerName: "ap", managementKey: "apKey", want: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"offerID":"` + o.ID + `","peername":"` + tt.peerName + `","sdp":"answer"}`
+			if status := do(t, srv, http.MethodPost, "/api/answers", tt.managementKey, body, nil); status != tt.want {
+				t.Errorf("answer responded with %d, want %d", status, tt.want)
+			}
+		})
+	}
+
+	// the answer reaches the offering peer
+	msgs := []mailbox.Message{}
+	if status := do(t, srv, http.MethodGet, "/api/offerings/op/messages?timeout=1s", "opKey", "", &msgs); status != http.StatusOK {
+		t.Fatalf("polling responded with %d", status)
+	}
+	if len(msgs) != 1 || msgs[0].Type != pushOfferAnswer {
+		t.Errorf("offering peer got %+v, want the answer only", msgs)
+	}
+}
+
+func TestGetOfferRequiresAPeerOfTheOffer(t *testing.T) {
+	_, srv := newTestServer(t)
+	o := offer(t, srv)
+
+	tests := []struct {
+		name          string
+		peerName      string
+		managementKey string
+		want          int
+	}{
+		{name: "wrong management key", peerName: "op", managementKey: "apKey", want: http.StatusForbidden},
+		{name: "peer outside the offer", peerName: "other", want: http.StatusForbidden},
+		{name: "offering peer", peerName: "op", managementKey: "opKey", want: http.StatusOK},
+		{name: "answering peer", peerName: "ap", managementKey: "apKey", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := peerhub.Offer{}
+			status := do(t, srv, http.MethodGet, "/api/offers/"+o.ID+"?peer="+tt.peerName, tt.managementKey, "", &got)
+			if status != tt.want {
+				t.Errorf("offer responded with %d, want %d", status, tt.want)
+			}
+			if status == http.StatusOK && got.ID != o.ID {
+				t.Errorf("got offer %s, want %s", got.ID, o.ID)
+			}
+		})
+	}
+}
+
+func TestDeletePeerClosesItsMailbox(t *testing.T) {
+	boxes, srv := newTestServer(t)
+	offer(t, srv)
+
+	if status := do(t, srv, http.MethodDelete, "/api/answerings/ap", "opKey", "", nil); status != http.StatusForbidden {
+		t.Errorf("delete with a wrong key responded with %d, want 403", status)
+	}
+	if _, ok := boxes.Get(mailbox.Answering, "ap"); !ok {
+		t.Error("mailbox of a peer that wasn't deleted was closed")
+	}
+
+	if status := do(t, srv, http.MethodDelete, "/api/answerings/ap", "apKey", "", nil); status != http.StatusNoContent {
+		t.Errorf("delete responded with %d, want 204", status)
+	}
+	if _, ok := boxes.Get(mailbox.Answering, "ap"); ok {
+		t.Error("mailbox of the deleted peer is open")
+	}
+
+	if status := do(t, srv, http.MethodDelete, "/api/offerings/op", "opKey", "", nil); status != http.StatusNoContent {
+		t.Errorf("delete responded with %d, want 204", status)
+	}
+	if _, ok := boxes.Get(mailbox.Offering, "op"); ok {
+		t.Error("mailbox of the deleted peer is open")
+	}
+}
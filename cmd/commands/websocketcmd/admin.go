@@ -0,0 +1,154 @@
+package websocketcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+var errNothingToKick = errors.New("no connection to kick")
+
+type kickRequest struct {
+	MasterPassword string `json:"masterpassword"`
+	AnsweringPeer  string `json:"answeringpeer"`
+	OfferingPeer   string `json:"offeringpeer"`
+}
+
+func (h *handler) handleAdminGetOfferingPeers(ctx context.Context, w *writer, req peerhub.AdminRequest) error {
+	ops, err := h.hub.GetOfferingPeers(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error getting offering peers: %w", err)
+	}
+	return w.Write(messageTypeOfferingPeers, ops)
+}
+
+func (h *handler) handleAdminDeleteAnsweringPeer(ctx context.Context, w *writer, req peerhub.ForceDeletePeerRequest) error {
+	if err := h.forceDeleteA(ctx, req, w.conn); err != nil {
+		return fmt.Errorf("error deleting answering peer: %w", err)
+	}
+	return w.Ack(fmt.Sprintf("answering peer %s deleted", req.Name))
+}
+
+func (h *handler) handleAdminDeleteOfferingPeer(ctx context.Context, w *writer, req peerhub.ForceDeletePeerRequest) error {
+	if err := h.forceDeleteO(ctx, req, w.conn); err != nil {
+		return fmt.Errorf("error deleting offering peer: %w", err)
+	}
+	return w.Ack(fmt.Sprintf("offering peer %s deleted", req.Name))
+}
+
+// forceDeleteA deletes answering peer regardless of its management key, closes its mailbox and its connection
+// unless it is the skip connection so the peer can't keep signalling
+func (h *handler) forceDeleteA(ctx context.Context, req peerhub.ForceDeletePeerRequest, skip *connection) error {
+	if err := h.hub.ForceDeleteAnsweringPeer(ctx, req); err != nil {
+		return err
+	}
+	h.boxes.Close(mailbox.Answering, req.Name)
+	return h.wc.deleteA(req.Name, skip)
+}
+
+// forceDeleteO deletes offering peer regardless of its management key, closes its mailbox and its connection
+// unless it is the skip connection so the peer can't keep signalling
+func (h *handler) forceDeleteO(ctx context.Context, req peerhub.ForceDeletePeerRequest, skip *connection) error {
+	if err := h.hub.ForceDeleteOfferingPeer(ctx, req); err != nil {
+		return err
+	}
+	h.boxes.Close(mailbox.Offering, req.Name)
+	return h.wc.deleteO(req.Name, skip)
+}
+
+func (h *handler) handleAdminKick(ctx context.Context, w *writer, req kickRequest) error {
+	if err := h.kick(req); err != nil {
+		return fmt.Errorf("error kicking connection: %w", err)
+	}
+	return w.Ack("connection kicked")
+}
+
+func (h *handler) handleAdminStats(ctx context.Context, w *writer, req peerhub.AdminRequest) error {
+	stats, err := h.hub.Stats(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error getting stats: %w", err)
+	}
+	return w.Write(messageTypeStats, stats)
+}
+
+// kick closes connections of the requested peers, their cleanup is left to the disconnect handling
+func (h *handler) kick(req kickRequest) error {
+	if err := h.hub.AuthorizeAdmin(req.MasterPassword); err != nil {
+		return err
+	}
+
+	kicked := false
+	errs := []error{}
+	if aW, ok := h.wc.getA(req.AnsweringPeer); ok && req.AnsweringPeer != "" {
+		kicked = true
+		errs = append(errs, aW.conn.Close())
+	}
+	if oW, ok := h.wc.getO(req.OfferingPeer); ok && req.OfferingPeer != "" {
+		kicked = true
+		errs = append(errs, oW.conn.Close())
+	}
+	if !kicked {
+		return errNothingToKick
+	}
+
+	return errors.Join(errs...)
+}
+
+func (h *handler) adminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		commands.WriteError(w, commands.ErrMethodNotAllowed)
+		return
+	}
+
+	req := kickRequest{}
+	if err := commands.DecodeJSON(r.Body, &req); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+	req.MasterPassword = commands.MasterPassword(r)
+
+	err := h.kick(req)
+	if errors.Is(err, errNothingToKick) {
+		commands.WriteErrorStatus(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminDeleteAnswering deletes answering peer named by the {name} path value
+func (h *handler) adminDeleteAnswering(w http.ResponseWriter, r *http.Request) {
+	err := h.forceDeleteA(r.Context(), peerhub.ForceDeletePeerRequest{
+		MasterPassword: commands.MasterPassword(r),
+		Name:           r.PathValue("name"),
+	}, nil)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminDeleteOffering deletes offering peer named by the {name} path value
+func (h *handler) adminDeleteOffering(w http.ResponseWriter, r *http.Request) {
+	err := h.forceDeleteO(r.Context(), peerhub.ForceDeletePeerRequest{
+		MasterPassword: commands.MasterPassword(r),
+		Name:           r.PathValue("name"),
+	}, nil)
+	if err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package websocketcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+)
+
+// registerA caches answering peer's writer along with the version the registration stored the peer at
+// and starts pumping its mailbox into the connection
+func (h *handler) registerA(ctx context.Context, name string, version int, w *writer) error {
+	if err := h.wc.setA(name, w, version, true); err != nil {
+		return fmt.Errorf("error caching peer's connection: %w", err)
+	}
+
+	h.startPump(ctx, mailbox.Answering, name, w.conn)
+
+	return nil
+}
+
+// registerO caches offering peer's writer along with the version the registration stored the peer at
+// and starts pumping its mailbox into the connection
+func (h *handler) registerO(ctx context.Context, name string, version int, w *writer) error {
+	if err := h.wc.setO(name, w, version, true); err != nil {
+		return fmt.Errorf("error caching peer's connection: %w", err)
+	}
+
+	h.startPump(ctx, mailbox.Offering, name, w.conn)
+
+	return nil
+}
+
+// startPump pumps peer's mailbox into the connection unless it's already pumped into the current websocket
+// of the connection, a peer registered again over the same connection keeps its pump
+func (h *handler) startPump(ctx context.Context, role mailbox.Role, name string, conn *connection) {
+	k := pumpKey{role: role, name: name, conn: conn}
+	wsCtx := conn.context()
+	if !h.pumps.start(wsCtx, k) {
+		return
+	}
+
+	go func() {
+		defer h.pumps.stop(wsCtx, k)
+		h.pump(wsCtx, h.boxes.Open(ctx, role, name), conn)
+	}()
+}
+
+// pump writes messages from the mailbox to the connection until the websocket of ctx detaches,
+// it pauses while another consumer is attached to the mailbox
+func (h *handler) pump(ctx context.Context, box *mailbox.Mailbox, conn *connection) {
+	c := box.Attach()
+	defer c.Detach()
+
+	w := newWriter(conn, "")
+	for {
+		msgs, err := c.Wait(ctx)
+		if err != nil {
+			return
+		}
+
+		for i, msg := range msgs {
+			if err := w.Write(messageType(msg.Type), msg.Data); err != nil {
+				fmt.Println(err)
+				// the peer gets the rest over whichever transport it uses next
+				c.Requeue(msgs[i:])
+				return
+			}
+			c.Delivered(msg)
+		}
+	}
+}
+
+// deliverA pushes a message to answering peer over whichever transport it uses,
+// the message is queued if the peer is offline and the sender gets a receipt once it's delivered
+func (h *handler) deliverA(ctx context.Context, from mailbox.Peer, name string, mt messageType, data any) (bool, error) {
+	queued, err := h.boxes.DeliverFrom(ctx, from, mailbox.Answering, name, string(mt), data)
+	if errors.Is(err, mailbox.ErrNotFound) {
+		return false, fmt.Errorf("could not find answering peer: %w", err)
+	}
+	return queued, err
+}
+
+// deliverO pushes a message to offering peer over whichever transport it uses,
+// the message is queued if the peer is offline and the sender gets a receipt once it's delivered
+func (h *handler) deliverO(ctx context.Context, from mailbox.Peer, name string, mt messageType, data any) (bool, error) {
+	queued, err := h.boxes.DeliverFrom(ctx, from, mailbox.Offering, name, string(mt), data)
+	if errors.Is(err, mailbox.ErrNotFound) {
+		return false, fmt.Errorf("could not find offering peer: %w", err)
+	}
+	return queued, err
+}
+
+type pumpKey struct {
+	role mailbox.Role
+	name string
+	conn *connection
+}
+
+// pumpSet tracks running pumps by the context of the websocket they write to, a resumed connection
+// gets new pumps while those of its previous websocket are stopping
+type pumpSet struct {
+	mu      sync.Mutex
+	running map[pumpKey]context.Context
+}
+
+func newPumpSet() *pumpSet {
+	return &pumpSet{
+		mu:      sync.Mutex{},
+		running: map[pumpKey]context.Context{},
+	}
+}
+
+// start reports whether a pump has to be started, it's false if one already writes to the websocket of ctx
+func (s *pumpSet) start(ctx context.Context, k pumpKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if running, ok := s.running[k]; ok && running == ctx && ctx.Err() == nil {
+		return false
+	}
+	s.running[k] = ctx
+	return true
+}
+
+// stop forgets the pump unless it was already replaced by a pump of a newer websocket
+func (s *pumpSet) stop(ctx context.Context, k pumpKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[k] == ctx {
+		delete(s.running, k)
+	}
+}
@@ -1,6 +1,7 @@
 package websocketcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -8,6 +9,10 @@ import (
 
 	"github.com/H3Cki/peerhub"
 	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/federation"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/H3Cki/peerhub/cmd/commands/rest"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -16,107 +21,323 @@ var upgrader = websocket.Upgrader{
 }
 
 type handler struct {
-	hub *peerhub.Hub
-	wc  *writerCache
+	// ctx is cancelled once the server shuts down
+	ctx      context.Context
+	hub      *peerhub.Hub
+	wc       *writerCache
+	sessions *sessionCache
+	boxes    *mailbox.Registry
+	// pumps write mailboxes of registered peers into their connections
+	pumps *pumpSet
+	// fed connects the hub to partner hubs, nil if the hub is not federated
+	fed *federation.Federation
+	// disconnectGrace is the time peers of a dropped connection have to reconnect before they are cleaned up
+	disconnectGrace time.Duration
+	// disconnectDelete deletes peers of a dropped connection instead of marking them offline
+	disconnectDelete bool
+	// messageTimeout bounds the time handling a single message can take
+	messageTimeout time.Duration
+	router         *router
+	metrics        *messageMetrics
 }
 
 func (h *handler) registerHandlers(mux *http.ServeMux) {
-	mux.HandleFunc("/answerings", commands.AnsweringsHandler(h.hub))
+	if h.fed != nil {
+		mux.HandleFunc("/answerings", commands.AnsweringsHandler(h.hub, h.fed.Previews))
+		h.fed.RegisterHandlers(mux, h.hub, h.boxes)
+	} else {
+		mux.HandleFunc("/answerings", commands.AnsweringsHandler(h.hub))
+	}
 	mux.HandleFunc("/hub", h.wsHub)
+
+	mux.HandleFunc("/admin/offerings", commands.AdminOfferingsHandler(h.hub))
+	mux.HandleFunc("/admin/stats", commands.AdminStatsHandler(h.hub))
+	mux.HandleFunc("/admin/messages", h.adminMessages)
+	mux.HandleFunc("DELETE /admin/answerings/{name}", h.adminDeleteAnswering)
+	mux.HandleFunc("DELETE /admin/offerings/{name}", h.adminDeleteOffering)
+	mux.HandleFunc("/admin/kick", h.adminKick)
+
+	rest.NewHandler(h.hub, h.boxes).RegisterHandlers(mux)
 }
 
 func (h *handler) wsHub(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// a failed upgrade is responded to by the upgrader
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	conn, err := h.attach(ws, r)
 	if err != nil {
 		fmt.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		ws.WriteJSON(message{Type: messageTypeError, ID: uuid.NewString(), Data: errorData(err)})
+		ws.Close()
 		return
 	}
 
+	defer func() {
+		ws.Close()
+		conn.detach(ws)
+		h.handleDisconnect(conn)
+	}()
+
 	for {
-		msg := message{}
-		if err := conn.ReadJSON(&msg); err != nil {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		if err := h.handleMessage(conn, msg); err != nil {
+		if err := h.handleFrame(conn, data); err != nil {
 			fmt.Println(err)
 		}
 	}
 }
 
-func (h *handler) handleMessage(conn *websocket.Conn, msg message) error {
-	w := newWriter(conn, msg.Conv)
-
-	switch msg.Type {
-	case messageTypeCreateAnsweringPeer:
-		req := peerhub.CreateAnsweringPeerRequest{}
-		if err := msg.UnmarshalData(&req); err != nil {
-			err = errors.Join(err, w.Error(err))
-			return err
+// attach starts a new session for the websocket or resumes the one named by the resume query parameter,
+// resumed session keeps its peers and gets messages sequenced after the seq query parameter replayed,
+// the session is registered only once the websocket took the session message
+func (h *handler) attach(ws *websocket.Conn, r *http.Request) (*connection, error) {
+	token := r.URL.Query().Get("resume")
+	if token == "" {
+		conn := newConnection(ws)
+		if err := ws.WriteJSON(newSessionMessage(conn.token, 0)); err != nil {
+			conn.Close()
+			return nil, err
 		}
-		err := h.handleCreateAnsweringPeer(w, req)
-		if err != nil {
-			err = errors.Join(err, w.Error(err))
-		}
-		return err
-	case messageTypeCreateOfferingPeer:
-		req := peerhub.CreateOfferingPeerRequest{}
-		if err := msg.UnmarshalData(&req); err != nil {
-			err = errors.Join(err, w.Error(err))
-			return err
+		h.sessions.set(conn)
+		return conn, nil
+	}
+
+	lastSeq, err := parseSeq(r.URL.Query().Get("seq"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sequence number: %w", err)
+	}
+
+	conn, ok := h.sessions.get(token)
+	if !ok {
+		return nil, errSessionNotFound
+	}
+
+	if err := conn.resume(ws, lastSeq); err != nil {
+		return nil, fmt.Errorf("error resuming session: %w", err)
+	}
+
+	// pumps stopped when the previous websocket detached
+	ctx := r.Context()
+	aps, ops := h.wc.peersOf(conn)
+	for _, name := range aps {
+		h.startPump(ctx, mailbox.Answering, name, conn)
+	}
+	for _, name := range ops {
+		h.startPump(ctx, mailbox.Offering, name, conn)
+	}
+
+	return conn, nil
+}
+
+// handleDisconnect schedules cleanup of the session and peers registered over it,
+// nothing is cleaned up if the session was resumed within the grace period
+// and peers that registered over a new connection are left intact
+func (h *handler) handleDisconnect(conn *connection) {
+	time.AfterFunc(h.disconnectGrace, func() {
+		if !conn.expire() {
+			return
 		}
-		err := h.handleCreateOfferingPeer(w, req)
-		if err != nil {
-			err = errors.Join(err, w.Error(err))
+		h.sessions.remove(conn.token)
+
+		// the connection is gone, cleanup is not bound to any request
+		ctx := context.Background()
+		aps, ops := h.wc.peersOf(conn)
+		for _, name := range aps {
+			version, ok := h.wc.removeA(name, conn)
+			if !ok {
+				continue
+			}
+			h.boxes.Close(mailbox.Answering, name)
+			if err := h.disconnectAnsweringPeer(ctx, name, version); err != nil {
+				fmt.Println(err)
+			}
 		}
-		return err
-	case messageTypeOfferAnswer:
-		req := peerhub.CreateAnswerRequest{}
-		if err := msg.UnmarshalData(&req); err != nil {
-			return err
+
+		for _, name := range ops {
+			version, ok := h.wc.removeO(name, conn)
+			if !ok {
+				continue
+			}
+			h.boxes.Close(mailbox.Offering, name)
+			if err := h.hub.DisconnectOfferingPeer(ctx, name, version, h.disconnectDelete); err != nil {
+				fmt.Println(fmt.Errorf("error disconnecting offering peer: %w", err))
+			}
 		}
-		err := h.handleCreateAnswer(w, req)
-		if err != nil {
-			err = errors.Join(err, w.Error(err))
+	})
+}
+
+// disconnectAnsweringPeer cleans up answering peer still at the version and notifies offering peers which had pending offers to it
+func (h *handler) disconnectAnsweringPeer(ctx context.Context, name string, version int) error {
+	offers, err := h.hub.DisconnectAnsweringPeer(ctx, name, version, h.disconnectDelete)
+	if err != nil {
+		return fmt.Errorf("error disconnecting answering peer: %w", err)
+	}
+
+	errs := []error{}
+	for _, offer := range offers {
+		_, err := h.deliverO(ctx, mailbox.Peer{}, offer.OfferingPeer, messageTypeAnsweringPeerDisconnected, offer)
+		if err != nil && !errors.Is(err, mailbox.ErrNotFound) {
+			errs = append(errs, err)
 		}
-		return err
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
-// handleCreateAnsweringPeer creates answering peer and sends all matching offers to it
-func (h *handler) handleCreateAnsweringPeer(apWriter *writer, req peerhub.CreateAnsweringPeerRequest) error {
-	// create ap
-	ap, err := h.hub.CreateAnsweringPeer(req)
+// sweep periodically deletes expired offers and answers and notifies offering peers about expired offers
+func (h *handler) sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			offers, err := h.hub.DeleteExpired(ctx, now)
+			if err != nil {
+				fmt.Println(fmt.Errorf("error deleting expired offers: %w", err))
+				continue
+			}
+
+			for _, offer := range offers {
+				_, err := h.deliverO(ctx, mailbox.Peer{}, offer.OfferingPeer, messageTypeOfferExpired, offer)
+				if err != nil && !errors.Is(err, mailbox.ErrNotFound) {
+					fmt.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// handleFrame decodes and routes a message of the connection, store calls it makes give up after the message timeout,
+// once the websocket detaches or the server shuts down, every frame gets exactly one ack or error reply,
+// a frame that fails to decode is routed as invalid and answered like any other invalid request, the connection stays up
+func (h *handler) handleFrame(conn *connection, data []byte) error {
+	ctx, cancel := context.WithTimeout(conn.context(), h.messageTimeout)
+	defer cancel()
+	stop := context.AfterFunc(h.ctx, cancel)
+	defer stop()
+
+	msg, err := decodeMessage(data)
+	w := newWriter(conn, msg.Conv)
 	if err != nil {
-		return fmt.Errorf("error creating answering peer: %w", err)
+		err = h.router.routeInvalid(ctx, w, err)
+	} else {
+		err = h.router.route(ctx, w, msg)
 	}
 
-	if err := h.wc.setA(ap.Name, apWriter, true); err != nil {
-		return fmt.Errorf("error caching peer's connection: %w", err)
+	// errors of handlers are logged by the middleware, only errors of replying are returned
+	if err != nil {
+		return w.Error(err)
 	}
 
-	err = apWriter.Write(messageTypeInfo, genericMessage{
-		Message: fmt.Sprintf("answering peer %s created", ap.Name),
+	// handlers ack with a message of their own, the rest is acked here
+	return w.Ack("")
+}
+
+// registerRoutes registers handlers of all inbound message types, handlers reply with results of the request
+// and may ack it, errors they return are the error reply
+func (h *handler) registerRoutes(r *router) {
+	handle(r, messageTypeCreateAnsweringPeer, h.handleCreateAnsweringPeer)
+	handle(r, messageTypeCreateOfferingPeer, h.handleCreateOfferingPeer)
+	handle(r, messageTypeDeleteAnsweringPeer, h.handleDeleteAnsweringPeer)
+	handle(r, messageTypeDeleteOfferingPeer, h.handleDeleteOfferingPeer)
+	handle(r, messageTypeRotateAnsweringKeys, h.handleRotateAnsweringPeerKeys)
+	handle(r, messageTypeRotateOfferingKey, h.handleRotateOfferingPeerKey)
+	handle(r, messageTypeOfferAnswer, h.handleCreateAnswer)
+	handle(r, messageTypeDealAnswerRejected, h.handleRejectOffer)
+	handle(r, messageTypeDealAnswerError, func(ctx context.Context, w *writer, req peerhub.RejectOfferRequest) error {
+		req.Reason = peerhub.RejectReasonError
+		return h.handleRejectOffer(ctx, w, req)
+	})
+	handle(r, messageTypeGetAnswer, h.handleGetAnswer)
+	handle(r, messageTypeICECandidate, h.handleCreateCandidate)
+	handle(r, messageTypeEndOfCandidates, func(ctx context.Context, w *writer, req peerhub.CreateCandidateRequest) error {
+		req.EndOfCandidates = true
+		return h.handleCreateCandidate(ctx, w, req)
 	})
+
+	handleAdmin(r, messageTypeAdminGetOfferingPeers, h.handleAdminGetOfferingPeers)
+	handleAdmin(r, messageTypeAdminDeleteAnsweringPeer, h.handleAdminDeleteAnsweringPeer)
+	handleAdmin(r, messageTypeAdminDeleteOfferingPeer, h.handleAdminDeleteOfferingPeer)
+	handleAdmin(r, messageTypeAdminKick, h.handleAdminKick)
+	handleAdmin(r, messageTypeAdminStats, h.handleAdminStats)
+}
+
+// handleRotateAnsweringPeerKeys replaces keys of answering peer
+func (h *handler) handleRotateAnsweringPeerKeys(ctx context.Context, w *writer, req peerhub.RotateAnsweringPeerKeysRequest) error {
+	ap, err := h.hub.RotateAnsweringPeerKeys(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error rotating answering peer keys: %w", err)
+	}
+	h.wc.setVersionA(ap.Name, ap.Version)
+	return w.Ack(fmt.Sprintf("answering peer %s keys rotated", req.Name))
+}
+
+// handleRotateOfferingPeerKey replaces management key of offering peer
+func (h *handler) handleRotateOfferingPeerKey(ctx context.Context, w *writer, req peerhub.RotateOfferingPeerKeyRequest) error {
+	op, err := h.hub.RotateOfferingPeerKey(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error rotating offering peer key: %w", err)
+	}
+	h.wc.setVersionO(op.Name, op.Version)
+	return w.Ack(fmt.Sprintf("offering peer %s key rotated", req.Name))
+}
+
+// handleCreateAnsweringPeer creates answering peer and sends all matching offers to it
+func (h *handler) handleCreateAnsweringPeer(ctx context.Context, apWriter *writer, req peerhub.CreateAnsweringPeerRequest) error {
+	// create ap
+	ap, err := h.hub.CreateAnsweringPeer(ctx, req)
 	if err != nil {
+		return fmt.Errorf("error creating answering peer: %w", err)
+	}
+
+	if err := h.registerA(ctx, ap.Name, ap.Version, apWriter); err != nil {
 		return err
 	}
 
 	// create ap deals
-	offers, fOffers, err := h.hub.OffersForAnsweringPeer(ap)
+	offers, fOffers, err := h.hub.OffersForAnsweringPeer(ctx, ap)
 	if err != nil {
 		return fmt.Errorf("error getting offers for answering peer: %w", err)
 	}
 
-	if err := h.sendOffers(apWriter, offers, fOffers); err != nil {
+	if err := apWriter.Ack(fmt.Sprintf("answering peer %s created", ap.Name)); err != nil {
+		return err
+	}
+
+	// offers are pushed, they are not replies to the registration
+	if err := h.sendOffers(newWriter(apWriter.conn, ""), offers, fOffers); err != nil {
 		return err
 	}
 
-	return nil
+	errs := []error{}
+	for _, offer := range offers {
+		// let the offering peer know the offer id so it can start trickling candidates
+		_, err := h.deliverO(ctx, mailbox.Peer{}, offer.OfferingPeer, messageTypeOfferCreated, offer)
+		if err != nil && !errors.Is(err, mailbox.ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, fo := range fOffers {
+		// the offering peer learns its offer failed, not only the answering peer
+		_, err := h.deliverO(ctx, mailbox.Peer{}, fo.OfferingPeer, messageTypeOfferFailed, fo)
+		if err != nil && !errors.Is(err, mailbox.ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func (h *handler) sendOffers(w *writer, offers []peerhub.Offer, fOffers []peerhub.FailedOffer) error {
@@ -141,49 +362,38 @@ func (h *handler) sendOffers(w *writer, offers []peerhub.Offer, fOffers []peerhu
 }
 
 // handleCreateOfferingPeer creates offering peer and sends an offer to matched answering if such was found
-func (h *handler) handleCreateOfferingPeer(opWriter *writer, req peerhub.CreateOfferingPeerRequest) error {
+func (h *handler) handleCreateOfferingPeer(ctx context.Context, opWriter *writer, req peerhub.CreateOfferingPeerRequest) error {
 	// create op
-	op, err := h.hub.CreateOfferingPeer(req)
+	op, err := h.hub.CreateOfferingPeer(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error creating answering peer: %w", err)
 	}
 
-	if err := h.wc.setO(op.Name, opWriter, true); err != nil {
-		return fmt.Errorf("error caching peer's connection: %w", err)
-	}
-
-	err = opWriter.Write(messageTypeInfo, genericMessage{
-		Message: fmt.Sprintf("offering peer %s created", op.Name),
-	})
-	if err != nil {
+	if err := h.registerO(ctx, op.Name, op.Version, opWriter); err != nil {
 		return err
 	}
 
-	offer, failed, isOffer, isFailed, err := h.hub.OfferFromOfferingPeer(op)
+	offer, failed, isOffer, isFailed, err := h.hub.OfferFromOfferingPeer(ctx, op)
 	if err != nil {
 		return err
 	}
 
 	if isOffer {
-		err = opWriter.Info(fmt.Sprintf("offer for peer %s created", offer.AnsweringPeer))
+		err = opWriter.Write(messageTypeOfferCreated, offer)
 		if err != nil {
 			fmt.Println(err)
 		}
 
-		// send offer to ap
-		apW, ok := h.wc.getA(offer.AnsweringPeer)
-		if !ok {
-			return fmt.Errorf("could not find connection to answering peer")
-		}
-
-		apwErr := apW.Conv("").Write(messageOffer, offer)
+		// send offer to ap, it's queued if the ap is offline
+		queued, err := h.deliverA(ctx, mailbox.Peer{Role: mailbox.Offering, Name: op.Name}, offer.AnsweringPeer, messageOffer, offer)
 		if err != nil {
-			opwErr := opWriter.Error(errors.New("error sending offer to answering peer"))
-			return errors.Join(err, apwErr, opwErr)
+			return fmt.Errorf("error sending offer to answering peer: %w", err)
 		}
 
-		opwErr := opWriter.Info(fmt.Sprintf("offer for peer %s sent", offer.AnsweringPeer))
-		return errors.Join(err, opwErr)
+		if queued {
+			return opWriter.Ack(fmt.Sprintf("answering peer %s is offline, offer queued", offer.AnsweringPeer))
+		}
+		return opWriter.Ack(fmt.Sprintf("offer for peer %s sent", offer.AnsweringPeer))
 	}
 
 	if isFailed {
@@ -193,33 +403,161 @@ func (h *handler) handleCreateOfferingPeer(opWriter *writer, req peerhub.CreateO
 		}
 	}
 
-	return nil
+	return opWriter.Ack(fmt.Sprintf("offering peer %s created", op.Name))
 }
 
-// handleCreateAnswer creates an answer and sends it to the offering peer
-func (h *handler) handleCreateAnswer(w *writer, req peerhub.CreateAnswerRequest) error {
-	answer, offer, err := h.hub.CreateAnswer(req)
+// handleDeleteAnsweringPeer deletes answering peer and closes its connection unless it's the requesting one
+func (h *handler) handleDeleteAnsweringPeer(ctx context.Context, w *writer, req peerhub.DeleteAnsweringPeerRequest) error {
+	if err := h.hub.DeleteAnsweringPeer(ctx, req); err != nil {
+		return fmt.Errorf("error deleting answering peer: %w", err)
+	}
+
+	wErr := w.Ack(fmt.Sprintf("answering peer %s deleted", req.Name))
+	cErr := h.wc.deleteA(req.Name, w.conn)
+	h.boxes.Close(mailbox.Answering, req.Name)
+
+	return errors.Join(wErr, cErr)
+}
+
+// handleDeleteOfferingPeer deletes offering peer and closes its connection unless it's the requesting one
+func (h *handler) handleDeleteOfferingPeer(ctx context.Context, w *writer, req peerhub.DeleteOfferingPeerRequest) error {
+	if err := h.hub.DeleteOfferingPeer(ctx, req); err != nil {
+		return fmt.Errorf("error deleting offering peer: %w", err)
+	}
+
+	wErr := w.Ack(fmt.Sprintf("offering peer %s deleted", req.Name))
+	cErr := h.wc.deleteO(req.Name, w.conn)
+	h.boxes.Close(mailbox.Offering, req.Name)
+
+	return errors.Join(wErr, cErr)
+}
+
+// handleCreateAnswer creates an answer and sends it to the offering peer,
+// the answering peer must be registered on the connection
+func (h *handler) handleCreateAnswer(ctx context.Context, w *writer, req peerhub.CreateAnswerRequest) error {
+	if !h.wc.ownsA(req.PeerName, w.conn) {
+		return fmt.Errorf("error creating answer: %w", errPeerNotRegistered)
+	}
+
+	answer, offer, err := h.hub.CreateAnswer(ctx, req)
 	if err != nil {
 		return fmt.Errorf("error creating answer: %w", err)
 	}
 
-	if err := h.wc.setA(answer.AnsweringPeer, w, true); err != nil {
-		return fmt.Errorf("error caching peer's connection: %w", err)
+	// send answer to op, it's queued if the op is offline
+	_, err = h.deliverO(ctx, mailbox.Peer{Role: mailbox.Answering, Name: answer.AnsweringPeer}, offer.OfferingPeer, messageTypeOfferAnswer, answer)
+	if err != nil {
+		return fmt.Errorf("error sending answer to offering peer: %w", err)
 	}
 
-	// send answer to op
-	opW, ok := h.wc.getO(offer.OfferingPeer)
-	if !ok {
-		return fmt.Errorf("could not find connection to offering peer")
+	wErr := w.Write(messageTypeAnswer, answer)
+
+	// candidates of the answering peer were held back until the answer was delivered
+	fErr := h.flushCandidates(ctx, offer, offer.OfferingPeer)
+
+	return errors.Join(wErr, fErr)
+}
+
+// handleRejectOffer clears the rejected offer and forwards the rejection to the offering peer,
+// the rejecting peer must be registered on the connection
+func (h *handler) handleRejectOffer(ctx context.Context, w *writer, req peerhub.RejectOfferRequest) error {
+	if !h.wc.ownsA(req.PeerName, w.conn) {
+		return fmt.Errorf("error rejecting offer: %w", errPeerNotRegistered)
+	}
+
+	rejected, err := h.hub.RejectOffer(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error rejecting offer: %w", err)
+	}
+
+	mt := messageTypeDealAnswerRejected
+	if rejected.Reason == peerhub.RejectReasonError {
+		mt = messageTypeDealAnswerError
+	}
+
+	from := mailbox.Peer{Role: mailbox.Answering, Name: rejected.AnsweringPeer}
+	if _, err := h.deliverO(ctx, from, rejected.OfferingPeer, mt, rejected); err != nil {
+		return fmt.Errorf("error sending rejection to offering peer: %w", err)
+	}
+
+	return w.Ack(fmt.Sprintf("rejection sent to %s", rejected.OfferingPeer))
+}
+
+// handleGetAnswer sends a stored answer back to one of the peers of the offer, the peer must be registered
+// on the connection
+func (h *handler) handleGetAnswer(ctx context.Context, w *writer, req peerhub.GetAnswerRequest) error {
+	answer, err := h.hub.GetAnswer(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error getting answer: %w", err)
+	}
+
+	if !h.ownsParty(w.conn, req.PeerName, answer.OfferingPeer, answer.AnsweringPeer) {
+		return fmt.Errorf("error getting answer: %w", errPeerNotRegistered)
+	}
+
+	return w.Write(messageTypeAnswer, answer)
+}
+
+// handleCreateCandidate buffers a candidate and relays it to the counterpart peer if it can already receive it,
+// the sending peer must be registered on the connection
+func (h *handler) handleCreateCandidate(ctx context.Context, w *writer, req peerhub.CreateCandidateRequest) error {
+	offer, err := h.hub.GetOffer(ctx, peerhub.GetOfferRequest{OfferID: req.OfferID, PeerName: req.PeerName})
+	if err != nil {
+		return fmt.Errorf("error creating candidate: %w", err)
+	}
+
+	if !h.ownsParty(w.conn, req.PeerName, offer.OfferingPeer, offer.AnsweringPeer) {
+		return fmt.Errorf("error creating candidate: %w", errPeerNotRegistered)
+	}
+
+	cand, offer, err := h.hub.CreateCandidate(ctx, req)
+	if err != nil {
+		return fmt.Errorf("error creating candidate: %w", err)
+	}
+
+	return h.flushCandidates(ctx, offer, cand.To)
+}
+
+// ownsParty tells if the connection registered the peer in the role it has in the offer
+func (h *handler) ownsParty(conn *connection, name, opName, apName string) bool {
+	return (name == apName && h.wc.ownsA(name, conn)) || (name == opName && h.wc.ownsO(name, conn))
+}
+
+// flushCandidates sends candidates buffered for the peer, they stay buffered if the peer is not connected,
+// candidates for peers of other hubs are sent to their hub
+func (h *handler) flushCandidates(ctx context.Context, offer peerhub.Offer, peerName string) error {
+	role := mailbox.Offering
+	if peerName == offer.AnsweringPeer {
+		role = mailbox.Answering
+	}
+
+	push := func(msg mailbox.Message) error {
+		_, err := h.boxes.DeliverFrom(ctx, mailbox.Peer{}, role, peerName, msg.Type, msg.Data)
+		return err
+	}
+	if !h.boxes.IsRemote(peerName) {
+		box, ok := h.boxes.Get(role, peerName)
+		if !ok {
+			return nil
+		}
+		push = box.Push
 	}
 
-	err = opW.Conv("").Write(messageTypeOfferAnswer, answer)
+	cands, err := h.hub.PendingCandidates(ctx, offer.ID, peerName)
 	if err != nil {
-		wErr := w.Error(errors.New("error sending answer to offering peer"))
-		return errors.Join(err, wErr)
+		return fmt.Errorf("error getting pending candidates: %w", err)
 	}
 
-	wErr := w.Info(fmt.Sprintf("answer sent to %s", offer.OfferingPeer))
+	errs := []error{}
+	for _, cand := range cands {
+		mt := messageTypeICECandidate
+		if cand.EndOfCandidates {
+			mt = messageTypeEndOfCandidates
+		}
+		if err := push(mailbox.Message{Type: string(mt), Data: cand}); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-	return wErr
+	return errors.Join(errs...)
 }
@@ -0,0 +1,297 @@
+package websocketcmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+	"github.com/gorilla/websocket"
+)
+
+// testMessage is a message as the client reads it
+type testMessage struct {
+	Type messageType     `json:"type"`
+	Conv string          `json:"conv"`
+	Data json.RawMessage `json:"data"`
+	Seq  uint64          `json:"seq"`
+}
+
+// newTestServer serves the handler over an in-memory hub, peers of dropped connections are deleted after grace
+func newTestServer(t *testing.T, grace time.Duration) (*handler, string) {
+	t.Helper()
+	hub := peerhub.NewHub(peerhub.HubConfig{
+		PeerService:    peer.NewInMemoryService(),
+		SignalService:  sig.NewInMemoryService(),
+		MasterPassword: "secret",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := &handler{
+		ctx:              ctx,
+		hub:              hub,
+		wc:               newConnCache(),
+		sessions:         newSessionCache(),
+		boxes:            mailbox.NewRegistry(hub),
+		pumps:            newPumpSet(),
+		disconnectGrace:  grace,
+		disconnectDelete: true,
+		messageTimeout:   time.Second,
+		metrics:          newMessageMetrics(),
+	}
+	h.router = newRouter(logMessages, h.metrics.middleware, h.authorizeAdmin)
+	h.registerRoutes(h.router)
+
+	mux := http.NewServeMux()
+	h.registerHandlers(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return h, "ws" + strings.TrimPrefix(srv.URL, "http") + "/hub"
+}
+
+// dial connects to the hub and reads the session message
+func dial(t *testing.T, url string) (*websocket.Conn, sessionMessage) {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	msg := readMessage(t, ws)
+	session := sessionMessage{}
+	if msg.Type != messageTypeSession || json.Unmarshal(msg.Data, &session) != nil {
+		t.Fatalf("first message is %s, want a session", msg.Type)
+	}
+	return ws, session
+}
+
+func readMessage(t *testing.T, ws *websocket.Conn) testMessage {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	msg := testMessage{}
+	if err := ws.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// request sends the message and reads messages until its ack or error, which is returned
+func request(t *testing.T, ws *websocket.Conn, mt messageType, data any) testMessage {
+	t.Helper()
+	if err := ws.WriteJSON(message{Type: mt, Conv: string(mt), Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		msg := readMessage(t, ws)
+		if msg.Conv == string(mt) && (msg.Type == messageTypeAck || msg.Type == messageTypeError) {
+			return msg
+		}
+	}
+}
+
+// eventually polls the condition until it holds or the deadline passes
+func eventually(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDisconnectDeletesPeersAfterGrace(t *testing.T) {
+	h, url := newTestServer(t, 100*time.Millisecond)
+	ws, _ := dial(t, url)
+
+	if msg := request(t, ws, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); msg.Type != messageTypeAck {
+		t.Fatalf("registration replied %s %s", msg.Type, msg.Data)
+	}
+	ws.Close()
+
+	gone := func() bool {
+		_, err := h.hub.AuthorizeAnsweringPeer(context.Background(), peerhub.PeerCredentials{Name: "ap"})
+		return errors.Is(err, peerhub.ErrAnsweringPeerNotFound)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if gone() {
+		t.Fatal("peer was deleted within the grace period")
+	}
+	if !eventually(t, gone) {
+		t.Error("peer outlived the grace period")
+	}
+}
+
+func TestDisconnectDeletesPeersWithRotatedKeys(t *testing.T) {
+	h, url := newTestServer(t, 50*time.Millisecond)
+	ws, _ := dial(t, url)
+
+	request(t, ws, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	key := "key"
+	if msg := request(t, ws, messageTypeRotateAnsweringKeys, peerhub.RotateAnsweringPeerKeysRequest{Name: "ap", NewManagementKey: &key}); msg.Type != messageTypeAck {
+		t.Fatalf("rotation replied %s %s", msg.Type, msg.Data)
+	}
+	ws.Close()
+
+	// the rotation changed the peer through this hub, so it's still the peer the connection registered
+	gone := func() bool {
+		_, err := h.hub.AuthorizeAnsweringPeer(context.Background(), peerhub.PeerCredentials{Name: "ap", ManagementKey: key})
+		return errors.Is(err, peerhub.ErrAnsweringPeerNotFound)
+	}
+	if !eventually(t, gone) {
+		t.Error("peer with rotated keys outlived the grace period")
+	}
+}
+
+func TestOnlyTheAnsweringPeerCanAnswer(t *testing.T) {
+	_, url := newTestServer(t, time.Minute)
+	apWS, _ := dial(t, url)
+	opWS, _ := dial(t, url)
+
+	request(t, apWS, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	if err := opWS.WriteJSON(message{Type: messageTypeCreateOfferingPeer, Data: peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap"}}); err != nil {
+		t.Fatal(err)
+	}
+	// the offering peer learns the offer id
+	msg := readMessage(t, opWS)
+	offer := peerhub.Offer{}
+	if msg.Type != messageTypeOfferCreated || json.Unmarshal(msg.Data, &offer) != nil {
+		t.Fatalf("offering peer got %s, want %s", msg.Type, messageTypeOfferCreated)
+	}
+
+	msg = request(t, opWS, messageTypeOfferAnswer, peerhub.CreateAnswerRequest{OfferID: offer.ID, PeerName: "ap", SDP: "forged"})
+	data := peerhub.ErrorData{}
+	json.Unmarshal(msg.Data, &data)
+	if msg.Type != messageTypeError || data.Code != peerhub.CodeForbidden {
+		t.Errorf("answer over another connection replied %s %+v, want forbidden", msg.Type, data)
+	}
+
+	// the answering peer keeps its connection and can still answer
+	if msg := readMessage(t, apWS); msg.Type != messageOffer {
+		t.Fatalf("answering peer got %s, want an offer", msg.Type)
+	}
+	if msg := request(t, apWS, messageTypeOfferAnswer, peerhub.CreateAnswerRequest{OfferID: offer.ID, PeerName: "ap", SDP: "answer"}); msg.Type != messageTypeAck {
+		t.Errorf("answer of the answering peer replied %s %s", msg.Type, msg.Data)
+	}
+}
+
+func TestAdminDeleteClosesThePeer(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		role mailbox.Role
+		peer string
+	}{
+		{name: "answering", path: "/admin/answerings/ap", role: mailbox.Answering, peer: "ap"},
+		{name: "offering", path: "/admin/offerings/op", role: mailbox.Offering, peer: "op"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, url := newTestServer(t, time.Minute)
+			apWS, _ := dial(t, url)
+			opWS, _ := dial(t, url)
+			request(t, apWS, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+			request(t, opWS, messageTypeCreateOfferingPeer, peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap"})
+
+			srvURL := "http" + strings.TrimSuffix(strings.TrimPrefix(url, "ws"), "/hub")
+			for _, password := range []string{"wrong", "secret"} {
+				req, _ := http.NewRequest(http.MethodDelete, srvURL+tt.path, nil)
+				req.Header.Set("Authorization", "Bearer "+password)
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+
+				want := http.StatusNoContent
+				if password == "wrong" {
+					want = http.StatusUnauthorized
+				}
+				if resp.StatusCode != want {
+					t.Fatalf("delete with password %q responded with %d, want %d", password, resp.StatusCode, want)
+				}
+			}
+
+			if _, ok := h.boxes.Get(tt.role, tt.peer); ok {
+				t.Error("mailbox of the deleted peer is open")
+			}
+
+			ws := apWS
+			if tt.role == mailbox.Offering {
+				ws = opWS
+			}
+			if !closed(ws) {
+				t.Error("connection of the deleted peer is open")
+			}
+		})
+	}
+}
+
+// closed reads the websocket until it fails, it tells if it failed before the read deadline
+func closed(ws *websocket.Conn) bool {
+	ws.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for {
+		if _, _, err := ws.ReadMessage(); err != nil {
+			var netErr net.Error
+			return !errors.As(err, &netErr) || !netErr.Timeout()
+		}
+	}
+}
+
+func TestRegisteringAgainKeepsOnePump(t *testing.T) {
+	h, url := newTestServer(t, time.Minute)
+	ws, _ := dial(t, url)
+
+	for i := 0; i < 3; i++ {
+		if msg := request(t, ws, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); msg.Type != messageTypeAck {
+			t.Fatalf("registration replied %s %s", msg.Type, msg.Data)
+		}
+	}
+
+	h.pumps.mu.Lock()
+	pumps := len(h.pumps.running)
+	h.pumps.mu.Unlock()
+	if pumps != 1 {
+		t.Errorf("%d pumps run for one peer on one connection, want 1", pumps)
+	}
+
+	// the one pump still delivers
+	if err := h.boxes.Deliver(context.Background(), mailbox.Answering, "ap", "ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readMessage(t, ws); msg.Type != "ping" {
+		t.Errorf("peer got %s, want ping", msg.Type)
+	}
+}
+
+func TestInvalidFramesAreRouted(t *testing.T) {
+	h, url := newTestServer(t, time.Minute)
+	ws, _ := dial(t, url)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"conv":"c","type":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	msg := readMessage(t, ws)
+	data := peerhub.ErrorData{}
+	json.Unmarshal(msg.Data, &data)
+	if msg.Type != messageTypeError || msg.Conv != "c" || data.Code != peerhub.CodeInvalidRequest {
+		t.Errorf("invalid frame replied %s to %q %+v, want invalid request to c", msg.Type, msg.Conv, data)
+	}
+
+	if s := h.metrics.snapshot()[messageTypeInvalid]; s.Count != 1 || s.Errors != 1 {
+		t.Errorf("counted %+v invalid frames, want 1 with an error", s)
+	}
+}
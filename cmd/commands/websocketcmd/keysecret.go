@@ -0,0 +1,52 @@
+package websocketcmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keySecretFile is the file in the data directory holding the generated key secret
+const keySecretFile = "key-secret"
+
+// loadKeySecret reads the key secret kept in the directory, it's generated on first use so target access keys
+// sealed before a restart can still be opened
+func loadKeySecret(dir string) (string, error) {
+	path := filepath.Join(dir, keySecretFile)
+	b, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	encoded := base64.RawStdEncoding.EncodeToString(secret)
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	// a partly written secret would seal keys nobody can open later
+	if _, err := f.WriteString(encoded); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return encoded, nil
+}
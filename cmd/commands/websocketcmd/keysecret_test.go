@@ -0,0 +1,30 @@
+package websocketcmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadKeySecret(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+
+	secret, err := loadKeySecret(dir)
+	if err != nil || secret == "" {
+		t.Fatalf("generated %q, err %v", secret, err)
+	}
+
+	// a restarted hub reads the same secret
+	again, err := loadKeySecret(dir)
+	if err != nil || again != secret {
+		t.Errorf("loaded %q after a restart, want %q, err %v", again, secret, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, keySecretFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("secret is stored with %v, want it readable by the owner only", perm)
+	}
+}
@@ -1,9 +1,16 @@
 package websocketcmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"strconv"
 	"sync"
 
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/cmd/commands/federation"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -14,23 +21,67 @@ const (
 	// Inbound
 	messageTypeCreateOfferingPeer  messageType = "create_offering_peer"
 	messageTypeCreateAnsweringPeer messageType = "create_answering_peer"
+	messageTypeDeleteOfferingPeer  messageType = "delete_offering_peer"
+	messageTypeDeleteAnsweringPeer messageType = "delete_answering_peer"
+	messageTypeRotateAnsweringKeys messageType = "rotate_answering_peer_keys"
+	messageTypeRotateOfferingKey   messageType = "rotate_offering_peer_key"
 
-	messageTypeOfferAnswer        messageType = "offer_answer"
-	messageTypeDealAnswerRejected messageType = "deal_answer_rejected"
-	messageTypeDealAnswerError    messageType = "deal_answer_error"
+	messageTypeOfferAnswer messageType = "offer_answer"
+	messageTypeGetAnswer   messageType = "get_answer"
+
+	messageTypeAdminGetOfferingPeers    messageType = "admin_get_offering_peers"
+	messageTypeAdminDeleteAnsweringPeer messageType = "admin_delete_answering_peer"
+	messageTypeAdminDeleteOfferingPeer  messageType = "admin_delete_offering_peer"
+	messageTypeAdminKick                messageType = "admin_kick"
+	messageTypeAdminStats               messageType = "admin_stats"
+	messageTypeDealAnswerRejected       messageType = "deal_answer_rejected"
+	messageTypeDealAnswerError          messageType = "deal_answer_error"
+
+	// Inbound and outbound
+	messageTypeICECandidate    messageType = "ice_candidate"
+	messageTypeEndOfCandidates messageType = "end_of_candidates"
 
 	// Outbound
-	messageOffer           messageType = "offer"
-	messageTypeOfferFailed messageType = "offer_failed"
+	messageOffer             messageType = "offer"
+	messageTypeOfferCreated  messageType = "offer_created"
+	messageTypeOfferFailed   messageType = "offer_failed"
+	messageTypeOfferExpired  messageType = "offer_expired"
+	messageTypeAnswer        messageType = "answer"
+	messageTypeOfferingPeers messageType = "offering_peers"
+	messageTypeStats         messageType = "stats"
 
-	messageTypeInfo  messageType = "info"
+	messageTypeAnsweringPeerDisconnected messageType = "answering_peer_disconnected"
+	messageTypeDeliveryReceipt           messageType = "delivery_receipt"
+	messageTypeSession                   messageType = "session"
+
+	// Ack and Error end the conversation of an inbound message, every inbound message gets exactly one of them
+	messageTypeAck   messageType = "ack"
 	messageTypeError messageType = "error"
 )
 
+// message is the envelope of every message, replies carry the conv of the inbound message,
+// pushes have no conv, every outbound message has its own ID
 type message struct {
 	Type messageType `json:"type"`
-	Conv string      `json:"conv"`
+	ID   string      `json:"id,omitempty"`
+	Conv string      `json:"conv,omitempty"`
 	Data any         `json:"data"`
+	// Seq is the sequence number of outbound messages within the session
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// decodeMessage decodes an inbound frame, if it fails the returned message holds the conv of the frame
+// if it could be recovered so the error reply can still be correlated
+func decodeMessage(data []byte) (message, error) {
+	msg := message{}
+	if err := commands.UnmarshalJSON(data, &msg); err != nil {
+		conv := struct {
+			Conv string `json:"conv"`
+		}{}
+		json.Unmarshal(data, &conv)
+		return message{Conv: conv.Conv}, err
+	}
+	return msg, nil
 }
 
 func (m message) UnmarshalData(v any) error {
@@ -38,76 +89,234 @@ func (m message) UnmarshalData(v any) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(bytes, v)
+	return commands.UnmarshalJSON(bytes, v)
+}
+
+// connection serializes writes of all writers sharing the websocket connection, it outlives the websocket
+// so a client that resumes the session with its token keeps its peers and gets the messages it missed
+type connection struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+	// ctx is cancelled once the current websocket detaches
+	ctx    context.Context
+	cancel context.CancelFunc
+	token  string
+	seq    uint64
+	// sent holds the last sequenced messages for replay
+	sent   []message
+	closed bool
+	// limiter limits inbound messages of the session
+	limiter limiter
+}
+
+func newConnection(ws *websocket.Conn) *connection {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connection{
+		ws:     ws,
+		ctx:    ctx,
+		cancel: cancel,
+		token:  uuid.NewString(),
+		sent:   []message{},
+	}
+}
+
+// context returns context of the current websocket
+func (c *connection) context() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+// WriteJSON sequences the message and writes it to the websocket, messages written
+// while the websocket is detached are only kept for replay
+func (c *connection) WriteJSON(msg message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errSessionClosed
+	}
+
+	c.seq++
+	msg.Seq = c.seq
+	c.sent = append(c.sent, msg)
+	if len(c.sent) > maxReplay {
+		c.sent = c.sent[len(c.sent)-maxReplay:]
+	}
+
+	if c.ws == nil {
+		return nil
+	}
+	return c.ws.WriteJSON(msg)
+}
+
+// detach drops the websocket if it's still the current one, the session stays resumable
+func (c *connection) detach(ws *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ws != ws {
+		return
+	}
+	c.cancel()
+	c.ws = nil
+}
+
+// resume replays messages sequenced after lastSeq to the websocket and attaches it, a websocket that is
+// still attached is closed, the session is left as it was if the replay fails
+func (c *connection) resume(ws *websocket.Conn, lastSeq uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errSessionClosed
+	}
+
+	missed := []message{}
+	for _, msg := range c.sent {
+		if msg.Seq > lastSeq {
+			missed = append(missed, msg)
+		}
+	}
+	if lastSeq < c.seq && (len(missed) == 0 || missed[0].Seq != lastSeq+1) {
+		return errReplayUnavailable
+	}
+
+	if err := ws.WriteJSON(newSessionMessage(c.token, c.seq)); err != nil {
+		return err
+	}
+	for _, msg := range missed {
+		if err := ws.WriteJSON(msg); err != nil {
+			return err
+		}
+	}
+
+	if c.ws != nil {
+		c.cancel()
+		c.ws.Close()
+	}
+	c.ws = ws
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	return nil
+}
+
+// expire ends the session unless a websocket is attached, it reports whether the session ended
+func (c *connection) expire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ws != nil && !c.closed {
+		return false
+	}
+	c.closed = true
+	c.cancel()
+	return true
+}
+
+// Close ends the session and closes its websocket
+func (c *connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cancel()
+	if c.ws == nil {
+		return nil
+	}
+	return c.ws.Close()
 }
 
+// writer writes messages of a conversation, a writer without conv writes pushes,
+// Ack and Error end the conversation so only the first of them is written
 type writer struct {
-	mu   sync.Mutex
-	conn *websocket.Conn
-	conv string
+	mu      sync.Mutex
+	conn    *connection
+	conv    string
+	replied bool
 }
 
-func newWriter(conn *websocket.Conn, conv string) *writer {
+func newWriter(conn *connection, conv string) *writer {
 	return &writer{
 		conn: conn,
 		conv: conv,
 	}
 }
 
-func (w *writer) Conv(c string) *writer {
+func (w *writer) Write(mt messageType, data any) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	w.conv = c
-	return w
+	return w.write(mt, data)
 }
 
-func (w *writer) Write(mt messageType, data any) error {
+// Ack acknowledges the inbound message
+func (w *writer) Ack(msg string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.replied {
+		return nil
+	}
+	w.replied = true
+	return w.write(messageTypeAck, genericMessage{
+		Message: msg,
+	})
+}
+
+// Error replies to the inbound message with the error and its code
+func (w *writer) Error(err error) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	c := w.conv
-	if c == "" {
-		c = uuid.NewString()
+	if w.replied {
+		return nil
 	}
+	w.replied = true
+	return w.write(messageTypeError, errorData(err))
+}
+
+func (w *writer) write(mt messageType, data any) error {
 	msg := message{
 		Type: mt,
-		Conv: c,
+		ID:   uuid.NewString(),
+		Conv: w.conv,
 		Data: data,
 	}
 	return w.conn.WriteJSON(msg)
 }
 
-func (w *writer) Info(msg string) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	c := w.conv
-	if c == "" {
-		c = uuid.NewString()
-	}
-	m := message{
-		Type: messageTypeInfo,
-		Conv: c,
-		Data: genericMessage{
-			Message: msg,
-		},
-	}
-	return w.conn.WriteJSON(m)
+// errorCodes name errors of the transport which have no code in the hub
+var errorCodes = []struct {
+	err  error
+	code peerhub.ErrorCode
+}{
+	{errSessionNotFound, peerhub.CodeSessionNotFound},
+	{errSessionClosed, peerhub.CodeSessionNotFound},
+	{errReplayUnavailable, peerhub.CodeReplayUnavailable},
+	{strconv.ErrSyntax, peerhub.CodeInvalidRequest},
+	{strconv.ErrRange, peerhub.CodeInvalidRequest},
+	{mailbox.ErrNotFound, peerhub.CodePeerUnreachable},
+	{mailbox.ErrFull, peerhub.CodePeerUnreachable},
+	{mailbox.ErrClosed, peerhub.CodePeerUnreachable},
+	{federation.ErrHubUnavailable, peerhub.CodeHubUnavailable},
+	{errRateLimited, peerhub.CodeRateLimited},
+	{errPeerNotRegistered, peerhub.CodeForbidden},
 }
 
-func (w *writer) Error(err error) error {
-	w.mu.Lock()
-	defer w.mu.Unlock()
-	c := w.conv
-	if c == "" {
-		c = uuid.NewString()
+// errorData returns the serializable form of the error, codes of the hub take precedence over those of the transport
+func errorData(err error) peerhub.ErrorData {
+	data := commands.ErrorData(err)
+	if data.Code != peerhub.CodeInternal {
+		return data
 	}
-	msg := message{
-		Type: messageTypeError,
-		Conv: c,
-		Data: genericMessage{
-			Message: err.Error(),
-		},
+
+	var unknownErr unknownMessageTypeError
+	if errors.As(err, &unknownErr) {
+		data.Code = peerhub.CodeUnknownMessageType
+		data.Details = map[string]any{"type": unknownErr.mt}
+		return data
 	}
-	return w.conn.WriteJSON(msg)
+
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			data.Code = ec.code
+			break
+		}
+	}
+	return data
 }
 
 type genericMessage struct {
@@ -0,0 +1,35 @@
+package websocketcmd
+
+import (
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		frame    string
+		wantConv string
+		wantErr  bool
+	}{
+		{name: "valid", frame: `{"type":"get_answer","conv":"c1","data":{}}`, wantConv: "c1"},
+		{name: "mistyped field", frame: `{"type":1,"conv":"c2"}`, wantConv: "c2", wantErr: true},
+		{name: "not json", frame: `{"type":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := decodeMessage([]byte(tt.frame))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decode returned %v", err)
+			}
+			if msg.Conv != tt.wantConv {
+				t.Errorf("conv %q, want %q", msg.Conv, tt.wantConv)
+			}
+			if err != nil && errorData(err).Code != peerhub.CodeInvalidRequest {
+				t.Errorf("error code %s, want %s", errorData(err).Code, peerhub.CodeInvalidRequest)
+			}
+		})
+	}
+}
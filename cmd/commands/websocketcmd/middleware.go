@@ -0,0 +1,154 @@
+package websocketcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+)
+
+var errRateLimited = errors.New("too many messages, slow down")
+
+// logMessages logs errors of handlers along the type of the message
+func logMessages(rt route, next handlerFunc) handlerFunc {
+	return func(ctx context.Context, w *writer, msg message) error {
+		err := next(ctx, w, msg)
+		if err != nil {
+			fmt.Println(fmt.Errorf("error handling %s message: %w", rt.mt, err))
+		}
+		return err
+	}
+}
+
+// authorizeAdmin checks the master password of administrative messages before they are handled
+func (h *handler) authorizeAdmin(rt route, next handlerFunc) handlerFunc {
+	if !rt.admin {
+		return next
+	}
+
+	return func(ctx context.Context, w *writer, msg message) error {
+		req := peerhub.AdminRequest{}
+		if err := msg.UnmarshalData(&req); err != nil {
+			return err
+		}
+		if err := h.hub.AuthorizeAdmin(req.MasterPassword); err != nil {
+			return err
+		}
+		return next(ctx, w, msg)
+	}
+}
+
+// limitMessages allows every connection rate messages per second with bursts of up to burst messages,
+// a rate of 0 doesn't limit messages
+func limitMessages(rate float64, burst int) middleware {
+	return func(rt route, next handlerFunc) handlerFunc {
+		if rate <= 0 {
+			return next
+		}
+
+		return func(ctx context.Context, w *writer, msg message) error {
+			if !w.conn.limiter.allow(rate, burst, time.Now()) {
+				return errRateLimited
+			}
+			return next(ctx, w, msg)
+		}
+	}
+}
+
+// limiter is a token bucket of a connection, shared by all its websockets so reconnecting doesn't refill it
+type limiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func (l *limiter) allow(rate float64, burst int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last.IsZero() {
+		l.tokens = float64(burst)
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	l.tokens = min(l.tokens, float64(burst))
+	l.last = now
+
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+type messageStats struct {
+	Count  int64 `json:"count"`
+	Errors int64 `json:"errors"`
+	// TotalMS is the time spent handling messages of the type
+	TotalMS int64 `json:"totalms"`
+}
+
+// messageMetrics counts messages handled by every route
+type messageMetrics struct {
+	mu    sync.Mutex
+	stats map[messageType]*messageStats
+}
+
+func newMessageMetrics() *messageMetrics {
+	return &messageMetrics{
+		stats: map[messageType]*messageStats{},
+	}
+}
+
+func (m *messageMetrics) middleware(rt route, next handlerFunc) handlerFunc {
+	m.mu.Lock()
+	m.stats[rt.mt] = &messageStats{}
+	m.mu.Unlock()
+
+	return func(ctx context.Context, w *writer, msg message) error {
+		start := time.Now()
+		err := next(ctx, w, msg)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		s := m.stats[rt.mt]
+		s.Count++
+		if err != nil {
+			s.Errors++
+		}
+		s.TotalMS += time.Since(start).Milliseconds()
+
+		return err
+	}
+}
+
+func (m *messageMetrics) snapshot() map[messageType]messageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap := make(map[messageType]messageStats, len(m.stats))
+	for mt, s := range m.stats {
+		snap[mt] = *s
+	}
+	return snap
+}
+
+// adminMessages responds with metrics of the messages handled so far
+func (h *handler) adminMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		commands.WriteError(w, commands.ErrMethodNotAllowed)
+		return
+	}
+
+	if err := h.hub.AuthorizeAdmin(commands.MasterPassword(r)); err != nil {
+		commands.WriteError(w, err)
+		return
+	}
+
+	commands.WriteJSON(w, http.StatusOK, h.metrics.snapshot())
+}
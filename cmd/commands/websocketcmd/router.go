@@ -0,0 +1,115 @@
+package websocketcmd
+
+import (
+	"context"
+	"fmt"
+)
+
+// handlerFunc handles a message of a route, errors it returns are replied to the message
+type handlerFunc func(ctx context.Context, w *writer, msg message) error
+
+// middleware wraps handlers of every route, it's given the route to tell them apart
+type middleware func(rt route, next handlerFunc) handlerFunc
+
+const (
+	// messageTypeUnknown is the route of messages of types without a handler
+	messageTypeUnknown messageType = "unknown"
+	// messageTypeInvalid is the route of frames that fail to decode
+	messageTypeInvalid messageType = "invalid"
+)
+
+type route struct {
+	mt messageType
+	// admin routes are authorized with the master password carried by the message
+	admin bool
+}
+
+// router passes messages to handlers registered for their type, handlers are wrapped with middleware
+// when they are registered so middleware has to be passed to newRouter
+type router struct {
+	middleware []middleware
+	routes     map[messageType]handlerFunc
+	// unknown and invalid reject messages of unknown types and frames that fail to decode, they are wrapped
+	// with the middleware too so such messages are limited, logged and counted like the rest
+	unknown handlerFunc
+	invalid handlerFunc
+}
+
+// newRouter returns a router wrapping handlers with the middleware, the first one is the outermost
+func newRouter(mw ...middleware) *router {
+	r := &router{
+		middleware: mw,
+		routes:     map[messageType]handlerFunc{},
+	}
+	r.unknown = r.wrap(route{mt: messageTypeUnknown}, func(ctx context.Context, w *writer, msg message) error {
+		return unknownMessageTypeError{mt: msg.Type}
+	})
+	r.invalid = r.wrap(route{mt: messageTypeInvalid}, func(ctx context.Context, w *writer, msg message) error {
+		return msg.Data.(invalidFrame).err
+	})
+	return r
+}
+
+// handle registers a handler of the message type, the data of the message is decoded into its request
+func handle[T any](r *router, mt messageType, fn func(ctx context.Context, w *writer, req T) error) {
+	r.register(route{mt: mt}, typed(fn))
+}
+
+// handleAdmin registers a handler of the administrative message type
+func handleAdmin[T any](r *router, mt messageType, fn func(ctx context.Context, w *writer, req T) error) {
+	r.register(route{mt: mt, admin: true}, typed(fn))
+}
+
+func typed[T any](fn func(ctx context.Context, w *writer, req T) error) handlerFunc {
+	return func(ctx context.Context, w *writer, msg message) error {
+		var req T
+		if err := msg.UnmarshalData(&req); err != nil {
+			return err
+		}
+		return fn(ctx, w, req)
+	}
+}
+
+func (r *router) register(rt route, fn handlerFunc) {
+	if _, ok := r.routes[rt.mt]; ok {
+		panic(fmt.Sprintf("message type %s is already routed", rt.mt))
+	}
+
+	r.routes[rt.mt] = r.wrap(rt, fn)
+}
+
+// wrap wraps the handler of the route with the middleware
+func (r *router) wrap(rt route, fn handlerFunc) handlerFunc {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		fn = r.middleware[i](rt, fn)
+	}
+	return fn
+}
+
+// route passes the message to the handler of its type, messages of types without one are an error
+func (r *router) route(ctx context.Context, w *writer, msg message) error {
+	fn, ok := r.routes[msg.Type]
+	if !ok {
+		return r.unknown(ctx, w, msg)
+	}
+	return fn(ctx, w, msg)
+}
+
+// routeInvalid passes the error of a frame that failed to decode through the middleware
+func (r *router) routeInvalid(ctx context.Context, w *writer, err error) error {
+	return r.invalid(ctx, w, message{Type: messageTypeInvalid, Data: invalidFrame{err: err}})
+}
+
+// invalidFrame is the data of the message routed for a frame that failed to decode
+type invalidFrame struct {
+	err error
+}
+
+// unknownMessageTypeError is replied to messages of a type the hub doesn't handle
+type unknownMessageTypeError struct {
+	mt messageType
+}
+
+func (e unknownMessageTypeError) Error() string {
+	return fmt.Sprintf("unknown message type %q", e.mt)
+}
@@ -0,0 +1,189 @@
+package websocketcmd
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+type echoRequest struct {
+	Name string `json:"name"`
+}
+
+func newTestWriter() *writer {
+	return newWriter(&connection{}, "c")
+}
+
+func TestRouterDecodesRequests(t *testing.T) {
+	r := newRouter()
+	got := ""
+	handle(r, "echo", func(ctx context.Context, w *writer, req echoRequest) error {
+		got = req.Name
+		return nil
+	})
+
+	err := r.route(context.Background(), newTestWriter(), message{Type: "echo", Data: map[string]any{"name": "ap"}})
+	if err != nil || got != "ap" {
+		t.Errorf("handled %q, err %v", got, err)
+	}
+
+	err = r.route(context.Background(), newTestWriter(), message{Type: "echo", Data: map[string]any{"name": 1}})
+	if code := errorData(err).Code; code != peerhub.CodeInvalidRequest {
+		t.Errorf("undecodable request replied with %s, want %s", code, peerhub.CodeInvalidRequest)
+	}
+}
+
+func TestRouterRejectsUnknownMessageTypes(t *testing.T) {
+	r := newRouter()
+
+	err := r.route(context.Background(), newTestWriter(), message{Type: "nope"})
+	data := errorData(err)
+	if data.Code != peerhub.CodeUnknownMessageType || data.Details["type"] != messageType("nope") {
+		t.Errorf("unknown message type replied with %+v", data)
+	}
+}
+
+func TestRouterPanicsOnDuplicateRoutes(t *testing.T) {
+	r := newRouter()
+	fn := func(ctx context.Context, w *writer, req echoRequest) error { return nil }
+	handle(r, "echo", fn)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("message type was routed twice")
+		}
+	}()
+	handle(r, "echo", fn)
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	calls := []string{}
+	trace := func(name string) middleware {
+		return func(rt route, next handlerFunc) handlerFunc {
+			return func(ctx context.Context, w *writer, msg message) error {
+				calls = append(calls, name)
+				return next(ctx, w, msg)
+			}
+		}
+	}
+
+	r := newRouter(trace("outer"), trace("inner"))
+	handle(r, "echo", func(ctx context.Context, w *writer, req echoRequest) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	if err := r.route(context.Background(), newTestWriter(), message{Type: "echo"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("called %v, want %v", calls, want)
+	}
+}
+
+func TestLimitMessages(t *testing.T) {
+	r := newRouter(limitMessages(0.001, 2))
+	handle(r, "echo", func(ctx context.Context, w *writer, req echoRequest) error { return nil })
+
+	w := newTestWriter()
+	for i := 0; i < 2; i++ {
+		if err := r.route(context.Background(), w, message{Type: "echo"}); err != nil {
+			t.Fatalf("message %d within the burst was limited: %v", i, err)
+		}
+	}
+	if err := r.route(context.Background(), w, message{Type: "echo"}); errorData(err).Code != peerhub.CodeRateLimited {
+		t.Errorf("message over the burst returned %v, want rate limited", err)
+	}
+
+	// the bucket belongs to the connection
+	if err := r.route(context.Background(), newTestWriter(), message{Type: "echo"}); err != nil {
+		t.Errorf("message of another connection was limited: %v", err)
+	}
+}
+
+func TestAuthorizeAdmin(t *testing.T) {
+	h := &handler{hub: peerhub.NewHub(peerhub.HubConfig{
+		PeerService:    peer.NewInMemoryService(),
+		SignalService:  sig.NewInMemoryService(),
+		MasterPassword: "secret",
+	})}
+	r := newRouter(h.authorizeAdmin)
+	handled := 0
+	handleAdmin(r, "stats", func(ctx context.Context, w *writer, req peerhub.AdminRequest) error {
+		handled++
+		return nil
+	})
+	handle(r, "echo", func(ctx context.Context, w *writer, req echoRequest) error {
+		handled++
+		return nil
+	})
+
+	err := r.route(context.Background(), newTestWriter(), message{Type: "stats", Data: map[string]any{"masterpassword": "wrong"}})
+	if !errors.Is(err, peerhub.ErrInvalidMasterPassword) || handled != 0 {
+		t.Errorf("admin message with a wrong password returned %v, handled %d times", err, handled)
+	}
+
+	err = r.route(context.Background(), newTestWriter(), message{Type: "stats", Data: map[string]any{"masterpassword": "secret"}})
+	if err != nil || handled != 1 {
+		t.Errorf("admin message returned %v, handled %d times", err, handled)
+	}
+
+	if err := r.route(context.Background(), newTestWriter(), message{Type: "echo"}); err != nil || handled != 2 {
+		t.Errorf("message of a route that isn't administrative returned %v", err)
+	}
+}
+
+func TestMessageMetrics(t *testing.T) {
+	m := newMessageMetrics()
+	r := newRouter(m.middleware)
+	failure := errors.New("failed")
+	handle(r, "echo", func(ctx context.Context, w *writer, req echoRequest) error {
+		if req.Name == "" {
+			return failure
+		}
+		return nil
+	})
+
+	r.route(context.Background(), newTestWriter(), message{Type: "echo", Data: map[string]any{"name": "ap"}})
+	r.route(context.Background(), newTestWriter(), message{Type: "echo"})
+
+	if s := m.snapshot()["echo"]; s.Count != 2 || s.Errors != 1 {
+		t.Errorf("counted %+v, want 2 messages and 1 error", s)
+	}
+}
+
+func TestUnroutableMessagesPassTheMiddleware(t *testing.T) {
+	m := newMessageMetrics()
+	// metrics are outermost so they count the limited messages too
+	r := newRouter(m.middleware, limitMessages(0.001, 2))
+	w := newTestWriter()
+
+	if err := r.route(context.Background(), w, message{Type: "nope"}); errorData(err).Code != peerhub.CodeUnknownMessageType {
+		t.Errorf("unknown message type returned %v", err)
+	}
+	invalid := commands.UnmarshalJSON([]byte("{"), &message{})
+	if err := r.routeInvalid(context.Background(), w, invalid); errorData(err).Code != peerhub.CodeInvalidRequest {
+		t.Errorf("invalid frame returned %v", err)
+	}
+
+	// both used up the burst of the connection
+	if err := r.route(context.Background(), w, message{Type: "nope"}); errorData(err).Code != peerhub.CodeRateLimited {
+		t.Errorf("unknown message type over the burst returned %v, want rate limited", err)
+	}
+	if err := r.routeInvalid(context.Background(), w, invalid); errorData(err).Code != peerhub.CodeRateLimited {
+		t.Errorf("invalid frame over the burst returned %v, want rate limited", err)
+	}
+
+	snap := m.snapshot()
+	for _, mt := range []messageType{messageTypeUnknown, messageTypeInvalid} {
+		if s := snap[mt]; s.Count != 2 || s.Errors != 2 {
+			t.Errorf("counted %+v %s messages, want 2 messages and 2 errors", s, mt)
+		}
+	}
+}
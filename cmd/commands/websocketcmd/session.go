@@ -0,0 +1,75 @@
+package websocketcmd
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// maxReplay is the number of last outbound messages a session keeps for replay
+const maxReplay = 256
+
+var (
+	errSessionClosed     = errors.New("session closed")
+	errSessionNotFound   = errors.New("session not found")
+	errReplayUnavailable = errors.New("missed messages are no longer available")
+)
+
+// sessionMessage is sent first over every websocket, clients reconnect with the token
+// and the last sequence number they've seen to resume the session
+type sessionMessage struct {
+	Token string `json:"token"`
+	Seq   uint64 `json:"seq"`
+}
+
+func newSessionMessage(token string, seq uint64) message {
+	return message{
+		Type: messageTypeSession,
+		ID:   uuid.NewString(),
+		Data: sessionMessage{
+			Token: token,
+			Seq:   seq,
+		},
+	}
+}
+
+type sessionCache struct {
+	mu    sync.Mutex
+	conns map[string]*connection
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{
+		mu:    sync.Mutex{},
+		conns: map[string]*connection{},
+	}
+}
+
+func (c *sessionCache) get(token string) (*connection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, ok := c.conns[token]
+	return conn, ok
+}
+
+func (c *sessionCache) set(conn *connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[conn.token] = conn
+}
+
+func (c *sessionCache) remove(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, token)
+}
+
+// parseSeq parses the last seen sequence number, missing one means nothing was seen
+func parseSeq(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,164 @@
+package websocketcmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
+	"github.com/gorilla/websocket"
+)
+
+// detached closes the websocket and waits until the session lets it go
+func detached(t *testing.T, h *handler, ws *websocket.Conn, token string) *connection {
+	t.Helper()
+	conn, ok := h.sessions.get(token)
+	if !ok {
+		t.Fatal("session not found")
+	}
+	ws.Close()
+
+	select {
+	case <-conn.context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("websocket didn't detach")
+	}
+	return conn
+}
+
+func TestResumeReplaysMissedMessages(t *testing.T) {
+	h, url := newTestServer(t, time.Minute)
+	ws, session := dial(t, url)
+
+	ack := request(t, ws, messageTypeCreateAnsweringPeer, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	conn := detached(t, h, ws, session.Token)
+
+	// messages written while the websocket is detached are kept for replay
+	for i := 0; i < 2; i++ {
+		if err := conn.WriteJSON(message{Type: "missed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?resume=%s&seq=%d", url, session.Token, ack.Seq), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	msg := readMessage(t, ws)
+	resumed := sessionMessage{}
+	if msg.Type != messageTypeSession || json.Unmarshal(msg.Data, &resumed) != nil || resumed.Token != session.Token {
+		t.Fatalf("first message is %s %s, want the resumed session", msg.Type, msg.Data)
+	}
+	for i := uint64(1); i <= 2; i++ {
+		if msg := readMessage(t, ws); msg.Type != "missed" || msg.Seq != ack.Seq+i {
+			t.Errorf("replayed %s with seq %d, want missed with seq %d", msg.Type, msg.Seq, ack.Seq+i)
+		}
+	}
+
+	// the peer stays registered and its mailbox is pumped into the new websocket
+	if err := h.boxes.Deliver(context.Background(), mailbox.Answering, "ap", "ping", nil); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readMessage(t, ws); msg.Type != "ping" || msg.Seq != ack.Seq+3 {
+		t.Errorf("peer got %s with seq %d after resuming, want ping with seq %d", msg.Type, msg.Seq, ack.Seq+3)
+	}
+}
+
+func TestResumeFailures(t *testing.T) {
+	h, url := newTestServer(t, time.Minute)
+	ws, session := dial(t, url)
+	conn := detached(t, h, ws, session.Token)
+
+	// more messages than are kept for replay
+	for i := 0; i <= maxReplay; i++ {
+		if err := conn.WriteJSON(message{Type: "missed"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  peerhub.ErrorCode
+	}{
+		{name: "replay unavailable", query: "?resume=" + session.Token + "&seq=0", want: peerhub.CodeReplayUnavailable},
+		{name: "unknown session", query: "?resume=unknown", want: peerhub.CodeSessionNotFound},
+		{name: "invalid sequence number", query: "?resume=" + session.Token + "&seq=x", want: peerhub.CodeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws, _, err := websocket.DefaultDialer.Dial(url+tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+
+			msg := readMessage(t, ws)
+			data := peerhub.ErrorData{}
+			json.Unmarshal(msg.Data, &data)
+			if msg.Type != messageTypeError || data.Code != tt.want {
+				t.Errorf("resume replied %s %+v, want %s", msg.Type, data, tt.want)
+			}
+		})
+	}
+
+	// the session can still be resumed from what was kept
+	ws, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("%s?resume=%s&seq=%d", url, session.Token, conn.seq-1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if msg := readMessage(t, ws); msg.Type != messageTypeSession {
+		t.Errorf("resume replied %s, want the session", msg.Type)
+	}
+}
+
+// serverWebsocket returns the hub's end of a websocket, the client's end is closed with the test
+func serverWebsocket(t *testing.T) *websocket.Conn {
+	t.Helper()
+	wsC := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		wsC <- ws
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return <-wsC
+}
+
+func TestFailedResumeLeavesSessionDetached(t *testing.T) {
+	conn := newConnection(nil)
+	if err := conn.WriteJSON(message{Type: "missed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session message can't be written to a closed websocket
+	ws := serverWebsocket(t)
+	ws.Close()
+	if err := conn.resume(ws, 0); err == nil {
+		t.Fatal("resume over a closed websocket succeeded")
+	}
+
+	if !conn.expire() {
+		t.Error("session of the failed resume points at the dead websocket and never expires")
+	}
+}
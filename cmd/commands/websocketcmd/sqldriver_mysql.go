@@ -0,0 +1,6 @@
+//go:build mysql
+
+package websocketcmd
+
+// the mysql driver of the sql store is linked in with the mysql build tag
+import _ "github.com/go-sql-driver/mysql"
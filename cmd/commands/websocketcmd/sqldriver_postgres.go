@@ -0,0 +1,6 @@
+//go:build postgres
+
+package websocketcmd
+
+// the postgres driver of the sql store is linked in with the postgres build tag
+import _ "github.com/lib/pq"
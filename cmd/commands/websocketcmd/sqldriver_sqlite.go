@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package websocketcmd
+
+// the sqlite driver of the sql store is linked in with the sqlite build tag
+import _ "github.com/mattn/go-sqlite3"
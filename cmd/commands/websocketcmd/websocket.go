@@ -2,23 +2,59 @@ package websocketcmd
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
+	"time"
 
 	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/cmd/commands/cluster"
+	"github.com/H3Cki/peerhub/cmd/commands/federation"
+	"github.com/H3Cki/peerhub/cmd/commands/mailbox"
 	"github.com/H3Cki/peerhub/internal/peer"
+	"github.com/H3Cki/peerhub/internal/redisstore"
+	"github.com/H3Cki/peerhub/internal/resp"
 	sig "github.com/H3Cki/peerhub/internal/signal"
-
-	//"github.com/H3Cki/peerhub/internal/inmemory"
+	"github.com/H3Cki/peerhub/internal/sqlstore"
+	"github.com/google/uuid"
 	"github.com/urfave/cli/v2"
 )
 
+const (
+	disconnectActionDelete  = "delete"
+	disconnectActionOffline = "offline"
+)
+
+const (
+	storeMemory = "memory"
+	storeFile   = "file"
+	storeSQL    = "sql"
+	storeRedis  = "redis"
+)
+
 var (
-	defaultPort           = 54321
-	defaultMasterPassword = ""
+	defaultPort             = 54321
+	defaultMasterPassword   = ""
+	defaultDisconnectGrace  = 10 * time.Second
+	defaultDisconnectAction = disconnectActionDelete
+	defaultOfferTTL         = peerhub.DefaultOfferTTL
+	defaultAnswerTTL        = peerhub.DefaultAnswerTTL
+	defaultGCInterval       = 30 * time.Second
+	defaultRejectCooldown   = peerhub.DefaultRejectCooldown
+	defaultMailTTL          = peerhub.DefaultMailTTL
+	defaultStore            = storeMemory
+	defaultDataDir          = "data"
+	defaultRedisAddr        = "localhost:6379"
+	defaultMessageTimeout   = 10 * time.Second
+	defaultMessageRate      = 0.0
+	defaultMessageBurst     = 20
+
+	defaultClusterHealthInterval = 2 * time.Second
 )
 
 var Command = &cli.Command{
@@ -28,18 +64,163 @@ var Command = &cli.Command{
 	Flags: []cli.Flag{
 		&cli.IntFlag{Name: "port", Value: defaultPort, EnvVars: []string{"PH_PORT"}, Usage: "port to run the server on"},
 		&cli.StringFlag{Name: "master-password", Value: defaultMasterPassword, EnvVars: []string{"PH_MASTER_PASSWORD"}, Usage: "master password for the server"},
+		&cli.StringFlag{Name: "key-secret", EnvVars: []string{"PH_KEY_SECRET"}, Usage: "secret encrypting stored target access keys of offering peers, required by hubs sharing a store, the file store generates one in its data directory"},
+		&cli.DurationFlag{Name: "disconnect-grace", Value: defaultDisconnectGrace, EnvVars: []string{"PH_DISCONNECT_GRACE"}, Usage: "time peers of a dropped connection have to reconnect before they are cleaned up"},
+		&cli.DurationFlag{Name: "offer-ttl", Value: defaultOfferTTL, EnvVars: []string{"PH_OFFER_TTL"}, Usage: "time after which unanswered offers expire"},
+		&cli.DurationFlag{Name: "answer-ttl", Value: defaultAnswerTTL, EnvVars: []string{"PH_ANSWER_TTL"}, Usage: "time after which answers expire"},
+		&cli.DurationFlag{Name: "reject-cooldown", Value: defaultRejectCooldown, EnvVars: []string{"PH_REJECT_COOLDOWN"}, Usage: "time an offering peer can't re-offer after its offer was rejected with block"},
+		&cli.DurationFlag{Name: "mail-ttl", Value: defaultMailTTL, EnvVars: []string{"PH_MAIL_TTL"}, Usage: "time messages for disconnected peers are kept until they reconnect"},
+		&cli.DurationFlag{Name: "message-timeout", Value: defaultMessageTimeout, EnvVars: []string{"PH_MESSAGE_TIMEOUT"}, Usage: "time handling a single websocket message can take before its store calls are abandoned"},
+		&cli.Float64Flag{Name: "message-rate", Value: defaultMessageRate, EnvVars: []string{"PH_MESSAGE_RATE"}, Usage: "websocket messages per second a session can send, 0 doesn't limit them"},
+		&cli.IntFlag{Name: "message-burst", Value: defaultMessageBurst, EnvVars: []string{"PH_MESSAGE_BURST"}, Usage: "websocket messages a session can send at once when they are rate limited"},
+		&cli.DurationFlag{Name: "gc-interval", Value: defaultGCInterval, EnvVars: []string{"PH_GC_INTERVAL"}, Usage: "interval of deleting expired offers and answers"},
+		&cli.StringFlag{Name: "store", Value: defaultStore, EnvVars: []string{"PH_STORE"}, Usage: "where peers and offers are stored, memory, file, sql or redis"},
+		&cli.StringFlag{Name: "data-dir", Value: defaultDataDir, EnvVars: []string{"PH_DATA_DIR"}, Usage: "directory of the file store"},
+		&cli.StringFlag{Name: "sql-driver", EnvVars: []string{"PH_SQL_DRIVER"}, Usage: "database/sql driver of the sql store, postgres, mysql or sqlite3, the hub has to be built with the postgres, mysql or sqlite tag"},
+		&cli.StringFlag{Name: "sql-dsn", EnvVars: []string{"PH_SQL_DSN"}, Usage: "data source name of the sql store"},
+		&cli.StringFlag{Name: "redis-addr", Value: defaultRedisAddr, EnvVars: []string{"PH_REDIS_ADDR"}, Usage: "address of the redis store, hubs sharing it relay messages to each other"},
+		&cli.StringFlag{Name: "redis-password", EnvVars: []string{"PH_REDIS_PASSWORD"}, Usage: "password of the redis store"},
+		&cli.IntFlag{Name: "redis-db", EnvVars: []string{"PH_REDIS_DB"}, Usage: "database number of the redis store"},
+		&cli.StringFlag{Name: "redis-prefix", Value: redisstore.DefaultPrefix, EnvVars: []string{"PH_REDIS_PREFIX"}, Usage: "prefix of redis keys"},
+		&cli.StringFlag{Name: "cluster-self", EnvVars: []string{"PH_CLUSTER_SELF"}, Usage: "host:port (or https://host:port behind a TLS proxy) other cluster nodes reach this hub at, enables cluster mode, nodes have to share a sql store"},
+		&cli.StringSliceFlag{Name: "cluster-nodes", EnvVars: []string{"PH_CLUSTER_NODES"}, Usage: "host:port (or https://host:port) of every cluster node"},
+		&cli.StringFlag{Name: "cluster-secret", EnvVars: []string{"PH_CLUSTER_SECRET"}, Usage: "secret authenticating requests between cluster nodes, it's sent in plain text to nodes reached over http, so run such clusters on a trusted network"},
+		&cli.DurationFlag{Name: "cluster-health-interval", Value: defaultClusterHealthInterval, EnvVars: []string{"PH_CLUSTER_HEALTH_INTERVAL"}, Usage: "interval of checking which cluster nodes are up"},
+		&cli.StringFlag{Name: "federation-config", EnvVars: []string{"PH_FEDERATION_CONFIG"}, Usage: "JSON file naming this hub and the partner hubs its offering peers reach as name@hub"},
+		&cli.StringFlag{Name: "disconnect-action", Value: defaultDisconnectAction, EnvVars: []string{"PH_DISCONNECT_ACTION"}, Usage: "what happens to peers of a dropped connection, delete or offline"},
 	},
 }
 
 func runWebsocket(ctx *cli.Context) error {
-	hub := peerhub.NewHub(peerhub.HubConfig{
-		PeerService:   peer.NewInMemoryService(),
-		SignalService: sig.NewInMemoryService(),
-	})
+	disconnectAction := ctx.String("disconnect-action")
+	if disconnectAction != disconnectActionDelete && disconnectAction != disconnectActionOffline {
+		return fmt.Errorf("invalid disconnect action %q", disconnectAction)
+	}
+
+	gcInterval := ctx.Duration("gc-interval")
+	if gcInterval <= 0 {
+		return fmt.Errorf("invalid gc interval %s", gcInterval)
+	}
+
+	messageTimeout := ctx.Duration("message-timeout")
+	if messageTimeout <= 0 {
+		return fmt.Errorf("invalid message timeout %s", messageTimeout)
+	}
+
+	messageRate, messageBurst := ctx.Float64("message-rate"), ctx.Int("message-burst")
+	if messageRate < 0 || (messageRate > 0 && messageBurst < 1) {
+		return fmt.Errorf("invalid message rate %v with burst %d", messageRate, messageBurst)
+	}
+
+	st, err := newStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer st.close()
+
+	// target access keys sealed by one hub are read by the others, and by the same hub after it restarts
+	keySecret := ctx.String("key-secret")
+	if keySecret == "" && st.shared {
+		return errors.New("hubs sharing a store require a key secret")
+	}
+	if keySecret == "" && st.dataDir != "" {
+		if keySecret, err = loadKeySecret(st.dataDir); err != nil {
+			return fmt.Errorf("error loading key secret: %w", err)
+		}
+	}
+
+	var fed *federation.Federation
+	hubCfg := peerhub.HubConfig{
+		PeerServiceV2:   st.peerSvc,
+		SignalServiceV2: st.signalSvc,
+		OfferTTL:        ctx.Duration("offer-ttl"),
+		AnswerTTL:       ctx.Duration("answer-ttl"),
+		RejectCooldown:  ctx.Duration("reject-cooldown"),
+		MailTTL:         ctx.Duration("mail-ttl"),
+		MasterPassword:  ctx.String("master-password"),
+		KeySecret:       keySecret,
+	}
+	if path := ctx.String("federation-config"); path != "" {
+		fedCfg, err := federation.LoadConfig(path)
+		if err != nil {
+			return fmt.Errorf("error loading federation config: %w", err)
+		}
+		if fed, err = federation.New(fedCfg); err != nil {
+			return err
+		}
+		hubCfg.Federation = fed
+	}
+	hub := peerhub.NewHub(hubCfg)
+
+	if migrated, err := hub.MigrateKeys(ctx.Context); err != nil {
+		return fmt.Errorf("error migrating peer keys: %w", err)
+	} else if migrated > 0 {
+		fmt.Printf("hashed keys of %d peers\n", migrated)
+	}
+
+	// peers in a shared store may be connected to other hubs
+	if !st.shared {
+		if _, err := hub.MarkPeersOffline(ctx.Context); err != nil {
+			return fmt.Errorf("error marking peers offline: %w", err)
+		}
+	}
+
+	var node *cluster.Node
+	relay := st.relay
+	if self := ctx.String("cluster-self"); self != "" {
+		if relay != nil {
+			return errors.New("cluster mode can't be combined with the redis relay")
+		}
+		// nodes only route messages, peers and offers registered at one node have to be visible to the others
+		if !st.shared {
+			return errors.New("cluster mode requires a store shared by all nodes, use the sql store")
+		}
+		if ctx.String("cluster-secret") == "" {
+			return errors.New("cluster mode requires a cluster secret")
+		}
+		node = cluster.NewNode(cluster.Config{
+			Self:           self,
+			Nodes:          ctx.StringSlice("cluster-nodes"),
+			Secret:         ctx.String("cluster-secret"),
+			HealthInterval: ctx.Duration("cluster-health-interval"),
+		})
+		relay = node
+	}
+
+	boxes := mailbox.NewRegistry(hub)
+	if relay != nil {
+		if err := boxes.UseRelay(relay); err != nil {
+			return fmt.Errorf("error starting relay: %w", err)
+		}
+	}
+	if fed != nil {
+		boxes.UseRemote(fed)
+	}
 
-	hndl := &handler{hub: hub, wc: newConnCache()}
+	// srvCtx is cancelled on shutdown, stopping background work and messages being handled
+	srvCtx, cancelSrv := context.WithCancel(context.Background())
+	defer cancelSrv()
+
+	hndl := &handler{
+		ctx:              srvCtx,
+		hub:              hub,
+		wc:               newConnCache(),
+		sessions:         newSessionCache(),
+		boxes:            boxes,
+		pumps:            newPumpSet(),
+		fed:              fed,
+		disconnectGrace:  ctx.Duration("disconnect-grace"),
+		disconnectDelete: disconnectAction == disconnectActionDelete,
+		messageTimeout:   messageTimeout,
+		metrics:          newMessageMetrics(),
+	}
+	hndl.router = newRouter(limitMessages(messageRate, messageBurst), logMessages, hndl.metrics.middleware, hndl.authorizeAdmin)
+	hndl.registerRoutes(hndl.router)
 	mux := http.NewServeMux()
 	hndl.registerHandlers(mux)
+	if node != nil {
+		node.RegisterHandlers(mux)
+	}
 
 	port := ctx.Int("port")
 
@@ -52,6 +233,11 @@ func runWebsocket(ctx *cli.Context) error {
 		Handler: mux,
 	}
 
+	go hndl.sweep(srvCtx, gcInterval)
+	if node != nil {
+		go node.Run(srvCtx)
+	}
+
 	srvErrC := make(chan error)
 	go func() {
 		srvErrC <- srv.ListenAndServe()
@@ -60,12 +246,113 @@ func runWebsocket(ctx *cli.Context) error {
 	sigC := make(chan os.Signal, 1)
 	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
 
-	var err error
 	select {
 	case err = <-srvErrC:
 	case <-sigC:
+		cancelSrv()
 		err = srv.Shutdown(context.Background())
 	}
 
 	return err
 }
+
+type store struct {
+	peerSvc   peerhub.PeerServiceV2
+	signalSvc peerhub.SignalServiceV2
+	// shared stores are used by several hubs at once
+	shared bool
+	// dataDir is the directory of a store kept on disk, a generated key secret is kept there
+	dataDir string
+	// relay connects hubs sharing the store, nil if the store doesn't provide one
+	relay mailbox.Relay
+	// close flushes and releases the store
+	close func()
+}
+
+// newStore creates peer and signal services of the selected store
+func newStore(ctx *cli.Context) (store, error) {
+	switch kind := ctx.String("store"); kind {
+	case storeMemory:
+		return store{
+			peerSvc:   peerhub.AdaptPeerService(peer.NewInMemoryService()),
+			signalSvc: peerhub.AdaptSignalService(sig.NewInMemoryService()),
+			close:     func() {},
+		}, nil
+	case storeFile:
+		peerSvc, err := peer.NewFileService(ctx.String("data-dir"))
+		if err != nil {
+			return store{}, fmt.Errorf("error opening peer store: %w", err)
+		}
+		signalSvc, err := sig.NewFileService(ctx.String("data-dir"))
+		if err != nil {
+			peerSvc.Close()
+			return store{}, fmt.Errorf("error opening signal store: %w", err)
+		}
+		closeStore := func() {
+			if err := errors.Join(peerSvc.Close(), signalSvc.Close()); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return store{
+			peerSvc:   peerhub.AdaptPeerService(peerSvc),
+			signalSvc: peerhub.AdaptSignalService(signalSvc),
+			dataDir:   ctx.String("data-dir"),
+			close:     closeStore,
+		}, nil
+	case storeSQL:
+		driver := ctx.String("sql-driver")
+		dialect, err := sqlstore.DialectFor(driver)
+		if err != nil {
+			return store{}, err
+		}
+		// drivers are linked in with build tags named after their dialects, see sqldriver_*.go
+		if !slices.Contains(sql.Drivers(), driver) {
+			return store{}, fmt.Errorf("sql driver %q is not compiled into the hub, build it with -tags %s", driver, dialect.Name)
+		}
+		db, err := sql.Open(driver, ctx.String("sql-dsn"))
+		if err != nil {
+			return store{}, fmt.Errorf("error opening database: %w", err)
+		}
+		if migrated, err := sqlstore.Migrate(ctx.Context, db, dialect); err != nil {
+			db.Close()
+			return store{}, fmt.Errorf("error migrating database: %w", err)
+		} else if migrated > 0 {
+			fmt.Printf("applied %d database migrations\n", migrated)
+		}
+		closeStore := func() {
+			if err := db.Close(); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return store{
+			peerSvc:   sqlstore.NewPeerService(db, dialect),
+			signalSvc: sqlstore.NewSignalService(db, dialect),
+			shared:    true,
+			close:     closeStore,
+		}, nil
+	case storeRedis:
+		c, err := resp.Dial(resp.Options{
+			Addr:     ctx.String("redis-addr"),
+			Password: ctx.String("redis-password"),
+			DB:       ctx.Int("redis-db"),
+		})
+		if err != nil {
+			return store{}, fmt.Errorf("error connecting to redis: %w", err)
+		}
+		prefix := ctx.String("redis-prefix")
+		closeStore := func() {
+			if err := c.Close(); err != nil {
+				fmt.Println(err)
+			}
+		}
+		return store{
+			peerSvc:   peerhub.AdaptPeerService(redisstore.NewPeerService(c, prefix)),
+			signalSvc: peerhub.AdaptSignalService(redisstore.NewSignalService(c, prefix)),
+			shared:    true,
+			relay:     mailbox.NewRedisRelay(c, prefix, uuid.NewString()),
+			close:     closeStore,
+		}, nil
+	default:
+		return store{}, fmt.Errorf("invalid store %q", kind)
+	}
+}
@@ -1,55 +1,164 @@
 package websocketcmd
 
 import (
+	"errors"
 	"sync"
 )
 
+// errPeerNotRegistered is returned for requests naming a peer the connection didn't register
+var errPeerNotRegistered = errors.New("peer is not registered on this connection")
+
+// cachedWriter is peer's writer along with the version its registration stored the peer at
+type cachedWriter struct {
+	*writer
+	version int
+}
+
 type writerCache struct {
 	mu       sync.Mutex
-	aWriters map[string]*writer
-	oWriters map[string]*writer
+	aWriters map[string]cachedWriter
+	oWriters map[string]cachedWriter
 }
 
 func newConnCache() *writerCache {
 	return &writerCache{
 		mu:       sync.Mutex{},
-		aWriters: map[string]*writer{},
-		oWriters: map[string]*writer{},
+		aWriters: map[string]cachedWriter{},
+		oWriters: map[string]cachedWriter{},
 	}
 }
 
 func (c *writerCache) getA(peerName string) (*writer, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	conn, ok := c.aWriters[peerName]
-	return conn, ok
+	w, ok := c.aWriters[peerName]
+	return w.writer, ok
 }
 
-func (c *writerCache) setA(peerName string, newW *writer, close bool) (err error) {
+// setA caches peer's writer and the version the peer was stored at
+func (c *writerCache) setA(peerName string, newW *writer, version int, close bool) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	w, ok := c.aWriters[peerName]
-	if ok && close {
+	if ok && close && w.conn != newW.conn {
 		err = w.conn.Close()
 	}
-	c.aWriters[peerName] = newW
+	c.aWriters[peerName] = cachedWriter{writer: newW, version: version}
+	return err
+}
+
+// setVersionA records the version peer was stored at by a change made through this hub
+func (c *writerCache) setVersionA(peerName string, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w, ok := c.aWriters[peerName]; ok {
+		w.version = version
+		c.aWriters[peerName] = w
+	}
+}
+
+// ownsA tells if answering peer's writer belongs to the connection
+func (c *writerCache) ownsA(peerName string, conn *connection) bool {
+	w, ok := c.getA(peerName)
+	return ok && w.conn == conn
+}
+
+// removeA removes peer's writer only if it still belongs to the connection and returns the cached version
+func (c *writerCache) removeA(peerName string, conn *connection) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.aWriters[peerName]
+	if !ok || w.conn != conn {
+		return 0, false
+	}
+	delete(c.aWriters, peerName)
+	return w.version, true
+}
+
+// deleteA removes peer's writer, its connection is closed unless it is the skip connection
+func (c *writerCache) deleteA(peerName string, skip *connection) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.aWriters[peerName]
+	if ok && w.conn != skip {
+		err = w.conn.Close()
+	}
+	delete(c.aWriters, peerName)
 	return err
 }
 
 func (c *writerCache) getO(peerName string) (*writer, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	conn, ok := c.oWriters[peerName]
-	return conn, ok
+	w, ok := c.oWriters[peerName]
+	return w.writer, ok
 }
 
-func (c *writerCache) setO(peerName string, newW *writer, close bool) (err error) {
+// setO caches peer's writer and the version the peer was stored at
+func (c *writerCache) setO(peerName string, newW *writer, version int, close bool) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	w, ok := c.oWriters[peerName]
-	if ok && close {
+	if ok && close && w.conn != newW.conn {
 		err = w.conn.Close()
 	}
-	c.oWriters[peerName] = newW
+	c.oWriters[peerName] = cachedWriter{writer: newW, version: version}
 	return err
 }
+
+// setVersionO records the version peer was stored at by a change made through this hub
+func (c *writerCache) setVersionO(peerName string, version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w, ok := c.oWriters[peerName]; ok {
+		w.version = version
+		c.oWriters[peerName] = w
+	}
+}
+
+// ownsO tells if offering peer's writer belongs to the connection
+func (c *writerCache) ownsO(peerName string, conn *connection) bool {
+	w, ok := c.getO(peerName)
+	return ok && w.conn == conn
+}
+
+// removeO removes peer's writer only if it still belongs to the connection and returns the cached version
+func (c *writerCache) removeO(peerName string, conn *connection) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.oWriters[peerName]
+	if !ok || w.conn != conn {
+		return 0, false
+	}
+	delete(c.oWriters, peerName)
+	return w.version, true
+}
+
+// deleteO removes peer's writer, its connection is closed unless it is the skip connection
+func (c *writerCache) deleteO(peerName string, skip *connection) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.oWriters[peerName]
+	if ok && w.conn != skip {
+		err = w.conn.Close()
+	}
+	delete(c.oWriters, peerName)
+	return err
+}
+
+// peersOf returns names of answering and offering peers whose writers use the connection
+func (c *writerCache) peersOf(conn *connection) (aps, ops []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, w := range c.aWriters {
+		if w.conn == conn {
+			aps = append(aps, name)
+		}
+	}
+	for name, w := range c.oWriters {
+		if w.conn == conn {
+			ops = append(ops, name)
+		}
+	}
+	return aps, ops
+}
@@ -0,0 +1,113 @@
+package peerhub
+
+import "errors"
+
+// ErrorCode is a stable machine-readable name of an error, transports send it along the error message
+// so clients don't have to match messages
+type ErrorCode string
+
+const (
+	CodeOfferingPeerNotFound       ErrorCode = "offering_peer_not_found"
+	CodeOfferingPeerAlreadyExists  ErrorCode = "offering_peer_already_exists"
+	CodeOfferingPeerConflict       ErrorCode = "offering_peer_conflict"
+	CodeAnsweringPeerNotFound      ErrorCode = "answering_peer_not_found"
+	CodeAnsweringPeerAlreadyExists ErrorCode = "answering_peer_already_exists"
+	CodeAnsweringPeerConflict      ErrorCode = "answering_peer_conflict"
+	CodeInvalidAccessKey           ErrorCode = "invalid_access_key"
+	CodeInvalidManagementKey       ErrorCode = "invalid_management_key"
+	CodeInvalidPeerRole            ErrorCode = "invalid_peer_role"
+	CodeInvalidKeyHash             ErrorCode = "invalid_key_hash"
+	CodeAdminDisabled              ErrorCode = "admin_disabled"
+	CodeInvalidMasterPassword      ErrorCode = "invalid_master_password"
+	CodeOfferNotFound              ErrorCode = "offer_not_found"
+	CodeOfferAlreadyAnswered       ErrorCode = "offer_already_answered"
+	CodeAnswerNotFound             ErrorCode = "answer_not_found"
+	CodePeerNotInOffer             ErrorCode = "peer_not_in_offer"
+	CodeCooldownNotFound           ErrorCode = "cooldown_not_found"
+	CodeOfferCooldown              ErrorCode = "offer_cooldown"
+	CodeInvalidPeerName            ErrorCode = "invalid_peer_name"
+	CodeNotRemotePeer              ErrorCode = "not_remote_peer"
+	CodeTooManyAccessKeys          ErrorCode = "too_many_access_keys"
+)
+
+// codes of errors of transports
+const (
+	// CodeInvalidRequest is the code of requests transports couldn't decode
+	CodeInvalidRequest     ErrorCode = "invalid_request"
+	CodeUnknownMessageType ErrorCode = "unknown_message_type"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeConflict           ErrorCode = "conflict"
+	CodeSessionNotFound    ErrorCode = "session_not_found"
+	CodeReplayUnavailable  ErrorCode = "replay_unavailable"
+	// CodePeerUnreachable is the code of messages that couldn't be delivered to a peer
+	CodePeerUnreachable ErrorCode = "peer_unreachable"
+	CodeHubUnavailable  ErrorCode = "hub_unavailable"
+	CodeRateLimited     ErrorCode = "rate_limited"
+	// CodeInternal is the code of errors without a code of their own
+	CodeInternal ErrorCode = "internal"
+)
+
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrOfferingPeerNotFound, CodeOfferingPeerNotFound},
+	{ErrOfferingPeerAlreadyExists, CodeOfferingPeerAlreadyExists},
+	{ErrOfferingPeerConflict, CodeOfferingPeerConflict},
+	{ErrAnsweringPeerNotFound, CodeAnsweringPeerNotFound},
+	{ErrAnsweringPeerAlreadyExists, CodeAnsweringPeerAlreadyExists},
+	{ErrAnsweringPeerConflict, CodeAnsweringPeerConflict},
+	{ErrInvalidAccessKey, CodeInvalidAccessKey},
+	{ErrInvalidManagementKey, CodeInvalidManagementKey},
+	{ErrInvalidPeerRole, CodeInvalidPeerRole},
+	{ErrInvalidKeyHash, CodeInvalidKeyHash},
+	{ErrAdminDisabled, CodeAdminDisabled},
+	{ErrInvalidMasterPassword, CodeInvalidMasterPassword},
+	{ErrOfferNotFound, CodeOfferNotFound},
+	{ErrOfferAlreadyAnswered, CodeOfferAlreadyAnswered},
+	{ErrAnswerNotFound, CodeAnswerNotFound},
+	{ErrPeerNotInOffer, CodePeerNotInOffer},
+	{ErrCooldownNotFound, CodeCooldownNotFound},
+	{ErrOfferCooldown, CodeOfferCooldown},
+	{ErrInvalidPeerName, CodeInvalidPeerName},
+	{ErrNotRemotePeer, CodeNotRemotePeer},
+	{ErrTooManyAccessKeys, CodeTooManyAccessKeys},
+}
+
+// CodeOf returns code of the first error of the hub found in the chain, false if there is none
+func CodeOf(err error) (ErrorCode, bool) {
+	for _, ec := range errorCodes {
+		if errors.Is(err, ec.err) {
+			return ec.code, true
+		}
+	}
+	return "", false
+}
+
+// ErrorOf returns the error of the hub the code names, false if the code isn't one of them
+func ErrorOf(code ErrorCode) (error, bool) {
+	for _, ec := range errorCodes {
+		if ec.code == code {
+			return ec.err, true
+		}
+	}
+	return nil, false
+}
+
+// ErrorData is the serializable form of an error, Details hold optional fields specific to the code
+type ErrorData struct {
+	Code    ErrorCode      `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// NewErrorData returns the serializable form of the error, errors without a code are CodeInternal
+func NewErrorData(err error) ErrorData {
+	code, ok := CodeOf(err)
+	if !ok {
+		code = CodeInternal
+	}
+	return ErrorData{Code: code, Message: err.Error()}
+}
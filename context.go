@@ -0,0 +1,304 @@
+package peerhub
+
+import (
+	"context"
+	"time"
+)
+
+// PeerServiceV2 is PeerService whose methods accept a context, implementations should give up once it's done,
+// creates, updates and deletes follow the same compare-and-swap rules
+type PeerServiceV2 interface {
+	CreateAnsweringPeer(context.Context, AnsweringPeer) error
+	UpdateAnsweringPeer(context.Context, AnsweringPeer) error
+	GetAnsweringPeer(ctx context.Context, name string) (AnsweringPeer, error)
+	GetAnsweringPeers(context.Context) ([]AnsweringPeer, error)
+	DeleteAnsweringPeer(context.Context, AnsweringPeer) error
+
+	CreateOfferingPeer(context.Context, OfferingPeer) error
+	UpdateOfferingPeer(context.Context, OfferingPeer) error
+	GetOfferingPeer(ctx context.Context, name string) (OfferingPeer, error)
+	GetOfferingPeers(context.Context) ([]OfferingPeer, error)
+	GetOfferingPeersByTarget(ctx context.Context, name string) ([]OfferingPeer, error)
+	DeleteOfferingPeer(context.Context, OfferingPeer) error
+}
+
+// SignalServiceV2 is SignalService whose methods accept a context, implementations should give up once it's done
+type SignalServiceV2 interface {
+	CreateOffer(context.Context, Offer) error
+	GetOffer(ctx context.Context, offerID string) (Offer, error)
+	GetOffers(context.Context) ([]Offer, error)
+	GetOffersByOfferingPeer(ctx context.Context, name string) ([]Offer, error)
+	GetOffersByAnsweringPeer(ctx context.Context, name string) ([]Offer, error)
+	DeleteOffer(ctx context.Context, offerID string) error
+	// DeleteExpiredOffers deletes offers expired at the given time and returns them
+	DeleteExpiredOffers(ctx context.Context, now time.Time) ([]Offer, error)
+
+	CreateAnswer(context.Context, Answer) error
+	// AnswerOffer stores the answer and moves its pending offer to the answered state in one step,
+	// ErrOfferAlreadyAnswered is returned if the offer is not pending anymore
+	AnswerOffer(context.Context, Answer) (Offer, error)
+	GetAnswer(ctx context.Context, answerID string) (Answer, error)
+	GetAnswersByOffer(ctx context.Context, offerID string) ([]Answer, error)
+	DeleteAnswer(ctx context.Context, answerID string) error
+	// DeleteExpiredAnswers deletes answers expired at the given time and returns them
+	DeleteExpiredAnswers(ctx context.Context, now time.Time) ([]Answer, error)
+
+	CreateCandidate(context.Context, Candidate) error
+	// PopCandidates returns and removes candidates of the offer addressed to the peer
+	PopCandidates(ctx context.Context, offerID, peerName string) ([]Candidate, error)
+
+	CreateCooldown(context.Context, Cooldown) error
+	GetCooldown(ctx context.Context, opName, apName string) (Cooldown, error)
+	// DeleteExpiredCooldowns deletes cooldowns expired at the given time and returns them
+	DeleteExpiredCooldowns(ctx context.Context, now time.Time) ([]Cooldown, error)
+
+	CreateMail(context.Context, Mail) error
+	// PopMail returns and removes mail queued for the peer in the order it was created
+	PopMail(ctx context.Context, role PeerRole, name string) ([]Mail, error)
+	// DeleteExpiredMail deletes mail expired at the given time and returns it
+	DeleteExpiredMail(ctx context.Context, now time.Time) ([]Mail, error)
+}
+
+// AdaptPeerService makes a PeerService usable as PeerServiceV2, calls fail without reaching the service
+// once the context is done but a call which already started runs to completion
+func AdaptPeerService(svc PeerService) PeerServiceV2 {
+	return peerServiceAdapter{svc: svc}
+}
+
+type peerServiceAdapter struct {
+	svc PeerService
+}
+
+func (a peerServiceAdapter) CreateAnsweringPeer(ctx context.Context, ap AnsweringPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateAnsweringPeer(ap)
+}
+
+func (a peerServiceAdapter) UpdateAnsweringPeer(ctx context.Context, ap AnsweringPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.UpdateAnsweringPeer(ap)
+}
+
+func (a peerServiceAdapter) GetAnsweringPeer(ctx context.Context, name string) (AnsweringPeer, error) {
+	if err := ctx.Err(); err != nil {
+		return AnsweringPeer{}, err
+	}
+	return a.svc.GetAnsweringPeer(name)
+}
+
+func (a peerServiceAdapter) GetAnsweringPeers(ctx context.Context) ([]AnsweringPeer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetAnsweringPeers()
+}
+
+func (a peerServiceAdapter) DeleteAnsweringPeer(ctx context.Context, ap AnsweringPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.DeleteAnsweringPeer(ap)
+}
+
+func (a peerServiceAdapter) CreateOfferingPeer(ctx context.Context, op OfferingPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateOfferingPeer(op)
+}
+
+func (a peerServiceAdapter) UpdateOfferingPeer(ctx context.Context, op OfferingPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.UpdateOfferingPeer(op)
+}
+
+func (a peerServiceAdapter) GetOfferingPeer(ctx context.Context, name string) (OfferingPeer, error) {
+	if err := ctx.Err(); err != nil {
+		return OfferingPeer{}, err
+	}
+	return a.svc.GetOfferingPeer(name)
+}
+
+func (a peerServiceAdapter) GetOfferingPeers(ctx context.Context) ([]OfferingPeer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetOfferingPeers()
+}
+
+func (a peerServiceAdapter) GetOfferingPeersByTarget(ctx context.Context, name string) ([]OfferingPeer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetOfferingPeersByTarget(name)
+}
+
+func (a peerServiceAdapter) DeleteOfferingPeer(ctx context.Context, op OfferingPeer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.DeleteOfferingPeer(op)
+}
+
+// AdaptSignalService makes a SignalService usable as SignalServiceV2, calls fail without reaching the service
+// once the context is done but a call which already started runs to completion
+func AdaptSignalService(svc SignalService) SignalServiceV2 {
+	return signalServiceAdapter{svc: svc}
+}
+
+type signalServiceAdapter struct {
+	svc SignalService
+}
+
+func (a signalServiceAdapter) CreateOffer(ctx context.Context, o Offer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateOffer(o)
+}
+
+func (a signalServiceAdapter) GetOffer(ctx context.Context, offerID string) (Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return Offer{}, err
+	}
+	return a.svc.GetOffer(offerID)
+}
+
+func (a signalServiceAdapter) GetOffers(ctx context.Context) ([]Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetOffers()
+}
+
+func (a signalServiceAdapter) GetOffersByOfferingPeer(ctx context.Context, name string) ([]Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetOffersByOfferingPeer(name)
+}
+
+func (a signalServiceAdapter) GetOffersByAnsweringPeer(ctx context.Context, name string) ([]Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetOffersByAnsweringPeer(name)
+}
+
+func (a signalServiceAdapter) DeleteOffer(ctx context.Context, offerID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.DeleteOffer(offerID)
+}
+
+func (a signalServiceAdapter) DeleteExpiredOffers(ctx context.Context, now time.Time) ([]Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.DeleteExpiredOffers(now)
+}
+
+func (a signalServiceAdapter) CreateAnswer(ctx context.Context, ans Answer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateAnswer(ans)
+}
+
+func (a signalServiceAdapter) AnswerOffer(ctx context.Context, ans Answer) (Offer, error) {
+	if err := ctx.Err(); err != nil {
+		return Offer{}, err
+	}
+	return a.svc.AnswerOffer(ans)
+}
+
+func (a signalServiceAdapter) GetAnswer(ctx context.Context, answerID string) (Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return Answer{}, err
+	}
+	return a.svc.GetAnswer(answerID)
+}
+
+func (a signalServiceAdapter) GetAnswersByOffer(ctx context.Context, offerID string) ([]Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.GetAnswersByOffer(offerID)
+}
+
+func (a signalServiceAdapter) DeleteAnswer(ctx context.Context, answerID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.DeleteAnswer(answerID)
+}
+
+func (a signalServiceAdapter) DeleteExpiredAnswers(ctx context.Context, now time.Time) ([]Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.DeleteExpiredAnswers(now)
+}
+
+func (a signalServiceAdapter) CreateCandidate(ctx context.Context, c Candidate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateCandidate(c)
+}
+
+func (a signalServiceAdapter) PopCandidates(ctx context.Context, offerID, peerName string) ([]Candidate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.PopCandidates(offerID, peerName)
+}
+
+func (a signalServiceAdapter) CreateCooldown(ctx context.Context, c Cooldown) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateCooldown(c)
+}
+
+func (a signalServiceAdapter) GetCooldown(ctx context.Context, opName, apName string) (Cooldown, error) {
+	if err := ctx.Err(); err != nil {
+		return Cooldown{}, err
+	}
+	return a.svc.GetCooldown(opName, apName)
+}
+
+func (a signalServiceAdapter) DeleteExpiredCooldowns(ctx context.Context, now time.Time) ([]Cooldown, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.DeleteExpiredCooldowns(now)
+}
+
+func (a signalServiceAdapter) CreateMail(ctx context.Context, m Mail) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.svc.CreateMail(m)
+}
+
+func (a signalServiceAdapter) PopMail(ctx context.Context, role PeerRole, name string) ([]Mail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.PopMail(role, name)
+}
+
+func (a signalServiceAdapter) DeleteExpiredMail(ctx context.Context, now time.Time) ([]Mail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.svc.DeleteExpiredMail(now)
+}
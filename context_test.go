@@ -0,0 +1,160 @@
+package peerhub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+// check fails the test unless the error of the call is want
+func check(t *testing.T, call string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s returned %v, want %v", call, err, want)
+	}
+}
+
+func TestPeerServiceAdapter(t *testing.T) {
+	ctx := context.Background()
+	v1 := peer.NewInMemoryService()
+	v2 := peerhub.AdaptPeerService(v1)
+
+	ap := peerhub.AnsweringPeer{Name: "ap"}
+	check(t, "CreateAnsweringPeer", v2.CreateAnsweringPeer(ctx, ap), nil)
+	if _, err := v1.GetAnsweringPeer("ap"); err != nil {
+		t.Fatalf("answering peer created through the adapter: %v", err)
+	}
+	check(t, "CreateAnsweringPeer", v2.CreateAnsweringPeer(ctx, ap), peerhub.ErrAnsweringPeerAlreadyExists)
+
+	ap, err := v2.GetAnsweringPeer(ctx, "ap")
+	check(t, "GetAnsweringPeer", err, nil)
+	ap.Online = true
+	check(t, "UpdateAnsweringPeer", v2.UpdateAnsweringPeer(ctx, ap), nil)
+	check(t, "UpdateAnsweringPeer", v2.UpdateAnsweringPeer(ctx, ap), peerhub.ErrAnsweringPeerConflict)
+	if ap, err = v2.GetAnsweringPeer(ctx, "ap"); err != nil || !ap.Online {
+		t.Errorf("GetAnsweringPeer returned %+v %v, want the updated peer", ap, err)
+	}
+	if aps, err := v2.GetAnsweringPeers(ctx); err != nil || len(aps) != 1 {
+		t.Errorf("GetAnsweringPeers returned %+v %v, want one peer", aps, err)
+	}
+
+	op := peerhub.OfferingPeer{Name: "op", TargetName: "ap"}
+	check(t, "CreateOfferingPeer", v2.CreateOfferingPeer(ctx, op), nil)
+	check(t, "CreateOfferingPeer", v2.CreateOfferingPeer(ctx, op), peerhub.ErrOfferingPeerAlreadyExists)
+	op, err = v2.GetOfferingPeer(ctx, "op")
+	check(t, "GetOfferingPeer", err, nil)
+	check(t, "UpdateOfferingPeer", v2.UpdateOfferingPeer(ctx, op), nil)
+	check(t, "UpdateOfferingPeer", v2.UpdateOfferingPeer(ctx, op), peerhub.ErrOfferingPeerConflict)
+	if ops, err := v2.GetOfferingPeersByTarget(ctx, "ap"); err != nil || len(ops) != 1 {
+		t.Errorf("GetOfferingPeersByTarget returned %+v %v, want one peer", ops, err)
+	}
+	if ops, err := v2.GetOfferingPeers(ctx); err != nil || len(ops) != 1 {
+		t.Errorf("GetOfferingPeers returned %+v %v, want one peer", ops, err)
+	}
+
+	got, err := v2.GetOfferingPeer(ctx, "op")
+	check(t, "GetOfferingPeer", err, nil)
+	check(t, "DeleteOfferingPeer", v2.DeleteOfferingPeer(ctx, op), peerhub.ErrOfferingPeerConflict)
+	check(t, "DeleteOfferingPeer", v2.DeleteOfferingPeer(ctx, got), nil)
+	_, err = v2.GetOfferingPeer(ctx, "op")
+	check(t, "GetOfferingPeer", err, peerhub.ErrOfferingPeerNotFound)
+
+	check(t, "DeleteAnsweringPeer", v2.DeleteAnsweringPeer(ctx, ap), nil)
+	_, err = v2.GetAnsweringPeer(ctx, "ap")
+	check(t, "GetAnsweringPeer", err, peerhub.ErrAnsweringPeerNotFound)
+
+	// calls with a done context don't reach the service
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	check(t, "CreateAnsweringPeer", v2.CreateAnsweringPeer(cancelled, peerhub.AnsweringPeer{Name: "ap"}), context.Canceled)
+	if _, err := v1.GetAnsweringPeer("ap"); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("answering peer was created with a done context")
+	}
+}
+
+func TestSignalServiceAdapter(t *testing.T) {
+	ctx := context.Background()
+	v1 := sig.NewInMemoryService()
+	v2 := peerhub.AdaptSignalService(v1)
+	expired := time.Now().Add(time.Hour)
+
+	o := peerhub.NewOffer("op", "offer", "ap", time.Minute)
+	check(t, "CreateOffer", v2.CreateOffer(ctx, o), nil)
+	if _, err := v1.GetOffer(o.ID); err != nil {
+		t.Fatalf("offer created through the adapter: %v", err)
+	}
+	if os, err := v2.GetOffersByOfferingPeer(ctx, "op"); err != nil || len(os) != 1 {
+		t.Errorf("GetOffersByOfferingPeer returned %+v %v, want the offer", os, err)
+	}
+	if os, err := v2.GetOffersByAnsweringPeer(ctx, "ap"); err != nil || len(os) != 1 {
+		t.Errorf("GetOffersByAnsweringPeer returned %+v %v, want the offer", os, err)
+	}
+
+	a := peerhub.NewAnswer(o.ID, "op", "ap", "answer", time.Minute)
+	if answered, err := v2.AnswerOffer(ctx, a); err != nil || answered.AnswerID != a.ID {
+		t.Errorf("AnswerOffer returned %+v %v, want the answered offer", answered, err)
+	}
+	_, err := v2.AnswerOffer(ctx, peerhub.NewAnswer(o.ID, "op", "ap", "answer", time.Minute))
+	check(t, "AnswerOffer", err, peerhub.ErrOfferAlreadyAnswered)
+	if got, err := v2.GetAnswer(ctx, a.ID); err != nil || got.SDP != "answer" {
+		t.Errorf("GetAnswer returned %+v %v, want the answer", got, err)
+	}
+	if as, err := v2.GetAnswersByOffer(ctx, o.ID); err != nil || len(as) != 1 {
+		t.Errorf("GetAnswersByOffer returned %+v %v, want the answer", as, err)
+	}
+
+	check(t, "CreateCandidate", v2.CreateCandidate(ctx, peerhub.Candidate{OfferID: o.ID, From: "op", To: "ap"}), nil)
+	check(t, "CreateCandidate", v2.CreateCandidate(ctx, peerhub.Candidate{OfferID: "unknown"}), peerhub.ErrOfferNotFound)
+	if cs, err := v2.PopCandidates(ctx, o.ID, "ap"); err != nil || len(cs) != 1 {
+		t.Errorf("PopCandidates returned %+v %v, want the candidate", cs, err)
+	}
+
+	_, err = v2.GetCooldown(ctx, "op", "ap")
+	check(t, "GetCooldown", err, peerhub.ErrCooldownNotFound)
+	check(t, "CreateCooldown", v2.CreateCooldown(ctx, peerhub.Cooldown{OfferingPeer: "op", AnsweringPeer: "ap", ExpiresAt: time.Now()}), nil)
+	_, err = v2.GetCooldown(ctx, "op", "ap")
+	check(t, "GetCooldown", err, nil)
+
+	mail := peerhub.Mail{RecipientRole: peerhub.PeerRoleAnswering, Recipient: "ap", ExpiresAt: time.Now()}
+	check(t, "CreateMail", v2.CreateMail(ctx, mail), nil)
+	if ms, err := v2.PopMail(ctx, peerhub.PeerRoleAnswering, "ap"); err != nil || len(ms) != 1 {
+		t.Errorf("PopMail returned %+v %v, want the mail", ms, err)
+	}
+	check(t, "CreateMail", v2.CreateMail(ctx, mail), nil)
+
+	if os, err := v2.DeleteExpiredOffers(ctx, expired); err != nil || len(os) != 1 {
+		t.Errorf("DeleteExpiredOffers returned %+v %v, want the offer", os, err)
+	}
+	if as, err := v2.DeleteExpiredAnswers(ctx, expired); err != nil || len(as) != 1 {
+		t.Errorf("DeleteExpiredAnswers returned %+v %v, want the answer", as, err)
+	}
+	if cs, err := v2.DeleteExpiredCooldowns(ctx, expired); err != nil || len(cs) != 1 {
+		t.Errorf("DeleteExpiredCooldowns returned %+v %v, want the cooldown", cs, err)
+	}
+	if ms, err := v2.DeleteExpiredMail(ctx, expired); err != nil || len(ms) != 1 {
+		t.Errorf("DeleteExpiredMail returned %+v %v, want the mail", ms, err)
+	}
+
+	check(t, "CreateOffer", v2.CreateOffer(ctx, o), nil)
+	check(t, "CreateAnswer", v2.CreateAnswer(ctx, a), nil)
+	check(t, "DeleteAnswer", v2.DeleteAnswer(ctx, a.ID), nil)
+	check(t, "DeleteOffer", v2.DeleteOffer(ctx, o.ID), nil)
+	_, err = v2.GetOffer(ctx, o.ID)
+	check(t, "GetOffer", err, peerhub.ErrOfferNotFound)
+	if os, err := v2.GetOffers(ctx); err != nil || len(os) != 0 {
+		t.Errorf("GetOffers returned %+v %v, want no offers", os, err)
+	}
+
+	// calls with a done context don't reach the service
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	check(t, "CreateOffer", v2.CreateOffer(cancelled, o), context.Canceled)
+	if _, err := v1.GetOffer(o.ID); !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("offer was created with a done context")
+	}
+}
@@ -0,0 +1,142 @@
+package peerhub
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidPeerName = errors.New("peer name can't contain @ on a federated hub")
+	ErrNotRemotePeer   = errors.New("peer is not a peer of another hub")
+)
+
+// Federation connects the hub to other hubs, their answering peers are addressed as name@hub
+type Federation interface {
+	// Remote tells if the name addresses a peer of another hub
+	Remote(name string) bool
+	// Offer makes an offer from the offering peer to its target on another hub,
+	// the returned offer is named the way this hub sees its peers
+	Offer(ctx context.Context, op OfferingPeer) (offer Offer, failedOffer FailedOffer, isOffer, isFailed bool, err error)
+}
+
+// validName rejects local names which could be confused with addresses of other hubs
+func (h *Hub) validName(name string) error {
+	if h.federation != nil && strings.Contains(name, "@") {
+		return ErrInvalidPeerName
+	}
+	return nil
+}
+
+// remoteOffer makes the offer at the hub of op's target and keeps a copy of it,
+// so candidates of the offering peer can be relayed the same way as for local offers
+func (h *Hub) remoteOffer(ctx context.Context, op OfferingPeer) (Offer, FailedOffer, bool, bool, error) {
+	// the hub of the target verifies the key
+	key, err := h.keySealer.open(op.Name, op.TargetAccessKey)
+	if err != nil {
+		return Offer{}, NewFailedOffer(op.Name, op.TargetName, ErrInvalidAccessKey), false, true, nil
+	}
+	op.TargetAccessKey = key
+
+	offer, failed, isOffer, isFailed, err := h.federation.Offer(ctx, op)
+	if err != nil || !isOffer {
+		return offer, failed, isOffer, isFailed, err
+	}
+
+	if err := h.MirrorRemoteOffer(ctx, offer); err != nil {
+		return Offer{}, FailedOffer{}, false, false, err
+	}
+
+	return offer, failed, isOffer, isFailed, nil
+}
+
+// OfferFromRemotePeer makes an offer from an offering peer of another hub to a local answering peer,
+// the remote peer is kept as an offering peer named name@hub so later answering peer registrations reach it too
+func (h *Hub) OfferFromRemotePeer(ctx context.Context, op OfferingPeer) (offer Offer, failedOffer FailedOffer, isOffer, isFailed bool, err error) {
+	if h.federation == nil || !h.federation.Remote(op.Name) {
+		return Offer{}, FailedOffer{}, false, false, ErrNotRemotePeer
+	}
+
+	op.ManagementKey = ""
+	op.Online = true
+	op.TargetAccessKey, err = h.keySealer.seal(op.Name, op.TargetAccessKey)
+	if err != nil {
+		return Offer{}, FailedOffer{}, false, false, err
+	}
+
+	old, err := h.peerSvc.GetOfferingPeer(ctx, op.Name)
+	switch {
+	case err == nil:
+		op.Version = old.Version
+		err = h.peerSvc.UpdateOfferingPeer(ctx, op)
+	case errors.Is(err, ErrOfferingPeerNotFound):
+		err = h.peerSvc.CreateOfferingPeer(ctx, op)
+	}
+	if err != nil {
+		return Offer{}, FailedOffer{}, false, false, err
+	}
+
+	return h.OfferFromOfferingPeer(ctx, op)
+}
+
+// MirrorRemoteOffer stores a copy of an offer made at another hub, an existing copy is left intact
+func (h *Hub) MirrorRemoteOffer(ctx context.Context, offer Offer) error {
+	if h.federation == nil || !h.federation.Remote(offer.AnsweringPeer) {
+		return ErrNotRemotePeer
+	}
+
+	_, err := h.dealSvc.GetOffer(ctx, offer.ID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrOfferNotFound) {
+		return err
+	}
+	return h.dealSvc.CreateOffer(ctx, offer)
+}
+
+// AnswerRemoteOffer moves the copy of an offer answered at another hub to the answered state
+func (h *Hub) AnswerRemoteOffer(ctx context.Context, answer Answer) error {
+	offer, err := h.dealSvc.GetOffer(ctx, answer.OfferID)
+	if err != nil {
+		return err
+	}
+
+	if offer.AnsweringPeer != answer.AnsweringPeer {
+		return ErrPeerNotInOffer
+	}
+
+	_, err = h.dealSvc.AnswerOffer(ctx, answer)
+	return err
+}
+
+// ForgetRemoteOffer deletes the copy of an offer to the remote answering peer which ended at its hub,
+// it reports whether there was one
+func (h *Hub) ForgetRemoteOffer(ctx context.Context, offerID, apName string) (bool, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, offerID)
+	if errors.Is(err, ErrOfferNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if offer.AnsweringPeer != apName {
+		return false, ErrPeerNotInOffer
+	}
+
+	return true, h.deleteOffers(ctx, []Offer{offer})
+}
+
+// DeleteRemoteOfferingPeer deletes an offering peer of another hub after it disappeared there
+func (h *Hub) DeleteRemoteOfferingPeer(ctx context.Context, name string) error {
+	if h.federation == nil || !h.federation.Remote(name) {
+		return ErrNotRemotePeer
+	}
+
+	op, err := h.peerSvc.GetOfferingPeer(ctx, name)
+	if err != nil {
+		return err
+	}
+	return h.deleteOfferingPeer(ctx, op)
+}
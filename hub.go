@@ -1,26 +1,102 @@
 package peerhub
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	DefaultOfferTTL       = 5 * time.Minute
+	DefaultAnswerTTL      = 5 * time.Minute
+	DefaultRejectCooldown = time.Minute
+	// MaxAccessKeys is the number of access keys an answering peer can have, every offer verifies a key
+	// against each of them
+	MaxAccessKeys = 8
+)
+
+// maxKeyVerifications bounds the number of distinct target access keys verified when an answering peer registers
+const maxKeyVerifications = 32
 
 type HubConfig struct {
-	PeerService   PeerService
-	SignalService SignalService
+	// PeerService is used through AdaptPeerService unless PeerServiceV2 is set
+	PeerService PeerService
+	// SignalService is used through AdaptSignalService unless SignalServiceV2 is set
+	SignalService   SignalService
+	PeerServiceV2   PeerServiceV2
+	SignalServiceV2 SignalServiceV2
+	// OfferTTL is the time after which offers expire, defaults to DefaultOfferTTL
+	OfferTTL time.Duration
+	// AnswerTTL is the time after which answers expire, defaults to DefaultAnswerTTL
+	AnswerTTL time.Duration
+	// RejectCooldown is the time an offering peer is blocked from re-offering after a blocking rejection,
+	// defaults to DefaultRejectCooldown
+	RejectCooldown time.Duration
+	// MasterPassword grants access to administrative operations, they are disabled if it's empty
+	MasterPassword string
+	// KeyHasher hashes access and management keys, defaults to argon2id
+	KeyHasher KeyHasher
+	// KeySecret encrypts target access keys of offering peers before they're stored, hubs sharing a store
+	// need the same secret and persistent stores need the one they were written with, a random one is used
+	// if it's empty so stored keys can't be read after a restart
+	KeySecret string
+	// MailTTL is the time messages queued for offline peers are kept, defaults to DefaultMailTTL
+	MailTTL time.Duration
+	// Federation makes offers to answering peers of other hubs, targets are always local if it's nil
+	Federation Federation
 }
 
 type Hub struct {
-	peerSvc PeerService
-	dealSvc SignalService
+	peerSvc        PeerServiceV2
+	dealSvc        SignalServiceV2
+	offerTTL       time.Duration
+	answerTTL      time.Duration
+	rejectCooldown time.Duration
+	masterPassword string
+	keyHasher      KeyHasher
+	keySealer      *keySealer
+	mailTTL        time.Duration
+	federation     Federation
 }
 
 func NewHub(cfg HubConfig) *Hub {
+	if cfg.OfferTTL <= 0 {
+		cfg.OfferTTL = DefaultOfferTTL
+	}
+	if cfg.AnswerTTL <= 0 {
+		cfg.AnswerTTL = DefaultAnswerTTL
+	}
+	if cfg.RejectCooldown <= 0 {
+		cfg.RejectCooldown = DefaultRejectCooldown
+	}
+	if cfg.KeyHasher == nil {
+		cfg.KeyHasher = NewArgon2KeyHasher()
+	}
+	if cfg.MailTTL <= 0 {
+		cfg.MailTTL = DefaultMailTTL
+	}
+	if cfg.PeerServiceV2 == nil {
+		cfg.PeerServiceV2 = AdaptPeerService(cfg.PeerService)
+	}
+	if cfg.SignalServiceV2 == nil {
+		cfg.SignalServiceV2 = AdaptSignalService(cfg.SignalService)
+	}
 	return &Hub{
-		peerSvc: cfg.PeerService,
-		dealSvc: cfg.SignalService,
+		peerSvc:        cfg.PeerServiceV2,
+		dealSvc:        cfg.SignalServiceV2,
+		offerTTL:       cfg.OfferTTL,
+		answerTTL:      cfg.AnswerTTL,
+		rejectCooldown: cfg.RejectCooldown,
+		masterPassword: cfg.MasterPassword,
+		keyHasher:      cfg.KeyHasher,
+		keySealer:      newKeySealer(cfg.KeySecret),
+		mailTTL:        cfg.MailTTL,
+		federation:     cfg.Federation,
 	}
 }
 
-func (h *Hub) GetAnsweringPeersPrevies() ([]AnsweringPeerPreview, error) {
-	aps, err := h.peerSvc.GetAnsweringPeers()
+func (h *Hub) GetAnsweringPeersPrevies(ctx context.Context) ([]AnsweringPeerPreview, error) {
+	aps, err := h.peerSvc.GetAnsweringPeers(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -29,20 +105,56 @@ func (h *Hub) GetAnsweringPeersPrevies() ([]AnsweringPeerPreview, error) {
 		apps = append(apps, AnsweringPeerPreview{
 			Name:      ap.Name,
 			Protected: len(ap.AccessKeys) != 0,
+			Online:    ap.Online,
 		})
 	}
 	return apps, nil
 }
 
-func (h *Hub) CreateAnsweringPeer(req CreateAnsweringPeerRequest) (AnsweringPeer, error) {
-	ap := AnsweringPeer(req)
+func (h *Hub) CreateAnsweringPeer(ctx context.Context, req CreateAnsweringPeerRequest) (AnsweringPeer, error) {
+	if err := h.validName(req.Name); err != nil {
+		return AnsweringPeer{}, err
+	}
 
-	oldAP, err := h.peerSvc.GetAnsweringPeer(ap.Name)
-	if err == nil && oldAP.ManagementKeyMatches(ap.ManagementKey) {
-		err := h.peerSvc.UpdateAnsweringPeer(ap)
+	mKey, err := hashKey(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+
+	if len(req.AccessKeys) > MaxAccessKeys {
+		return AnsweringPeer{}, ErrTooManyAccessKeys
+	}
+	aKeys, err := hashKeys(h.keyHasher, req.AccessKeys)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+
+	ap := AnsweringPeer{
+		Name:          req.Name,
+		AccessKeys:    aKeys,
+		ManagementKey: mKey,
+		Online:        true,
+	}
+
+	oldAP, err := h.peerSvc.GetAnsweringPeer(ctx, ap.Name)
+	if err == nil {
+		ok, err := oldAP.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+		if err != nil {
+			return AnsweringPeer{}, err
+		}
+		if !ok {
+			return AnsweringPeer{}, ErrInvalidManagementKey
+		}
+		// the peer is replaced only if nobody changed it since it was read
+		ap.Version = oldAP.Version
+		err = h.peerSvc.UpdateAnsweringPeer(ctx, ap)
+		if errors.Is(err, ErrAnsweringPeerNotFound) {
+			return AnsweringPeer{}, ErrAnsweringPeerConflict
+		}
 		if err != nil {
 			return AnsweringPeer{}, err
 		}
+		ap.Version++
 		return ap, nil
 	}
 
@@ -50,30 +162,63 @@ func (h *Hub) CreateAnsweringPeer(req CreateAnsweringPeerRequest) (AnsweringPeer
 		return AnsweringPeer{}, err
 	}
 
-	err = h.peerSvc.CreateAnsweringPeer(ap)
+	err = h.peerSvc.CreateAnsweringPeer(ctx, ap)
+	if errors.Is(err, ErrAnsweringPeerAlreadyExists) {
+		// another client registered the name since it was read
+		return AnsweringPeer{}, ErrAnsweringPeerConflict
+	}
 	if err != nil {
 		return AnsweringPeer{}, err
 	}
 
+	ap.Version = 1
 	return ap, nil
 }
 
-func (h *Hub) CreateOfferingPeer(req CreateOfferingPeerRequest) (OfferingPeer, error) {
+func (h *Hub) CreateOfferingPeer(ctx context.Context, req CreateOfferingPeerRequest) (OfferingPeer, error) {
+	if err := h.validName(req.Name); err != nil {
+		return OfferingPeer{}, err
+	}
+
+	mKey, err := hashKey(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+
+	tKey, err := h.keySealer.seal(req.Name, req.TargetAccessKey)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+
 	op := OfferingPeer{
 		Name:            req.Name,
 		TargetName:      req.TargetName,
-		TargetAccessKey: req.TargetAccessKey,
-		ManagementKey:   req.ManagementKey,
+		TargetAccessKey: tKey,
+		ManagementKey:   mKey,
 		SDP:             req.SDP,
 		Delete:          req.Delete,
+		Online:          true,
 	}
 
-	oldOP, err := h.peerSvc.GetOfferingPeer(op.Name)
-	if err == nil && oldOP.ManagementKeyMatches(op.ManagementKey) {
-		err := h.peerSvc.UpdateOfferingPeer(op)
+	oldOP, err := h.peerSvc.GetOfferingPeer(ctx, op.Name)
+	if err == nil {
+		ok, err := oldOP.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+		if err != nil {
+			return OfferingPeer{}, err
+		}
+		if !ok {
+			return OfferingPeer{}, ErrInvalidManagementKey
+		}
+		// the peer is replaced only if nobody changed it since it was read
+		op.Version = oldOP.Version
+		err = h.peerSvc.UpdateOfferingPeer(ctx, op)
+		if errors.Is(err, ErrOfferingPeerNotFound) {
+			return OfferingPeer{}, ErrOfferingPeerConflict
+		}
 		if err != nil {
 			return OfferingPeer{}, err
 		}
+		op.Version++
 		return op, nil
 	}
 
@@ -81,52 +226,321 @@ func (h *Hub) CreateOfferingPeer(req CreateOfferingPeerRequest) (OfferingPeer, e
 		return OfferingPeer{}, err
 	}
 
-	if err := h.peerSvc.CreateOfferingPeer(op); err != nil {
+	err = h.peerSvc.CreateOfferingPeer(ctx, op)
+	if errors.Is(err, ErrOfferingPeerAlreadyExists) {
+		// another client registered the name since it was read
+		return OfferingPeer{}, ErrOfferingPeerConflict
+	}
+	if err != nil {
 		return OfferingPeer{}, err
 	}
 
+	op.Version = 1
 	return op, nil
 }
 
-// CreateAnswer creates an answer and returns the Offer which the answer relates to
-func (h *Hub) CreateAnswer(req CreateAnswerRequest) (Answer, Offer, error) {
-	offer, err := h.dealSvc.GetOffer(req.OfferID)
+// CreateAnswer creates an answer and returns the Offer which the answer relates to,
+// req.PeerName must be the answering peer of the offer
+func (h *Hub) CreateAnswer(ctx context.Context, req CreateAnswerRequest) (Answer, Offer, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, req.OfferID)
 	if err != nil {
 		return Answer{}, Offer{}, err
 	}
-	answer := NewAnswer(offer.ID, offer.AnsweringPeer, req.SDP)
+
+	if offer.AnsweringPeer != req.PeerName {
+		return Answer{}, Offer{}, ErrPeerNotInOffer
+	}
+
+	if offer.Expired(time.Now()) {
+		return Answer{}, Offer{}, ErrOfferNotFound
+	}
+	answer := NewAnswer(offer.ID, offer.OfferingPeer, offer.AnsweringPeer, req.SDP, h.answerTTL)
+
+	offer, err = h.dealSvc.AnswerOffer(ctx, answer)
+	if err != nil {
+		return Answer{}, Offer{}, err
+	}
+
 	return answer, offer, nil
 }
 
-func (h *Hub) OffersForAnsweringPeer(ap AnsweringPeer) ([]Offer, []FailedOffer, error) {
-	ops, err := h.peerSvc.GetOfferingPeersByTarget(ap.Name)
+// RejectOffer deletes a pending offer the answering peer declined or failed to answer,
+// with req.Block the offering peer can't offer to the answering peer again until the cooldown passes,
+// req.PeerName must be the answering peer of the offer
+func (h *Hub) RejectOffer(ctx context.Context, req RejectOfferRequest) (RejectedOffer, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, req.OfferID)
+	if err != nil {
+		return RejectedOffer{}, err
+	}
+
+	if offer.AnsweringPeer != req.PeerName {
+		return RejectedOffer{}, ErrPeerNotInOffer
+	}
+
+	if offer.State != OfferStatePending {
+		return RejectedOffer{}, ErrOfferAlreadyAnswered
+	}
+
+	if err := h.deleteOffers(ctx, []Offer{offer}); err != nil {
+		return RejectedOffer{}, err
+	}
+
+	reason := req.Reason
+	if reason == "" {
+		reason = RejectReasonDeclined
+	}
+
+	ro := RejectedOffer{
+		OfferID:       offer.ID,
+		OfferingPeer:  offer.OfferingPeer,
+		AnsweringPeer: offer.AnsweringPeer,
+		Reason:        reason,
+		Message:       req.Message,
+	}
+
+	if req.Block {
+		c := Cooldown{
+			OfferingPeer:  offer.OfferingPeer,
+			AnsweringPeer: offer.AnsweringPeer,
+			ExpiresAt:     time.Now().Add(h.rejectCooldown),
+		}
+		if err := h.dealSvc.CreateCooldown(ctx, c); err != nil {
+			return RejectedOffer{}, err
+		}
+		ro.RetryAfter = &c.ExpiresAt
+	}
+
+	return ro, nil
+}
+
+// coolingDown tells if the offering peer is blocked from offering to the answering peer
+func (h *Hub) coolingDown(ctx context.Context, opName, apName string) (bool, error) {
+	c, err := h.dealSvc.GetCooldown(ctx, opName, apName)
+	if errors.Is(err, ErrCooldownNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !c.Expired(time.Now()), nil
+}
+
+// GetOffer returns an offer to either of its peers
+func (h *Hub) GetOffer(ctx context.Context, req GetOfferRequest) (Offer, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, req.OfferID)
+	if err != nil {
+		return Offer{}, err
+	}
+
+	if req.PeerName != offer.OfferingPeer && req.PeerName != offer.AnsweringPeer {
+		return Offer{}, ErrPeerNotInOffer
+	}
+
+	return offer, nil
+}
+
+// GetAnswer returns an answer to either of the peers of the answered offer, it outlives the offer
+// so the peers are the ones stored with the answer
+func (h *Hub) GetAnswer(ctx context.Context, req GetAnswerRequest) (Answer, error) {
+	answer, err := h.dealSvc.GetAnswer(ctx, req.AnswerID)
+	if err != nil {
+		return Answer{}, err
+	}
+
+	if req.PeerName != answer.OfferingPeer && req.PeerName != answer.AnsweringPeer {
+		return Answer{}, ErrPeerNotInOffer
+	}
+
+	return answer, nil
+}
+
+// CreateCandidate buffers a candidate for the counterpart of the sending peer and returns the Offer which the candidate relates to
+func (h *Hub) CreateCandidate(ctx context.Context, req CreateCandidateRequest) (Candidate, Offer, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, req.OfferID)
+	if err != nil {
+		return Candidate{}, Offer{}, err
+	}
+
+	var to string
+	switch req.PeerName {
+	case offer.OfferingPeer:
+		to = offer.AnsweringPeer
+	case offer.AnsweringPeer:
+		to = offer.OfferingPeer
+	default:
+		return Candidate{}, Offer{}, ErrPeerNotInOffer
+	}
+
+	c := NewCandidate(offer.ID, req.PeerName, to, req)
+	if err := h.dealSvc.CreateCandidate(ctx, c); err != nil {
+		return Candidate{}, Offer{}, err
+	}
+
+	return c, offer, nil
+}
+
+// PendingCandidates returns and removes candidates buffered for the peer,
+// candidates for the offering peer are held back until the offer is answered
+func (h *Hub) PendingCandidates(ctx context.Context, offerID, peerName string) ([]Candidate, error) {
+	offer, err := h.dealSvc.GetOffer(ctx, offerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if peerName == offer.OfferingPeer && offer.State != OfferStateAnswered {
+		return []Candidate{}, nil
+	}
+
+	return h.dealSvc.PopCandidates(ctx, offerID, peerName)
+}
+
+func (h *Hub) OffersForAnsweringPeer(ctx context.Context, ap AnsweringPeer) ([]Offer, []FailedOffer, error) {
+	ops, err := h.peerSvc.GetOfferingPeersByTarget(ctx, ap.Name)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	offers := []Offer{}
 	fOffers := []FailedOffer{}
+	// matched holds verified target access keys, offering peers often share the key
+	matched := map[string]bool{}
 
 	for _, op := range ops {
-		if !ap.AccessKeyMatches(op.TargetAccessKey) {
-			fo := FailedOffer{
-				OfferingPeer:  op.Name,
-				AnsweringPeer: ap.Name,
-				Error:         err,
+		if !op.Online {
+			continue
+		}
+
+		cooling, err := h.coolingDown(ctx, op.Name, ap.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cooling {
+			continue
+		}
+
+		key, err := h.keySealer.open(op.Name, op.TargetAccessKey)
+		if err != nil {
+			fOffers = append(fOffers, NewFailedOffer(op.Name, ap.Name, ErrInvalidAccessKey))
+			continue
+		}
+		ok, verified := matched[key]
+		if !verified {
+			if len(matched) >= maxKeyVerifications {
+				// offered once the offering peer registers again
+				continue
 			}
-			fOffers = append(fOffers, fo)
+			if ok, err = ap.AccessKeyMatches(h.keyHasher, key); err != nil {
+				return nil, nil, err
+			}
+			matched[key] = ok
+		}
+		if !ok {
+			fOffers = append(fOffers, NewFailedOffer(op.Name, ap.Name, ErrInvalidAccessKey))
 			continue
 		}
 
-		offer := NewOffer(op.Name, op.SDP, ap.Name)
+		// an offer which is still pending is sent again instead of making a new one,
+		// the same offer may also be waiting in the answering peer's mail
+		pending, isPending, err := h.pendingOffer(ctx, op.Name, ap.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isPending {
+			offers = append(offers, pending)
+			continue
+		}
+
+		offer := NewOffer(op.Name, op.SDP, ap.Name, h.offerTTL)
+		if err := h.dealSvc.CreateOffer(ctx, offer); err != nil {
+			return nil, nil, err
+		}
 		offers = append(offers, offer)
 	}
 
 	return offers, fOffers, nil
 }
 
-func (h *Hub) OfferFromOfferingPeer(op OfferingPeer) (offer Offer, failedOffer FailedOffer, isOffer, isFailed bool, err error) {
-	ap, err := h.peerSvc.GetAnsweringPeer(op.TargetName)
+func (h *Hub) pendingOffer(ctx context.Context, opName, apName string) (Offer, bool, error) {
+	offers, err := h.dealSvc.GetOffersByOfferingPeer(ctx, opName)
+	if err != nil {
+		return Offer{}, false, err
+	}
+
+	now := time.Now()
+	for _, offer := range offers {
+		if offer.AnsweringPeer == apName && offer.State == OfferStatePending && !offer.Expired(now) {
+			return offer, true, nil
+		}
+	}
+
+	return Offer{}, false, nil
+}
+
+// AuthorizeAnsweringPeer checks management key of the answering peer, transports use it to authorize peer's subscriptions
+func (h *Hub) AuthorizeAnsweringPeer(ctx context.Context, creds PeerCredentials) (AnsweringPeer, error) {
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, creds.Name)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+
+	ok, err := ap.ManagementKeyMatches(h.keyHasher, creds.ManagementKey)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+	if !ok {
+		return AnsweringPeer{}, ErrInvalidManagementKey
+	}
+
+	return ap, nil
+}
+
+// AuthorizeOfferingPeer checks management key of the offering peer, transports use it to authorize peer's subscriptions
+func (h *Hub) AuthorizeOfferingPeer(ctx context.Context, creds PeerCredentials) (OfferingPeer, error) {
+	op, err := h.peerSvc.GetOfferingPeer(ctx, creds.Name)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+
+	ok, err := op.ManagementKeyMatches(h.keyHasher, creds.ManagementKey)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+	if !ok {
+		return OfferingPeer{}, ErrInvalidManagementKey
+	}
+
+	return op, nil
+}
+
+// GetPendingOffers returns offers waiting for an answer from the answering peer
+func (h *Hub) GetPendingOffers(ctx context.Context, req GetPendingOffersRequest) ([]Offer, error) {
+	ap, err := h.AuthorizeAnsweringPeer(ctx, PeerCredentials(req))
+	if err != nil {
+		return nil, err
+	}
+
+	offers, err := h.dealSvc.GetOffersByAnsweringPeer(ctx, ap.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	pending := []Offer{}
+	for _, offer := range offers {
+		if offer.State == OfferStatePending && !offer.Expired(now) {
+			pending = append(pending, offer)
+		}
+	}
+
+	return pending, nil
+}
+
+func (h *Hub) OfferFromOfferingPeer(ctx context.Context, op OfferingPeer) (offer Offer, failedOffer FailedOffer, isOffer, isFailed bool, err error) {
+	if h.federation != nil && h.federation.Remote(op.TargetName) {
+		return h.remoteOffer(ctx, op)
+	}
+
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, op.TargetName)
 	if op.IgnoreNotFound && errors.Is(err, ErrAnsweringPeerNotFound) {
 		return Offer{}, FailedOffer{}, false, false, nil
 	}
@@ -134,29 +548,409 @@ func (h *Hub) OfferFromOfferingPeer(op OfferingPeer) (offer Offer, failedOffer F
 		return Offer{}, FailedOffer{}, false, false, err
 	}
 
-	if !ap.AccessKeyMatches(op.TargetAccessKey) {
-		fo := FailedOffer{
-			OfferingPeer:  op.Name,
-			AnsweringPeer: ap.Name,
-			Error:         ErrInvalidAccessKey,
-		}
-		return Offer{}, fo, false, true, err
+	key, err := h.keySealer.open(op.Name, op.TargetAccessKey)
+	if err != nil {
+		// sealed with the secret of another hub
+		return Offer{}, NewFailedOffer(op.Name, ap.Name, ErrInvalidAccessKey), false, true, nil
+	}
+	ok, err := ap.AccessKeyMatches(h.keyHasher, key)
+	if err != nil {
+		return Offer{}, FailedOffer{}, false, false, err
+	}
+	if !ok {
+		return Offer{}, NewFailedOffer(op.Name, ap.Name, ErrInvalidAccessKey), false, true, nil
+	}
+
+	cooling, err := h.coolingDown(ctx, op.Name, ap.Name)
+	if err != nil {
+		return Offer{}, FailedOffer{}, false, false, err
+	}
+	if cooling {
+		return Offer{}, NewFailedOffer(op.Name, ap.Name, ErrOfferCooldown), false, true, nil
 	}
 
-	o := NewOffer(op.Name, op.SDP, ap.Name)
-	if err := h.dealSvc.CreateOffer(o); err != nil {
+	o := NewOffer(op.Name, op.SDP, ap.Name, h.offerTTL)
+	if err := h.dealSvc.CreateOffer(ctx, o); err != nil {
 		return Offer{}, FailedOffer{}, false, false, err
 	}
 
 	return o, FailedOffer{}, true, false, nil
 }
 
-func (h *Hub) DeleteAnsweringPeer(_ DeleteAnsweringPeerRequest) error {
-	panic("not implemented") // TODO: Implement
+// DeleteAnsweringPeer deletes answering peer along with offers made to it
+func (h *Hub) DeleteAnsweringPeer(ctx context.Context, req DeleteAnsweringPeerRequest) error {
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	ok, err := ap.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidManagementKey
+	}
+
+	return h.deleteAnsweringPeer(ctx, ap)
 }
 
-func (h *Hub) DeleteOfferingPeer(_ DeleteOfferingPeerRequest) error {
-	panic("not implemented") // TODO: Implement
+// deleteAnsweringPeer deletes the peer if it wasn't changed since it was read, its offers and mail go with it
+func (h *Hub) deleteAnsweringPeer(ctx context.Context, ap AnsweringPeer) error {
+	if err := h.peerSvc.DeleteAnsweringPeer(ctx, ap); err != nil {
+		return err
+	}
+
+	offers, err := h.dealSvc.GetOffersByAnsweringPeer(ctx, ap.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := h.deleteOffers(ctx, offers); err != nil {
+		return err
+	}
+
+	// mail of a deleted peer must not reach a new peer with the same name
+	_, err = h.dealSvc.PopMail(ctx, PeerRoleAnswering, ap.Name)
+	return err
+}
+
+// DeleteOfferingPeer deletes offering peer along with offers it made
+func (h *Hub) DeleteOfferingPeer(ctx context.Context, req DeleteOfferingPeerRequest) error {
+	op, err := h.peerSvc.GetOfferingPeer(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	ok, err := op.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidManagementKey
+	}
+
+	return h.deleteOfferingPeer(ctx, op)
+}
+
+// deleteOfferingPeer deletes the peer if it wasn't changed since it was read, its offers and mail go with it
+func (h *Hub) deleteOfferingPeer(ctx context.Context, op OfferingPeer) error {
+	if err := h.peerSvc.DeleteOfferingPeer(ctx, op); err != nil {
+		return err
+	}
+
+	offers, err := h.dealSvc.GetOffersByOfferingPeer(ctx, op.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := h.deleteOffers(ctx, offers); err != nil {
+		return err
+	}
+
+	// mail of a deleted peer must not reach a new peer with the same name
+	_, err = h.dealSvc.PopMail(ctx, PeerRoleOffering, op.Name)
+	return err
+}
+
+// RotateAnsweringPeerKeys replaces management and/or access keys of the answering peer
+func (h *Hub) RotateAnsweringPeerKeys(ctx context.Context, req RotateAnsweringPeerKeysRequest) (AnsweringPeer, error) {
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, req.Name)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+
+	ok, err := ap.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return AnsweringPeer{}, err
+	}
+	if !ok {
+		return AnsweringPeer{}, ErrInvalidManagementKey
+	}
+
+	if req.NewManagementKey != nil {
+		ap.ManagementKey, err = hashKey(h.keyHasher, *req.NewManagementKey)
+		if err != nil {
+			return AnsweringPeer{}, err
+		}
+	}
+	if len(req.NewAccessKeys) > MaxAccessKeys {
+		return AnsweringPeer{}, ErrTooManyAccessKeys
+	}
+	if req.NewAccessKeys != nil {
+		ap.AccessKeys, err = hashKeys(h.keyHasher, req.NewAccessKeys)
+		if err != nil {
+			return AnsweringPeer{}, err
+		}
+	}
+
+	if err := h.peerSvc.UpdateAnsweringPeer(ctx, ap); err != nil {
+		return AnsweringPeer{}, err
+	}
+
+	ap.Version++
+	return ap, nil
+}
+
+// RotateOfferingPeerKey replaces management key of the offering peer
+func (h *Hub) RotateOfferingPeerKey(ctx context.Context, req RotateOfferingPeerKeyRequest) (OfferingPeer, error) {
+	op, err := h.peerSvc.GetOfferingPeer(ctx, req.Name)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+
+	ok, err := op.ManagementKeyMatches(h.keyHasher, req.ManagementKey)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+	if !ok {
+		return OfferingPeer{}, ErrInvalidManagementKey
+	}
+
+	op.ManagementKey, err = hashKey(h.keyHasher, req.NewManagementKey)
+	if err != nil {
+		return OfferingPeer{}, err
+	}
+
+	if err := h.peerSvc.UpdateOfferingPeer(ctx, op); err != nil {
+		return OfferingPeer{}, err
+	}
+
+	op.Version++
+	return op, nil
+}
+
+// MigrateKeys hashes plaintext keys and seals plaintext target access keys of peers stored before
+// they were protected and returns the number of migrated peers
+func (h *Hub) MigrateKeys(ctx context.Context) (int, error) {
+	aps, err := h.peerSvc.GetAnsweringPeers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, ap := range aps {
+		changed := false
+		if ap.ManagementKey != "" && !h.keyHasher.IsHash(ap.ManagementKey) {
+			if ap.ManagementKey, err = h.keyHasher.Hash(ap.ManagementKey); err != nil {
+				return migrated, err
+			}
+			changed = true
+		}
+		for i, key := range ap.AccessKeys {
+			if key != "" && !h.keyHasher.IsHash(key) {
+				if ap.AccessKeys[i], err = h.keyHasher.Hash(key); err != nil {
+					return migrated, err
+				}
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := h.peerSvc.UpdateAnsweringPeer(ctx, ap); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	ops, err := h.peerSvc.GetOfferingPeers(ctx)
+	if err != nil {
+		return migrated, err
+	}
+
+	for _, op := range ops {
+		changed := false
+		if op.ManagementKey != "" && !h.keyHasher.IsHash(op.ManagementKey) {
+			if op.ManagementKey, err = h.keyHasher.Hash(op.ManagementKey); err != nil {
+				return migrated, err
+			}
+			changed = true
+		}
+		if op.TargetAccessKey != "" && !h.keySealer.isSealed(op.TargetAccessKey) {
+			if op.TargetAccessKey, err = h.keySealer.seal(op.Name, op.TargetAccessKey); err != nil {
+				return migrated, err
+			}
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := h.peerSvc.UpdateOfferingPeer(ctx, op); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// MarkPeersOffline marks all stored peers offline, none of them is connected after the hub restarts
+// with a persistent store, it returns the number of peers that were online
+func (h *Hub) MarkPeersOffline(ctx context.Context) (int, error) {
+	aps, err := h.peerSvc.GetAnsweringPeers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, ap := range aps {
+		if !ap.Online {
+			continue
+		}
+		ap.Online = false
+		if err := h.peerSvc.UpdateAnsweringPeer(ctx, ap); err != nil {
+			return marked, err
+		}
+		marked++
+	}
+
+	ops, err := h.peerSvc.GetOfferingPeers(ctx)
+	if err != nil {
+		return marked, err
+	}
+
+	for _, op := range ops {
+		if !op.Online {
+			continue
+		}
+		op.Online = false
+		if err := h.peerSvc.UpdateOfferingPeer(ctx, op); err != nil {
+			return marked, err
+		}
+		marked++
+	}
+
+	return marked, nil
+}
+
+// DisconnectAnsweringPeer deletes answering peer or marks it offline when its connection is gone,
+// offers made to it are deleted and the pending ones are returned, version is the version the connection
+// stored the peer at, a peer changed since then is left alone as it was likely registered again over
+// another connection or on another hub sharing the store
+func (h *Hub) DisconnectAnsweringPeer(ctx context.Context, name string, version int, del bool) ([]Offer, error) {
+	ap, err := h.peerSvc.GetAnsweringPeer(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if ap.Version != version {
+		return []Offer{}, nil
+	}
+
+	if del {
+		err = h.peerSvc.DeleteAnsweringPeer(ctx, ap)
+	} else {
+		ap.Online = false
+		err = h.peerSvc.UpdateAnsweringPeer(ctx, ap)
+	}
+	if errors.Is(err, ErrAnsweringPeerConflict) {
+		return []Offer{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	offers, err := h.dealSvc.GetOffersByAnsweringPeer(ctx, ap.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.deleteOffers(ctx, offers); err != nil {
+		return nil, err
+	}
+
+	pending := []Offer{}
+	for _, offer := range offers {
+		if offer.State == OfferStatePending {
+			pending = append(pending, offer)
+		}
+	}
+
+	return pending, nil
+}
+
+// DisconnectOfferingPeer deletes offering peer or marks it offline when its connection is gone, offers it made are deleted,
+// version is the version the connection stored the peer at, a peer changed since then is left alone as it was likely
+// registered again over another connection or on another hub sharing the store
+func (h *Hub) DisconnectOfferingPeer(ctx context.Context, name string, version int, del bool) error {
+	op, err := h.peerSvc.GetOfferingPeer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if op.Version != version {
+		return nil
+	}
+
+	if del {
+		err = h.peerSvc.DeleteOfferingPeer(ctx, op)
+	} else {
+		op.Online = false
+		err = h.peerSvc.UpdateOfferingPeer(ctx, op)
+	}
+	if errors.Is(err, ErrOfferingPeerConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	offers, err := h.dealSvc.GetOffersByOfferingPeer(ctx, op.Name)
+	if err != nil {
+		return err
+	}
+
+	return h.deleteOffers(ctx, offers)
+}
+
+// DeleteExpired deletes expired offers, answers, cooldowns and mail, expired offers which were still pending are returned
+func (h *Hub) DeleteExpired(ctx context.Context, now time.Time) ([]Offer, error) {
+	offers, err := h.dealSvc.DeleteExpiredOffers(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := h.dealSvc.DeleteExpiredAnswers(ctx, now); err != nil {
+		return nil, err
+	}
+
+	if _, err := h.dealSvc.DeleteExpiredCooldowns(ctx, now); err != nil {
+		return nil, err
+	}
+
+	if _, err := h.dealSvc.DeleteExpiredMail(ctx, now); err != nil {
+		return nil, err
+	}
+
+	pending := []Offer{}
+	for _, offer := range offers {
+		if offer.State == OfferStatePending {
+			pending = append(pending, offer)
+		}
+	}
+
+	return pending, nil
+}
+
+// deleteOffers deletes offers and their answers
+func (h *Hub) deleteOffers(ctx context.Context, offers []Offer) error {
+	for _, offer := range offers {
+		answers, err := h.dealSvc.GetAnswersByOffer(ctx, offer.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, answer := range answers {
+			if err := h.dealSvc.DeleteAnswer(ctx, answer.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := h.dealSvc.DeleteOffer(ctx, offer.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type CreateAnsweringPeerRequest struct {
@@ -166,8 +960,37 @@ type CreateAnsweringPeerRequest struct {
 }
 
 type CreateAnswerRequest struct {
-	OfferID string `json:"offerID"`
-	SDP     string `json:"sdp"`
+	OfferID  string `json:"offerID"`
+	PeerName string `json:"peername"`
+	SDP      string `json:"sdp"`
+}
+
+type RejectOfferRequest struct {
+	OfferID  string       `json:"offerID"`
+	PeerName string       `json:"peername"`
+	Reason   RejectReason `json:"reason"`
+	Message  string       `json:"message"`
+	Block    bool         `json:"block"`
+}
+
+type GetOfferRequest struct {
+	OfferID  string `json:"offerID"`
+	PeerName string `json:"peername"`
+}
+
+type GetAnswerRequest struct {
+	AnswerID string `json:"answerID"`
+	PeerName string `json:"peername"`
+}
+
+type CreateCandidateRequest struct {
+	OfferID          string  `json:"offerID"`
+	PeerName         string  `json:"peername"`
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpmid"`
+	SDPMLineIndex    *uint16 `json:"sdpmlineindex"`
+	UsernameFragment *string `json:"usernamefragment"`
+	EndOfCandidates  bool    `json:"endofcandidates"`
 }
 
 type DealForAnsweringPeerRequest struct {
@@ -177,8 +1000,34 @@ type DealForAnsweringPeerRequest struct {
 	SDP               string `json:"sdp"`
 }
 
+type PeerCredentials struct {
+	Name          string `json:"name"`
+	ManagementKey string `json:"managementkey"`
+}
+
+type GetPendingOffersRequest struct {
+	Name          string `json:"name"`
+	ManagementKey string `json:"managementkey"`
+}
+
 type DeleteAnsweringPeerRequest struct {
-	PeerID string
+	Name          string `json:"name"`
+	ManagementKey string `json:"managementkey"`
+}
+
+type RotateAnsweringPeerKeysRequest struct {
+	Name          string `json:"name"`
+	ManagementKey string `json:"managementkey"`
+	// NewManagementKey replaces the management key if set, empty value unprotects the peer
+	NewManagementKey *string `json:"newmanagementkey"`
+	// NewAccessKeys replace the access keys if set, empty list makes the peer public
+	NewAccessKeys []string `json:"newaccesskeys"`
+}
+
+type RotateOfferingPeerKeyRequest struct {
+	Name             string `json:"name"`
+	ManagementKey    string `json:"managementkey"`
+	NewManagementKey string `json:"newmanagementkey"`
 }
 
 type CreateOfferingPeerRequest struct {
@@ -191,5 +1040,6 @@ type CreateOfferingPeerRequest struct {
 }
 
 type DeleteOfferingPeerRequest struct {
-	PeerID string
+	Name          string `json:"name"`
+	ManagementKey string `json:"managementkey"`
 }
@@ -0,0 +1,364 @@
+package peerhub_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/peer"
+	sig "github.com/H3Cki/peerhub/internal/signal"
+)
+
+// racingPeers runs the race once, right after the hub reads a peer and before it writes it back
+type racingPeers struct {
+	peerhub.PeerServiceV2
+	race func()
+}
+
+func (r *racingPeers) run() {
+	if race := r.race; race != nil {
+		r.race = nil
+		race()
+	}
+}
+
+func (r *racingPeers) GetAnsweringPeer(ctx context.Context, name string) (peerhub.AnsweringPeer, error) {
+	ap, err := r.PeerServiceV2.GetAnsweringPeer(ctx, name)
+	r.run()
+	return ap, err
+}
+
+func (r *racingPeers) GetOfferingPeer(ctx context.Context, name string) (peerhub.OfferingPeer, error) {
+	op, err := r.PeerServiceV2.GetOfferingPeer(ctx, name)
+	r.run()
+	return op, err
+}
+
+func newRacingHub(t *testing.T) (*peerhub.Hub, *racingPeers) {
+	t.Helper()
+	peers := &racingPeers{PeerServiceV2: peerhub.AdaptPeerService(peer.NewInMemoryService())}
+	h := peerhub.NewHub(peerhub.HubConfig{
+		PeerServiceV2: peers,
+		SignalService: sig.NewInMemoryService(),
+	})
+	return h, peers
+}
+
+func TestConcurrentCreatesConflict(t *testing.T) {
+	h, peers := newRacingHub(t)
+	ctx := context.Background()
+
+	peers.race = func() {
+		if err := peers.CreateAnsweringPeer(ctx, peerhub.AnsweringPeer{Name: "ap", Online: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	if !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("create racing another create returned %v, want conflict", err)
+	}
+}
+
+func TestConcurrentUpdatesConflict(t *testing.T) {
+	h, peers := newRacingHub(t)
+	ctx := context.Background()
+
+	if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	peers.race = func() {
+		if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+	if !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("update racing another update returned %v, want conflict", err)
+	}
+
+	ap, _ := peers.GetAnsweringPeer(ctx, "ap")
+	if ap.Version != 2 {
+		t.Errorf("peer is at version %d, want 2", ap.Version)
+	}
+}
+
+func TestDeleteOfChangedPeerConflicts(t *testing.T) {
+	h, peers := newRacingHub(t)
+	ctx := context.Background()
+
+	if _, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	peers.race = func() {
+		op, _ := peers.GetOfferingPeer(ctx, "op")
+		op.SDP = "changed"
+		if err := peers.UpdateOfferingPeer(ctx, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := h.DeleteOfferingPeer(ctx, peerhub.DeleteOfferingPeerRequest{Name: "op"})
+	if !errors.Is(err, peerhub.ErrOfferingPeerConflict) {
+		t.Errorf("delete of a changed peer returned %v, want conflict", err)
+	}
+	if _, err := peers.GetOfferingPeer(ctx, "op"); err != nil {
+		t.Errorf("changed peer was deleted, err %v", err)
+	}
+}
+
+func TestDisconnectLeavesPeerRegisteredAgain(t *testing.T) {
+	for _, del := range []bool{true, false} {
+		h, peers := newRacingHub(t)
+		ctx := context.Background()
+
+		ap, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the peer registers over a new connection while the old one is cleaned up
+		peers.race = func() {
+			if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := h.DisconnectAnsweringPeer(ctx, "ap", ap.Version, del); err != nil {
+			t.Fatal(err)
+		}
+
+		ap, err = peers.GetAnsweringPeer(ctx, "ap")
+		if err != nil || !ap.Online {
+			t.Errorf("disconnect with delete %v changed the peer registered again: %+v, err %v", del, ap, err)
+		}
+	}
+}
+
+func TestDisconnectLeavesPeerRegisteredOnAnotherHub(t *testing.T) {
+	for _, del := range []bool{true, false} {
+		ctx := context.Background()
+		peers, signals := peer.NewInMemoryService(), sig.NewInMemoryService()
+		h := peerhub.NewHub(peerhub.HubConfig{PeerService: peers, SignalService: signals})
+		other := peerhub.NewHub(peerhub.HubConfig{PeerService: peers, SignalService: signals})
+
+		ap, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		op, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the connection dropped and the peers registered on another hub within the grace period
+		if _, err := other.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := h.DisconnectAnsweringPeer(ctx, "ap", ap.Version, del); err != nil {
+			t.Fatal(err)
+		}
+		if err := h.DisconnectOfferingPeer(ctx, "op", op.Version, del); err != nil {
+			t.Fatal(err)
+		}
+
+		if ap, err := peers.GetAnsweringPeer("ap"); err != nil || !ap.Online {
+			t.Errorf("disconnect with delete %v changed the answering peer registered again: %+v, err %v", del, ap, err)
+		}
+		if op, err := peers.GetOfferingPeer("op"); err != nil || !op.Online {
+			t.Errorf("disconnect with delete %v changed the offering peer registered again: %+v, err %v", del, op, err)
+		}
+	}
+}
+
+func newTestHub(t *testing.T) (*peerhub.Hub, *sig.InMemoryService) {
+	t.Helper()
+	signals := sig.NewInMemoryService()
+	h := peerhub.NewHub(peerhub.HubConfig{
+		PeerService:   peer.NewInMemoryService(),
+		SignalService: signals,
+	})
+	return h, signals
+}
+
+// answeredOffer registers ap and op targeting it and answers the offer op makes
+func answeredOffer(t *testing.T, h *peerhub.Hub) (peerhub.Offer, peerhub.Answer) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	op, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", TargetName: "ap", SDP: "offer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, _, isOffer, _, err := h.OfferFromOfferingPeer(ctx, op)
+	if err != nil || !isOffer {
+		t.Fatalf("no offer was made, err %v", err)
+	}
+	answer, _, err := h.CreateAnswer(ctx, peerhub.CreateAnswerRequest{OfferID: offer.ID, PeerName: "ap", SDP: "answer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return offer, answer
+}
+
+func TestDeletePeerDeletesItsOffersAndAnswers(t *testing.T) {
+	tests := []struct {
+		name string
+		del  func(*peerhub.Hub) error
+	}{
+		{name: "answering", del: func(h *peerhub.Hub) error {
+			return h.DeleteAnsweringPeer(context.Background(), peerhub.DeleteAnsweringPeerRequest{Name: "ap"})
+		}},
+		{name: "offering", del: func(h *peerhub.Hub) error {
+			return h.DeleteOfferingPeer(context.Background(), peerhub.DeleteOfferingPeerRequest{Name: "op"})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, signals := newTestHub(t)
+			offer, answer := answeredOffer(t, h)
+
+			if err := tt.del(h); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := signals.GetOffer(offer.ID); !errors.Is(err, peerhub.ErrOfferNotFound) {
+				t.Errorf("offer of the deleted peer returned %v, want not found", err)
+			}
+			if _, err := signals.GetAnswer(answer.ID); !errors.Is(err, peerhub.ErrAnswerNotFound) {
+				t.Errorf("answer of the deleted peer returned %v, want not found", err)
+			}
+		})
+	}
+}
+
+func TestDisconnectDeletesOffers(t *testing.T) {
+	for _, del := range []bool{true, false} {
+		h, signals := newTestHub(t)
+		ctx := context.Background()
+
+		answered, _ := answeredOffer(t, h)
+		op, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op2", TargetName: "ap"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending, _, _, _, err := h.OfferFromOfferingPeer(ctx, op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ap, err := h.AuthorizeAnsweringPeer(ctx, peerhub.PeerCredentials{Name: "ap"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offers, err := h.DisconnectAnsweringPeer(ctx, "ap", ap.Version, del)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only offering peers still waiting for an answer are notified
+		if len(offers) != 1 || offers[0].ID != pending.ID {
+			t.Errorf("disconnect with delete %v returned %+v, want the pending offer", del, offers)
+		}
+		for _, id := range []string{answered.ID, pending.ID} {
+			if _, err := signals.GetOffer(id); !errors.Is(err, peerhub.ErrOfferNotFound) {
+				t.Errorf("disconnect with delete %v left offer %s, err %v", del, id, err)
+			}
+		}
+
+		ap, err = h.AuthorizeAnsweringPeer(ctx, peerhub.PeerCredentials{Name: "ap"})
+		if del && !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+			t.Errorf("disconnect with delete left the peer, err %v", err)
+		}
+		if !del && (err != nil || ap.Online) {
+			t.Errorf("disconnect without delete left the peer %+v, err %v", ap, err)
+		}
+	}
+}
+
+func TestManagementKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		key    string
+		want   error
+	}{
+		{name: "matching key", stored: "mk", key: "mk"},
+		{name: "wrong key", stored: "mk", key: "other", want: peerhub.ErrInvalidManagementKey},
+		{name: "empty key", stored: "mk", want: peerhub.ErrInvalidManagementKey},
+		{name: "unprotected peer", key: "anything"},
+		{name: "unprotected peer without key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestHub(t)
+			ctx := context.Background()
+
+			if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap", ManagementKey: tt.stored}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", ManagementKey: tt.stored}); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := h.AuthorizeAnsweringPeer(ctx, peerhub.PeerCredentials{Name: "ap", ManagementKey: tt.key}); !errors.Is(err, tt.want) {
+				t.Errorf("answering peer authorized with %v, want %v", err, tt.want)
+			}
+			if _, err := h.AuthorizeOfferingPeer(ctx, peerhub.PeerCredentials{Name: "op", ManagementKey: tt.key}); !errors.Is(err, tt.want) {
+				t.Errorf("offering peer authorized with %v, want %v", err, tt.want)
+			}
+
+			err := h.DeleteAnsweringPeer(ctx, peerhub.DeleteAnsweringPeerRequest{Name: "ap", ManagementKey: tt.key})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("delete returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateManagementKey(t *testing.T) {
+	h, _ := newTestHub(t)
+	ctx := context.Background()
+
+	if _, err := h.CreateAnsweringPeer(ctx, peerhub.CreateAnsweringPeerRequest{Name: "ap", ManagementKey: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.CreateOfferingPeer(ctx, peerhub.CreateOfferingPeerRequest{Name: "op", ManagementKey: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := "new"
+	_, err := h.RotateAnsweringPeerKeys(ctx, peerhub.RotateAnsweringPeerKeysRequest{Name: "ap", ManagementKey: "wrong", NewManagementKey: &newKey})
+	if !errors.Is(err, peerhub.ErrInvalidManagementKey) {
+		t.Errorf("rotation with a wrong key returned %v", err)
+	}
+	_, err = h.RotateOfferingPeerKey(ctx, peerhub.RotateOfferingPeerKeyRequest{Name: "op", ManagementKey: "wrong", NewManagementKey: newKey})
+	if !errors.Is(err, peerhub.ErrInvalidManagementKey) {
+		t.Errorf("rotation with a wrong key returned %v", err)
+	}
+
+	if _, err := h.RotateAnsweringPeerKeys(ctx, peerhub.RotateAnsweringPeerKeysRequest{Name: "ap", ManagementKey: "old", NewManagementKey: &newKey}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.RotateOfferingPeerKey(ctx, peerhub.RotateOfferingPeerKeyRequest{Name: "op", ManagementKey: "old", NewManagementKey: newKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]error{"old": peerhub.ErrInvalidManagementKey, "new": nil} {
+		if _, err := h.AuthorizeAnsweringPeer(ctx, peerhub.PeerCredentials{Name: "ap", ManagementKey: key}); !errors.Is(err, want) {
+			t.Errorf("answering peer authorized with %s key returned %v, want %v", key, err, want)
+		}
+		if _, err := h.AuthorizeOfferingPeer(ctx, peerhub.PeerCredentials{Name: "op", ManagementKey: key}); !errors.Is(err, want) {
+			t.Errorf("offering peer authorized with %s key returned %v, want %v", key, err, want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/wal"
+)
+
+const (
+	opPutAnsweringPeer    = "put_answering_peer"
+	opDeleteAnsweringPeer = "delete_answering_peer"
+	opPutOfferingPeer     = "put_offering_peer"
+	opDeleteOfferingPeer  = "delete_offering_peer"
+)
+
+// FileService keeps peers in memory and persists every change to a write-ahead log in the data directory
+type FileService struct {
+	// mu keeps the order of logged changes the same as the order they were applied in
+	mu  sync.Mutex
+	mem *InMemoryService
+	log *wal.Log
+}
+
+type fileSnapshot struct {
+	AnsweringPeers []peerhub.AnsweringPeer `json:"answeringpeers"`
+	OfferingPeers  []peerhub.OfferingPeer  `json:"offeringpeers"`
+}
+
+// NewFileService restores peers stored in dir
+func NewFileService(dir string) (*FileService, error) {
+	log, err := wal.Open(dir, "peers", wal.DefaultSnapshotEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileService{
+		mu:  sync.Mutex{},
+		mem: NewInMemoryService(),
+		log: log,
+	}
+
+	if err := log.Replay(s.restore, s.apply); err != nil {
+		log.Close()
+		return nil, fmt.Errorf("error replaying peers log: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *FileService) restore(state json.RawMessage) error {
+	snap := fileSnapshot{}
+	if err := json.Unmarshal(state, &snap); err != nil {
+		return err
+	}
+	for _, ap := range snap.AnsweringPeers {
+		s.mem.aps[ap.Name] = ap
+	}
+	for _, op := range snap.OfferingPeers {
+		s.mem.ops[op.Name] = op
+	}
+	return nil
+}
+
+func (s *FileService) apply(rec wal.Record) error {
+	switch rec.Op {
+	case opPutAnsweringPeer:
+		ap := peerhub.AnsweringPeer{}
+		if err := json.Unmarshal(rec.Data, &ap); err != nil {
+			return err
+		}
+		s.mem.putAnsweringPeer(ap)
+		return nil
+	case opDeleteAnsweringPeer:
+		name := ""
+		if err := json.Unmarshal(rec.Data, &name); err != nil {
+			return err
+		}
+		s.mem.removeAnsweringPeer(name)
+		return nil
+	case opPutOfferingPeer:
+		op := peerhub.OfferingPeer{}
+		if err := json.Unmarshal(rec.Data, &op); err != nil {
+			return err
+		}
+		s.mem.putOfferingPeer(op)
+		return nil
+	case opDeleteOfferingPeer:
+		name := ""
+		if err := json.Unmarshal(rec.Data, &name); err != nil {
+			return err
+		}
+		s.mem.removeOfferingPeer(name)
+		return nil
+	}
+
+	return fmt.Errorf("unknown operation %q", rec.Op)
+}
+
+// write logs the change and applies it in memory once it's synced to disk, check rejects changes the store
+// wouldn't make and must not change anything itself, the log is compacted once it grows large enough
+func (s *FileService) write(op string, data any, check func() error, apply func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := check(); err != nil {
+		return err
+	}
+
+	compact, err := s.log.Append(op, data)
+	if err != nil {
+		return fmt.Errorf("error logging %s: %w", op, err)
+	}
+	apply()
+	if !compact {
+		return nil
+	}
+
+	aps, _ := s.mem.GetAnsweringPeers()
+	ops, _ := s.mem.GetOfferingPeers()
+	if err := s.log.Snapshot(fileSnapshot{AnsweringPeers: aps, OfferingPeers: ops}); err != nil {
+		// the change is already logged, the snapshot is retried with the next one
+		fmt.Println(fmt.Errorf("error snapshotting peers: %w", err))
+	}
+
+	return nil
+}
+
+func noCheck() error { return nil }
+
+func (s *FileService) Close() error {
+	return s.log.Close()
+}
+
+func (s *FileService) GetAnsweringPeers() ([]peerhub.AnsweringPeer, error) {
+	return s.mem.GetAnsweringPeers()
+}
+
+// CreateAnsweringPeer creates the peer, the peer is logged the way it's stored, at its new version
+func (s *FileService) CreateAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	stored := ap
+	stored.Version = 1
+	return s.write(opPutAnsweringPeer, stored, func() error {
+		if _, err := s.mem.GetAnsweringPeer(ap.Name); err == nil {
+			return peerhub.ErrAnsweringPeerAlreadyExists
+		}
+		return nil
+	}, func() {
+		s.mem.putAnsweringPeer(stored)
+	})
+}
+
+func (s *FileService) UpdateAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	stored := ap
+	stored.Version++
+	return s.write(opPutAnsweringPeer, stored, func() error {
+		old, err := s.mem.GetAnsweringPeer(ap.Name)
+		if err != nil {
+			return err
+		}
+		if old.Version != ap.Version {
+			return peerhub.ErrAnsweringPeerConflict
+		}
+		return nil
+	}, func() {
+		s.mem.putAnsweringPeer(stored)
+	})
+}
+
+func (s *FileService) GetAnsweringPeer(name string) (peerhub.AnsweringPeer, error) {
+	return s.mem.GetAnsweringPeer(name)
+}
+
+// DeleteAnsweringPeer deletes the peer if it's still at the version of ap, only the name is logged
+func (s *FileService) DeleteAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	return s.write(opDeleteAnsweringPeer, ap.Name, func() error {
+		old, err := s.mem.GetAnsweringPeer(ap.Name)
+		if err != nil {
+			return err
+		}
+		if old.Version != ap.Version {
+			return peerhub.ErrAnsweringPeerConflict
+		}
+		return nil
+	}, func() {
+		s.mem.removeAnsweringPeer(ap.Name)
+	})
+}
+
+// CreateOfferingPeer creates the peer, the peer is logged the way it's stored, at its new version
+func (s *FileService) CreateOfferingPeer(op peerhub.OfferingPeer) error {
+	stored := op
+	stored.Version = 1
+	return s.write(opPutOfferingPeer, stored, func() error {
+		if _, err := s.mem.GetOfferingPeer(op.Name); err == nil {
+			return peerhub.ErrOfferingPeerAlreadyExists
+		}
+		return nil
+	}, func() {
+		s.mem.putOfferingPeer(stored)
+	})
+}
+
+func (s *FileService) UpdateOfferingPeer(op peerhub.OfferingPeer) error {
+	stored := op
+	stored.Version++
+	return s.write(opPutOfferingPeer, stored, func() error {
+		old, err := s.mem.GetOfferingPeer(op.Name)
+		if err != nil {
+			return err
+		}
+		if old.Version != op.Version {
+			return peerhub.ErrOfferingPeerConflict
+		}
+		return nil
+	}, func() {
+		s.mem.putOfferingPeer(stored)
+	})
+}
+
+func (s *FileService) GetOfferingPeer(name string) (peerhub.OfferingPeer, error) {
+	return s.mem.GetOfferingPeer(name)
+}
+
+func (s *FileService) GetOfferingPeers() ([]peerhub.OfferingPeer, error) {
+	return s.mem.GetOfferingPeers()
+}
+
+func (s *FileService) GetOfferingPeersByTarget(name string) ([]peerhub.OfferingPeer, error) {
+	return s.mem.GetOfferingPeersByTarget(name)
+}
+
+// DeleteOfferingPeer deletes the peer if it's still at the version of op, only the name is logged
+func (s *FileService) DeleteOfferingPeer(op peerhub.OfferingPeer) error {
+	return s.write(opDeleteOfferingPeer, op.Name, func() error {
+		old, err := s.mem.GetOfferingPeer(op.Name)
+		if err != nil {
+			return err
+		}
+		if old.Version != op.Version {
+			return peerhub.ErrOfferingPeerConflict
+		}
+		return nil
+	}, func() {
+		s.mem.removeOfferingPeer(op.Name)
+	})
+}
@@ -0,0 +1,108 @@
+package peer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestFileServiceRestoresPeers(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	ap, _ := s.GetAnsweringPeer("ap")
+	ap.Online = true
+	if err := s.UpdateAnsweringPeer(ap); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateOfferingPeer(peerhub.OfferingPeer{Name: "op", TargetName: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateOfferingPeer(peerhub.OfferingPeer{Name: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+	gone, _ := s.GetOfferingPeer("gone")
+	if err := s.DeleteOfferingPeer(gone); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ap, err = s.GetAnsweringPeer("ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ap.Online || ap.Version != 2 {
+		t.Errorf("restored %+v, want online peer at version 2", ap)
+	}
+	ops, _ := s.GetOfferingPeersByTarget("ap")
+	if len(ops) != 1 || ops[0].Name != "op" {
+		t.Errorf("restored offering peers %+v, want op", ops)
+	}
+	if _, err := s.GetOfferingPeer("gone"); !errors.Is(err, peerhub.ErrOfferingPeerNotFound) {
+		t.Errorf("deleted peer was restored, err %v", err)
+	}
+}
+
+func TestFileServiceRejectsStaleUpdates(t *testing.T) {
+	s, err := NewFileService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); !errors.Is(err, peerhub.ErrAnsweringPeerAlreadyExists) {
+		t.Errorf("created the peer twice, err %v", err)
+	}
+
+	stale, _ := s.GetAnsweringPeer("ap")
+	if err := s.UpdateAnsweringPeer(stale); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateAnsweringPeer(stale); !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("stale update returned %v, want conflict", err)
+	}
+	if err := s.UpdateAnsweringPeer(peerhub.AnsweringPeer{Name: "missing"}); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("update of a missing peer returned %v, want not found", err)
+	}
+
+	if err := s.DeleteAnsweringPeer(stale); !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("stale delete returned %v, want conflict", err)
+	}
+	if _, err := s.GetAnsweringPeer("ap"); err != nil {
+		t.Errorf("stale delete removed the peer, err %v", err)
+	}
+	if err := s.DeleteAnsweringPeer(peerhub.AnsweringPeer{Name: "missing"}); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("delete of a missing peer returned %v, want not found", err)
+	}
+}
+
+func TestFileServiceDoesNotApplyUnloggedChanges(t *testing.T) {
+	s, err := NewFileService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); err == nil {
+		t.Fatal("created a peer without a log")
+	}
+	if _, err := s.GetAnsweringPeer("ap"); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("peer that wasn't logged is stored, err %v", err)
+	}
+}
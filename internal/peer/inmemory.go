@@ -30,12 +30,34 @@ func (s *InMemoryService) GetAnsweringPeers() ([]peerhub.AnsweringPeer, error) {
 func (s *InMemoryService) CreateAnsweringPeer(ap peerhub.AnsweringPeer) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if _, ok := s.aps[ap.Name]; ok {
+		return peerhub.ErrAnsweringPeerAlreadyExists
+	}
+	ap.Version = 1
 	s.aps[ap.Name] = ap
 	return nil
 }
 
 func (s *InMemoryService) UpdateAnsweringPeer(ap peerhub.AnsweringPeer) error {
-	return s.CreateAnsweringPeer(ap)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.aps[ap.Name]
+	if !ok {
+		return peerhub.ErrAnsweringPeerNotFound
+	}
+	if old.Version != ap.Version {
+		return peerhub.ErrAnsweringPeerConflict
+	}
+	ap.Version++
+	s.aps[ap.Name] = ap
+	return nil
+}
+
+// putAnsweringPeer stores the peer as it is, regardless of the stored one
+func (s *InMemoryService) putAnsweringPeer(ap peerhub.AnsweringPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aps[ap.Name] = ap
 }
 
 func (s *InMemoryService) GetAnsweringPeer(name string) (peerhub.AnsweringPeer, error) {
@@ -48,22 +70,58 @@ func (s *InMemoryService) GetAnsweringPeer(name string) (peerhub.AnsweringPeer,
 	return ap, nil
 }
 
-func (s *InMemoryService) DeleteAnsweringPeer(name string) error {
+func (s *InMemoryService) DeleteAnsweringPeer(ap peerhub.AnsweringPeer) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.aps, name)
+	old, ok := s.aps[ap.Name]
+	if !ok {
+		return peerhub.ErrAnsweringPeerNotFound
+	}
+	if old.Version != ap.Version {
+		return peerhub.ErrAnsweringPeerConflict
+	}
+	delete(s.aps, ap.Name)
 	return nil
 }
 
+// removeAnsweringPeer removes the peer regardless of its version
+func (s *InMemoryService) removeAnsweringPeer(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.aps, name)
+}
+
 func (s *InMemoryService) CreateOfferingPeer(op peerhub.OfferingPeer) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if _, ok := s.ops[op.Name]; ok {
+		return peerhub.ErrOfferingPeerAlreadyExists
+	}
+	op.Version = 1
 	s.ops[op.Name] = op
 	return nil
 }
 
 func (s *InMemoryService) UpdateOfferingPeer(op peerhub.OfferingPeer) error {
-	return s.CreateOfferingPeer(op)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.ops[op.Name]
+	if !ok {
+		return peerhub.ErrOfferingPeerNotFound
+	}
+	if old.Version != op.Version {
+		return peerhub.ErrOfferingPeerConflict
+	}
+	op.Version++
+	s.ops[op.Name] = op
+	return nil
+}
+
+// putOfferingPeer stores the peer as it is, regardless of the stored one
+func (s *InMemoryService) putOfferingPeer(op peerhub.OfferingPeer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops[op.Name] = op
 }
 
 func (s *InMemoryService) GetOfferingPeer(name string) (peerhub.OfferingPeer, error) {
@@ -76,6 +134,12 @@ func (s *InMemoryService) GetOfferingPeer(name string) (peerhub.OfferingPeer, er
 	return op, nil
 }
 
+func (s *InMemoryService) GetOfferingPeers() ([]peerhub.OfferingPeer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Values(s.ops), nil
+}
+
 func (s *InMemoryService) GetOfferingPeersByTarget(name string) ([]peerhub.OfferingPeer, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -88,9 +152,23 @@ func (s *InMemoryService) GetOfferingPeersByTarget(name string) ([]peerhub.Offer
 	return ops, nil
 }
 
-func (s *InMemoryService) DeleteOfferingPeer(name string) error {
+func (s *InMemoryService) DeleteOfferingPeer(op peerhub.OfferingPeer) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.ops, name)
+	old, ok := s.ops[op.Name]
+	if !ok {
+		return peerhub.ErrOfferingPeerNotFound
+	}
+	if old.Version != op.Version {
+		return peerhub.ErrOfferingPeerConflict
+	}
+	delete(s.ops, op.Name)
 	return nil
 }
+
+// removeOfferingPeer removes the peer regardless of its version
+func (s *InMemoryService) removeOfferingPeer(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ops, name)
+}
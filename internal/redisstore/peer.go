@@ -0,0 +1,292 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/resp"
+)
+
+// DefaultPrefix is prepended to all keys
+const DefaultPrefix = "peerhub:"
+
+// PeerService stores peers in redis hashes, offering peers are also indexed by their target
+type PeerService struct {
+	c      *resp.Client
+	prefix string
+}
+
+// updateScript replaces the peer in KEYS[1] named ARGV[1] with ARGV[3] if the stored peer is at version ARGV[2]
+// and moves it from the target index KEYS[2] to KEYS[3] if they are given, it returns -1 if there is no stored peer
+// and 0 if it's at another version, peers stored before versioning are at version 0
+const updateScript = `
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if not cur then
+	return -1
+end
+if (cjson.decode(cur)['Version'] or 0) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+if KEYS[3] then
+	redis.call('SREM', KEYS[2], ARGV[1])
+	redis.call('SADD', KEYS[3], ARGV[1])
+end
+return 1
+`
+
+// deleteScript removes the peer in KEYS[1] named ARGV[1] if the stored peer is at version ARGV[2] and removes
+// it from the target index KEYS[2] if it's given, it returns -1 if there is no stored peer and 0 if it's at another version
+const deleteScript = `
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if not cur then
+	return -1
+end
+if (cjson.decode(cur)['Version'] or 0) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+if KEYS[2] then
+	redis.call('SREM', KEYS[2], ARGV[1])
+end
+return 1
+`
+
+// createScript stores ARGV[2] in KEYS[1] under ARGV[1] and adds it to the target index KEYS[2]
+// unless the name is taken, in which case it returns 0
+const createScript = `
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+return 1
+`
+
+func NewPeerService(c *resp.Client, prefix string) *PeerService {
+	return &PeerService{c: c, prefix: prefix}
+}
+
+func (s *PeerService) apKey() string {
+	return s.prefix + "answering_peers"
+}
+
+func (s *PeerService) opKey() string {
+	return s.prefix + "offering_peers"
+}
+
+func (s *PeerService) targetKey(target string) string {
+	return s.prefix + "offering_peers:target:" + target
+}
+
+func (s *PeerService) GetAnsweringPeers() ([]peerhub.AnsweringPeer, error) {
+	reply, err := s.c.Do("HVALS", s.apKey())
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.AnsweringPeer](resp.Strings(reply))
+}
+
+func (s *PeerService) CreateAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	ap.Version = 1
+	data, err := json.Marshal(ap)
+	if err != nil {
+		return err
+	}
+	reply, err := s.c.Do("HSETNX", s.apKey(), ap.Name, data)
+	if err != nil {
+		return err
+	}
+	if resp.Int(reply) == 0 {
+		return peerhub.ErrAnsweringPeerAlreadyExists
+	}
+	return nil
+}
+
+// UpdateAnsweringPeer replaces the answering peer if it's still at the version of ap, the version is checked
+// by a script so the check and the write are atomic even across hubs
+func (s *PeerService) UpdateAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	version := ap.Version
+	ap.Version++
+	data, err := json.Marshal(ap)
+	if err != nil {
+		return err
+	}
+	reply, err := s.c.Do("EVAL", updateScript, 1, s.apKey(), ap.Name, version, data)
+	if err != nil {
+		return err
+	}
+	switch resp.Int(reply) {
+	case -1:
+		return peerhub.ErrAnsweringPeerNotFound
+	case 0:
+		return peerhub.ErrAnsweringPeerConflict
+	}
+	return nil
+}
+
+func (s *PeerService) GetAnsweringPeer(name string) (peerhub.AnsweringPeer, error) {
+	reply, err := s.c.Do("HGET", s.apKey(), name)
+	if errors.Is(err, resp.ErrNil) {
+		return peerhub.AnsweringPeer{}, peerhub.ErrAnsweringPeerNotFound
+	}
+	if err != nil {
+		return peerhub.AnsweringPeer{}, err
+	}
+	return decode[peerhub.AnsweringPeer](resp.String(reply))
+}
+
+// DeleteAnsweringPeer deletes the answering peer if it's still at the version of ap
+func (s *PeerService) DeleteAnsweringPeer(ap peerhub.AnsweringPeer) error {
+	reply, err := s.c.Do("EVAL", deleteScript, 1, s.apKey(), ap.Name, ap.Version)
+	if err != nil {
+		return err
+	}
+	switch resp.Int(reply) {
+	case -1:
+		return peerhub.ErrAnsweringPeerNotFound
+	case 0:
+		return peerhub.ErrAnsweringPeerConflict
+	}
+	return nil
+}
+
+// CreateOfferingPeer creates the offering peer and adds it to the index of its target
+func (s *PeerService) CreateOfferingPeer(op peerhub.OfferingPeer) error {
+	op.Version = 1
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	reply, err := s.c.Do("EVAL", createScript, 2, s.opKey(), s.targetKey(op.TargetName), op.Name, data)
+	if err != nil {
+		return err
+	}
+	if resp.Int(reply) == 0 {
+		return peerhub.ErrOfferingPeerAlreadyExists
+	}
+	return nil
+}
+
+// UpdateOfferingPeer replaces the offering peer if it's still at the version of op and moves it to the index
+// of its target
+func (s *PeerService) UpdateOfferingPeer(op peerhub.OfferingPeer) error {
+	old, err := s.GetOfferingPeer(op.Name)
+	if err != nil {
+		return err
+	}
+	if old.Version != op.Version {
+		return peerhub.ErrOfferingPeerConflict
+	}
+
+	version := op.Version
+	op.Version++
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	// the old target is still the stored one if the script finds the peer at the same version
+	reply, err := s.c.Do("EVAL", updateScript, 3, s.opKey(), s.targetKey(old.TargetName), s.targetKey(op.TargetName),
+		op.Name, version, data)
+	if err != nil {
+		return err
+	}
+	switch resp.Int(reply) {
+	case -1:
+		return peerhub.ErrOfferingPeerNotFound
+	case 0:
+		return peerhub.ErrOfferingPeerConflict
+	}
+	return nil
+}
+
+func (s *PeerService) GetOfferingPeer(name string) (peerhub.OfferingPeer, error) {
+	reply, err := s.c.Do("HGET", s.opKey(), name)
+	if errors.Is(err, resp.ErrNil) {
+		return peerhub.OfferingPeer{}, peerhub.ErrOfferingPeerNotFound
+	}
+	if err != nil {
+		return peerhub.OfferingPeer{}, err
+	}
+	return decode[peerhub.OfferingPeer](resp.String(reply))
+}
+
+func (s *PeerService) GetOfferingPeers() ([]peerhub.OfferingPeer, error) {
+	reply, err := s.c.Do("HVALS", s.opKey())
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.OfferingPeer](resp.Strings(reply))
+}
+
+func (s *PeerService) GetOfferingPeersByTarget(name string) ([]peerhub.OfferingPeer, error) {
+	reply, err := s.c.Do("SMEMBERS", s.targetKey(name))
+	if err != nil {
+		return nil, err
+	}
+	names := resp.Strings(reply)
+	if len(names) == 0 {
+		return []peerhub.OfferingPeer{}, nil
+	}
+
+	args := []any{"HMGET", s.opKey()}
+	for _, n := range names {
+		args = append(args, n)
+	}
+	reply, err = s.c.Do(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []peerhub.OfferingPeer{}
+	for _, v := range reply.([]any) {
+		if v == nil {
+			continue
+		}
+		op, err := decode[peerhub.OfferingPeer](resp.String(v))
+		if err != nil {
+			return nil, err
+		}
+		// the index may briefly lag behind a retargeted peer
+		if op.TargetName == name {
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// DeleteOfferingPeer deletes the offering peer if it's still at the version of op and removes it from the index
+// of its target
+func (s *PeerService) DeleteOfferingPeer(op peerhub.OfferingPeer) error {
+	// the target of op is the stored one if the script finds the peer at the same version
+	reply, err := s.c.Do("EVAL", deleteScript, 2, s.opKey(), s.targetKey(op.TargetName), op.Name, op.Version)
+	if err != nil {
+		return err
+	}
+	switch resp.Int(reply) {
+	case -1:
+		return peerhub.ErrOfferingPeerNotFound
+	case 0:
+		return peerhub.ErrOfferingPeerConflict
+	}
+	return nil
+}
+
+func decode[T any](data string) (T, error) {
+	var v T
+	err := json.Unmarshal([]byte(data), &v)
+	return v, err
+}
+
+func decodeAll[T any](data []string) ([]T, error) {
+	vs := make([]T, 0, len(data))
+	for _, d := range data {
+		v, err := decode[T](d)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
@@ -0,0 +1,108 @@
+package redisstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestAnsweringPeerVersions(t *testing.T) {
+	c, _ := dial(t)
+	s := NewPeerService(c, DefaultPrefix)
+
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAnsweringPeer(peerhub.AnsweringPeer{Name: "ap"}); !errors.Is(err, peerhub.ErrAnsweringPeerAlreadyExists) {
+		t.Errorf("created the peer twice, err %v", err)
+	}
+
+	stale, err := s.GetAnsweringPeer("ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stale.Version != 1 {
+		t.Errorf("created peer is at version %d, want 1", stale.Version)
+	}
+
+	updated := stale
+	updated.Online = true
+	if err := s.UpdateAnsweringPeer(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateAnsweringPeer(stale); !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("stale update returned %v, want conflict", err)
+	}
+	if err := s.UpdateAnsweringPeer(peerhub.AnsweringPeer{Name: "missing"}); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("update of a missing peer returned %v, want not found", err)
+	}
+
+	ap, _ := s.GetAnsweringPeer("ap")
+	if !ap.Online || ap.Version != 2 {
+		t.Errorf("stored %+v, want online peer at version 2", ap)
+	}
+
+	if err := s.DeleteAnsweringPeer(stale); !errors.Is(err, peerhub.ErrAnsweringPeerConflict) {
+		t.Errorf("stale delete returned %v, want conflict", err)
+	}
+	if err := s.DeleteAnsweringPeer(ap); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteAnsweringPeer(ap); !errors.Is(err, peerhub.ErrAnsweringPeerNotFound) {
+		t.Errorf("delete of a deleted peer returned %v, want not found", err)
+	}
+}
+
+func TestAnsweringPeerStoredBeforeVersioning(t *testing.T) {
+	c, srv := dial(t)
+	s := NewPeerService(c, DefaultPrefix)
+
+	srv.HSet(s.apKey(), "ap", `{"Name":"ap"}`)
+	ap, err := s.GetAnsweringPeer("ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateAnsweringPeer(ap); err != nil {
+		t.Errorf("update of a peer without a version returned %v", err)
+	}
+}
+
+func TestOfferingPeerTargetIndex(t *testing.T) {
+	c, _ := dial(t)
+	s := NewPeerService(c, DefaultPrefix)
+
+	if err := s.CreateOfferingPeer(peerhub.OfferingPeer{Name: "op", TargetName: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateOfferingPeer(peerhub.OfferingPeer{Name: "op", TargetName: "a"}); !errors.Is(err, peerhub.ErrOfferingPeerAlreadyExists) {
+		t.Errorf("created the peer twice, err %v", err)
+	}
+
+	op, _ := s.GetOfferingPeer("op")
+	op.TargetName = "b"
+	if err := s.UpdateOfferingPeer(op); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateOfferingPeer(op); !errors.Is(err, peerhub.ErrOfferingPeerConflict) {
+		t.Errorf("stale update returned %v, want conflict", err)
+	}
+
+	if ops, _ := s.GetOfferingPeersByTarget("a"); len(ops) != 0 {
+		t.Errorf("peer is still indexed by its old target: %+v", ops)
+	}
+	if ops, _ := s.GetOfferingPeersByTarget("b"); len(ops) != 1 || ops[0].Version != 2 {
+		t.Errorf("indexed by the new target %+v, want op at version 2", ops)
+	}
+
+	if err := s.DeleteOfferingPeer(op); !errors.Is(err, peerhub.ErrOfferingPeerConflict) {
+		t.Errorf("stale delete returned %v, want conflict", err)
+	}
+	op, _ = s.GetOfferingPeer("op")
+	if err := s.DeleteOfferingPeer(op); err != nil {
+		t.Fatal(err)
+	}
+	if ops, _ := s.GetOfferingPeersByTarget("b"); len(ops) != 0 {
+		t.Errorf("deleted peer is still indexed: %+v", ops)
+	}
+}
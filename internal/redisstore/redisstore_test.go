@@ -0,0 +1,104 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/H3Cki/peerhub/internal/resp"
+	"github.com/H3Cki/peerhub/internal/resp/resptest"
+)
+
+func dial(t *testing.T) (*resp.Client, *resptest.Server) {
+	t.Helper()
+
+	s, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	s.Script(updateScript, update)
+	s.Script(deleteScript, del)
+	s.Script(createScript, create)
+	s.Script(answerScript, answer)
+
+	c, err := resp.Dial(resp.Options{Addr: s.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, s
+}
+
+// atVersion tells if the stored peer is at the version, peers stored before versioning are at version 0
+func atVersion(cur, version string) bool {
+	peer := struct{ Version int }{}
+	v, err := strconv.Atoi(version)
+	return json.Unmarshal([]byte(cur), &peer) == nil && err == nil && peer.Version == v
+}
+
+// update does what updateScript does
+func update(call func(args ...string) any, keys, argv []string) any {
+	cur, ok := call("HGET", keys[0], argv[0]).(string)
+	if !ok {
+		return -1
+	}
+	if !atVersion(cur, argv[1]) {
+		return 0
+	}
+	call("HSET", keys[0], argv[0], argv[2])
+	if len(keys) > 2 {
+		call("SREM", keys[1], argv[0])
+		call("SADD", keys[2], argv[0])
+	}
+	return 1
+}
+
+// del does what deleteScript does
+func del(call func(args ...string) any, keys, argv []string) any {
+	cur, ok := call("HGET", keys[0], argv[0]).(string)
+	if !ok {
+		return -1
+	}
+	if !atVersion(cur, argv[1]) {
+		return 0
+	}
+	call("HDEL", keys[0], argv[0])
+	if len(keys) > 1 {
+		call("SREM", keys[1], argv[0])
+	}
+	return 1
+}
+
+// create does what createScript does
+func create(call func(args ...string) any, keys, argv []string) any {
+	if call("HSETNX", keys[0], argv[0], argv[1]) == int64(0) {
+		return 0
+	}
+	call("SADD", keys[1], argv[0])
+	return 1
+}
+
+// answer does what answerScript does
+func answer(call func(args ...string) any, keys, argv []string) any {
+	cur, ok := call("HGET", keys[0], argv[0]).(string)
+	if !ok {
+		return -1
+	}
+	offer := map[string]any{}
+	if err := json.Unmarshal([]byte(cur), &offer); err != nil {
+		return err
+	}
+	if offer["state"] != argv[3] || call("HSETNX", keys[1], argv[0], argv[1]) == int64(0) {
+		return 0
+	}
+	offer["state"] = argv[4]
+	offer["answerid"] = argv[1]
+	data, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	call("HSET", keys[0], argv[0], string(data))
+	call("HSET", keys[2], argv[1], argv[2])
+	return string(data)
+}
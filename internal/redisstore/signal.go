@@ -0,0 +1,394 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/resp"
+)
+
+// SignalService stores offers and answers in redis hashes, candidates and mail in lists,
+// cooldowns are keys which expire on their own
+type SignalService struct {
+	c      *resp.Client
+	prefix string
+}
+
+func NewSignalService(c *resp.Client, prefix string) *SignalService {
+	return &SignalService{c: c, prefix: prefix}
+}
+
+func (s *SignalService) offersKey() string {
+	return s.prefix + "offers"
+}
+
+// answeredKey maps answered offers to their answers
+func (s *SignalService) answeredKey() string {
+	return s.prefix + "offers:answered"
+}
+
+func (s *SignalService) answersKey() string {
+	return s.prefix + "answers"
+}
+
+func (s *SignalService) candidatesKey(offerID, peerName string) string {
+	return s.prefix + "candidates:" + offerID + ":" + peerName
+}
+
+func (s *SignalService) cooldownKey(opName, apName string) string {
+	return s.prefix + "cooldowns:" + opName + ":" + apName
+}
+
+func (s *SignalService) mailKey(role peerhub.PeerRole, name string) string {
+	return s.prefix + "mail:" + string(role) + ":" + name
+}
+
+// mailboxesKey holds keys of all mail lists, so expired mail can be found
+func (s *SignalService) mailboxesKey() string {
+	return s.prefix + "mailboxes"
+}
+
+func (s *SignalService) CreateOffer(o peerhub.Offer) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Do("HSET", s.offersKey(), o.ID, data)
+	return err
+}
+
+func (s *SignalService) GetOffer(offerID string) (peerhub.Offer, error) {
+	reply, err := s.c.Do("HGET", s.offersKey(), offerID)
+	if errors.Is(err, resp.ErrNil) {
+		return peerhub.Offer{}, peerhub.ErrOfferNotFound
+	}
+	if err != nil {
+		return peerhub.Offer{}, err
+	}
+	return decode[peerhub.Offer](resp.String(reply))
+}
+
+func (s *SignalService) GetOffers() ([]peerhub.Offer, error) {
+	reply, err := s.c.Do("HVALS", s.offersKey())
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.Offer](resp.Strings(reply))
+}
+
+func (s *SignalService) GetOffersByOfferingPeer(name string) ([]peerhub.Offer, error) {
+	return s.filterOffers(func(o peerhub.Offer) bool { return o.OfferingPeer == name })
+}
+
+func (s *SignalService) GetOffersByAnsweringPeer(name string) ([]peerhub.Offer, error) {
+	return s.filterOffers(func(o peerhub.Offer) bool { return o.AnsweringPeer == name })
+}
+
+func (s *SignalService) filterOffers(keep func(peerhub.Offer) bool) ([]peerhub.Offer, error) {
+	all, err := s.GetOffers()
+	if err != nil {
+		return nil, err
+	}
+	offers := []peerhub.Offer{}
+	for _, o := range all {
+		if keep(o) {
+			offers = append(offers, o)
+		}
+	}
+	return offers, nil
+}
+
+func (s *SignalService) DeleteOffer(offerID string) error {
+	o, err := s.GetOffer(offerID)
+	if errors.Is(err, peerhub.ErrOfferNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = s.deleteOffer(o)
+	return err
+}
+
+// deleteOffer deletes the offer with its candidates and reports whether it was deleted by this call
+func (s *SignalService) deleteOffer(o peerhub.Offer) (bool, error) {
+	replies, err := s.c.Tx(func(queue func(args ...any) error) error {
+		if err := queue("HDEL", s.offersKey(), o.ID); err != nil {
+			return err
+		}
+		if err := queue("HDEL", s.answeredKey(), o.ID); err != nil {
+			return err
+		}
+		return queue("DEL", s.candidatesKey(o.ID, o.OfferingPeer), s.candidatesKey(o.ID, o.AnsweringPeer))
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Int(replies[0]) == 1, nil
+}
+
+// DeleteExpiredOffers deletes expired offers, with several hubs sharing the store
+// each offer is returned only to the hub that deleted it
+func (s *SignalService) DeleteExpiredOffers(now time.Time) ([]peerhub.Offer, error) {
+	offers, err := s.GetOffers()
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []peerhub.Offer{}
+	for _, o := range offers {
+		if !o.Expired(now) {
+			continue
+		}
+		deleted, err := s.deleteOffer(o)
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			expired = append(expired, o)
+		}
+	}
+	return expired, nil
+}
+
+func (s *SignalService) CreateAnswer(a peerhub.Answer) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Do("HSET", s.answersKey(), a.ID, data)
+	return err
+}
+
+// answerScript answers the offer ARGV[1] in KEYS[1] with the answer ARGV[2] stored as ARGV[3] in KEYS[3]
+// if the offer is in state ARGV[4], moving it to state ARGV[5] and recording the answer in KEYS[2],
+// it returns the answered offer, -1 if there is no offer and 0 if it's not pending
+const answerScript = `
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if not cur then
+	return -1
+end
+local offer = cjson.decode(cur)
+if offer['state'] ~= ARGV[4] or redis.call('HSETNX', KEYS[2], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+offer['state'] = ARGV[5]
+offer['answerid'] = ARGV[2]
+local data = cjson.encode(offer)
+redis.call('HSET', KEYS[1], ARGV[1], data)
+redis.call('HSET', KEYS[3], ARGV[2], ARGV[3])
+return data
+`
+
+// AnswerOffer checks and answers the offer in a script, so only one of concurrent answers succeeds
+// even across hubs
+func (s *SignalService) AnswerOffer(a peerhub.Answer) (peerhub.Offer, error) {
+	answerData, err := json.Marshal(a)
+	if err != nil {
+		return peerhub.Offer{}, err
+	}
+
+	reply, err := s.c.Do("EVAL", answerScript, 3, s.offersKey(), s.answeredKey(), s.answersKey(),
+		a.OfferID, a.ID, answerData, string(peerhub.OfferStatePending), string(peerhub.OfferStateAnswered))
+	if err != nil {
+		return peerhub.Offer{}, err
+	}
+
+	if n, ok := reply.(int64); ok {
+		if n == -1 {
+			return peerhub.Offer{}, peerhub.ErrOfferNotFound
+		}
+		return peerhub.Offer{}, peerhub.ErrOfferAlreadyAnswered
+	}
+	return decode[peerhub.Offer](resp.String(reply))
+}
+
+func (s *SignalService) GetAnswer(answerID string) (peerhub.Answer, error) {
+	reply, err := s.c.Do("HGET", s.answersKey(), answerID)
+	if errors.Is(err, resp.ErrNil) {
+		return peerhub.Answer{}, peerhub.ErrAnswerNotFound
+	}
+	if err != nil {
+		return peerhub.Answer{}, err
+	}
+	return decode[peerhub.Answer](resp.String(reply))
+}
+
+func (s *SignalService) getAnswers() ([]peerhub.Answer, error) {
+	reply, err := s.c.Do("HVALS", s.answersKey())
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.Answer](resp.Strings(reply))
+}
+
+func (s *SignalService) GetAnswersByOffer(offerID string) ([]peerhub.Answer, error) {
+	all, err := s.getAnswers()
+	if err != nil {
+		return nil, err
+	}
+	answers := []peerhub.Answer{}
+	for _, a := range all {
+		if a.OfferID == offerID {
+			answers = append(answers, a)
+		}
+	}
+	return answers, nil
+}
+
+func (s *SignalService) DeleteAnswer(answerID string) error {
+	_, err := s.c.Do("HDEL", s.answersKey(), answerID)
+	return err
+}
+
+func (s *SignalService) DeleteExpiredAnswers(now time.Time) ([]peerhub.Answer, error) {
+	answers, err := s.getAnswers()
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []peerhub.Answer{}
+	for _, a := range answers {
+		if !a.Expired(now) {
+			continue
+		}
+		reply, err := s.c.Do("HDEL", s.answersKey(), a.ID)
+		if err != nil {
+			return nil, err
+		}
+		if resp.Int(reply) == 1 {
+			expired = append(expired, a)
+		}
+	}
+	return expired, nil
+}
+
+func (s *SignalService) CreateCandidate(c peerhub.Candidate) error {
+	reply, err := s.c.Do("HEXISTS", s.offersKey(), c.OfferID)
+	if err != nil {
+		return err
+	}
+	if resp.Int(reply) == 0 {
+		return peerhub.ErrOfferNotFound
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Do("RPUSH", s.candidatesKey(c.OfferID, c.To), data)
+	return err
+}
+
+func (s *SignalService) PopCandidates(offerID, peerName string) ([]peerhub.Candidate, error) {
+	key := s.candidatesKey(offerID, peerName)
+	replies, err := s.c.Tx(func(queue func(args ...any) error) error {
+		if err := queue("LRANGE", key, 0, -1); err != nil {
+			return err
+		}
+		return queue("DEL", key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.Candidate](resp.Strings(replies[0]))
+}
+
+func (s *SignalService) CreateCooldown(c peerhub.Cooldown) error {
+	ttl := time.Until(c.ExpiresAt).Milliseconds()
+	if ttl <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.c.Do("SET", s.cooldownKey(c.OfferingPeer, c.AnsweringPeer), data, "PX", ttl)
+	return err
+}
+
+func (s *SignalService) GetCooldown(opName, apName string) (peerhub.Cooldown, error) {
+	reply, err := s.c.Do("GET", s.cooldownKey(opName, apName))
+	if errors.Is(err, resp.ErrNil) {
+		return peerhub.Cooldown{}, peerhub.ErrCooldownNotFound
+	}
+	if err != nil {
+		return peerhub.Cooldown{}, err
+	}
+	return decode[peerhub.Cooldown](resp.String(reply))
+}
+
+// DeleteExpiredCooldowns returns nothing, cooldown keys expire on their own
+func (s *SignalService) DeleteExpiredCooldowns(now time.Time) ([]peerhub.Cooldown, error) {
+	return []peerhub.Cooldown{}, nil
+}
+
+func (s *SignalService) CreateMail(m peerhub.Mail) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	key := s.mailKey(m.RecipientRole, m.Recipient)
+	_, err = s.c.Tx(func(queue func(args ...any) error) error {
+		if err := queue("RPUSH", key, data); err != nil {
+			return err
+		}
+		return queue("SADD", s.mailboxesKey(), key)
+	})
+	return err
+}
+
+func (s *SignalService) PopMail(role peerhub.PeerRole, name string) ([]peerhub.Mail, error) {
+	key := s.mailKey(role, name)
+	replies, err := s.c.Tx(func(queue func(args ...any) error) error {
+		if err := queue("LRANGE", key, 0, -1); err != nil {
+			return err
+		}
+		if err := queue("DEL", key); err != nil {
+			return err
+		}
+		return queue("SREM", s.mailboxesKey(), key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll[peerhub.Mail](resp.Strings(replies[0]))
+}
+
+// DeleteExpiredMail removes expired mail by value, so mail queued meanwhile is left intact
+func (s *SignalService) DeleteExpiredMail(now time.Time) ([]peerhub.Mail, error) {
+	reply, err := s.c.Do("SMEMBERS", s.mailboxesKey())
+	if err != nil {
+		return nil, err
+	}
+
+	expired := []peerhub.Mail{}
+	for _, key := range resp.Strings(reply) {
+		reply, err := s.c.Do("LRANGE", key, 0, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, data := range resp.Strings(reply) {
+			m, err := decode[peerhub.Mail](data)
+			if err != nil {
+				return nil, err
+			}
+			if !m.Expired(now) {
+				continue
+			}
+			removed, err := s.c.Do("LREM", key, 1, data)
+			if err != nil {
+				return nil, err
+			}
+			if resp.Int(removed) == 1 {
+				expired = append(expired, m)
+			}
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,171 @@
+package redisstore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestAnswerOffer(t *testing.T) {
+	c, _ := dial(t)
+	s := NewSignalService(c, DefaultPrefix)
+
+	if _, err := s.AnswerOffer(peerhub.NewAnswer("missing", "op", "ap", "sdp", time.Minute)); !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("answered a missing offer, err %v", err)
+	}
+
+	o := peerhub.NewOffer("op", "v=0\r\no=- 1 2 IN IP4 127.0.0.1", "ap", time.Minute)
+	if err := s.CreateOffer(o); err != nil {
+		t.Fatal(err)
+	}
+
+	a := peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)
+	answered, err := s.AnswerOffer(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answered.State != peerhub.OfferStateAnswered || answered.AnswerID != a.ID || answered.SDP != o.SDP {
+		t.Errorf("answered %+v", answered)
+	}
+	if !answered.ExpiresAt.Equal(o.ExpiresAt) {
+		t.Errorf("answering changed the expiry from %s to %s", o.ExpiresAt, answered.ExpiresAt)
+	}
+
+	stored, _ := s.GetOffer(o.ID)
+	if stored.State != peerhub.OfferStateAnswered || stored.AnswerID != a.ID {
+		t.Errorf("stored %+v", stored)
+	}
+	if _, err := s.GetAnswer(a.ID); err != nil {
+		t.Errorf("answer wasn't stored: %v", err)
+	}
+
+	if _, err := s.AnswerOffer(peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)); !errors.Is(err, peerhub.ErrOfferAlreadyAnswered) {
+		t.Errorf("answered the offer twice, err %v", err)
+	}
+}
+
+func TestAnswerOfferConcurrently(t *testing.T) {
+	c, _ := dial(t)
+	s := NewSignalService(c, DefaultPrefix)
+
+	o := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	if err := s.CreateOffer(o); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	won := 0
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.AnswerOffer(peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)); err == nil {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 {
+		t.Errorf("%d answers won, want 1", won)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	c, _ := dial(t)
+	s := NewSignalService(c, DefaultPrefix)
+
+	if err := s.CreateCandidate(peerhub.Candidate{OfferID: "missing", To: "ap"}); !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("created a candidate of a missing offer, err %v", err)
+	}
+
+	o := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	s.CreateOffer(o)
+	for _, cand := range []string{"1", "2"} {
+		if err := s.CreateCandidate(peerhub.Candidate{OfferID: o.ID, To: "ap", Candidate: cand}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	popped, err := s.PopCandidates(o.ID, "ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(popped) != 2 || popped[0].Candidate != "1" || popped[1].Candidate != "2" {
+		t.Errorf("popped %+v, want candidates in order", popped)
+	}
+	if popped, _ := s.PopCandidates(o.ID, "ap"); len(popped) != 0 {
+		t.Errorf("popped candidates twice: %+v", popped)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	c, _ := dial(t)
+	s := NewSignalService(c, DefaultPrefix)
+
+	expired := peerhub.NewOffer("op", "sdp", "ap", time.Second)
+	kept := peerhub.NewOffer("op", "sdp", "ap", time.Hour)
+	s.CreateOffer(expired)
+	s.CreateOffer(kept)
+	s.CreateCandidate(peerhub.Candidate{OfferID: expired.ID, To: "ap"})
+	s.CreateAnswer(peerhub.NewAnswer(expired.ID, "op", "ap", "sdp", time.Second))
+
+	now := time.Now()
+	s.CreateMail(peerhub.Mail{ID: "old", RecipientRole: peerhub.PeerRoleAnswering, Recipient: "ap", ExpiresAt: now})
+	s.CreateMail(peerhub.Mail{ID: "new", RecipientRole: peerhub.PeerRoleAnswering, Recipient: "ap", ExpiresAt: now.Add(time.Hour)})
+
+	later := now.Add(time.Minute)
+	offers, err := s.DeleteExpiredOffers(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 1 || offers[0].ID != expired.ID {
+		t.Errorf("deleted offers %+v, want %s", offers, expired.ID)
+	}
+	if offers, _ := s.DeleteExpiredOffers(later); len(offers) != 0 {
+		t.Errorf("deleted offers twice: %+v", offers)
+	}
+	if popped, _ := s.PopCandidates(expired.ID, "ap"); len(popped) != 0 {
+		t.Errorf("candidates of a deleted offer were kept: %+v", popped)
+	}
+	if _, err := s.GetOffer(kept.ID); err != nil {
+		t.Errorf("offer that didn't expire was deleted: %v", err)
+	}
+
+	if answers, _ := s.DeleteExpiredAnswers(later); len(answers) != 1 {
+		t.Errorf("deleted %d answers, want 1", len(answers))
+	}
+
+	mails, err := s.DeleteExpiredMail(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mails) != 1 || mails[0].ID != "old" {
+		t.Errorf("deleted mail %+v, want old", mails)
+	}
+	if left, _ := s.PopMail(peerhub.PeerRoleAnswering, "ap"); len(left) != 1 || left[0].ID != "new" {
+		t.Errorf("left mail %+v, want new", left)
+	}
+}
+
+func TestCooldownExpires(t *testing.T) {
+	c, _ := dial(t)
+	s := NewSignalService(c, DefaultPrefix)
+
+	if err := s.CreateCooldown(peerhub.Cooldown{OfferingPeer: "op", AnsweringPeer: "ap", ExpiresAt: time.Now().Add(50 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetCooldown("op", "ap"); err != nil {
+		t.Fatalf("cooldown wasn't stored: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := s.GetCooldown("op", "ap"); !errors.Is(err, peerhub.ErrCooldownNotFound) {
+		t.Errorf("cooldown didn't expire, err %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNil is returned for nil replies, e.g. GET of a missing key
+var ErrNil = errors.New("nil reply")
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+	// DialTimeout defaults to 5 seconds
+	DialTimeout time.Duration
+	// Timeout is the deadline of writing a command and reading its reply, it defaults to 5 seconds,
+	// a server that doesn't reply in time is treated as a network error and its connection is redialed
+	Timeout time.Duration
+}
+
+// Client is a minimal client of servers speaking RESP (redis and compatible ones), commands are sent over
+// a single connection one at a time and the connection is redialed after network errors
+type Client struct {
+	mu   sync.Mutex
+	opts Options
+	conn *conn
+}
+
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func Dial(opts Options) (*Client, error) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	c := &Client{opts: opts}
+	cn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = cn
+	return c, nil
+}
+
+func (c *Client) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", c.opts.Addr, c.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: c.opts.Timeout}
+
+	if c.opts.Password != "" {
+		if _, err := cn.do("AUTH", c.opts.Password); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.do("SELECT", c.opts.DB); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("error selecting database: %w", err)
+		}
+	}
+
+	return cn, nil
+}
+
+// Do sends the command and returns its reply, which is a string, []byte, int64, []any or nil
+func (c *Client) Do(args ...any) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.do(args...)
+}
+
+func (c *Client) do(args ...any) (any, error) {
+	if c.conn == nil {
+		cn, err := c.dial()
+		if err != nil {
+			return nil, err
+		}
+		c.conn = cn
+	}
+
+	reply, err := c.conn.do(args...)
+	var respErr Error
+	if err != nil && !errors.As(err, &respErr) && !errors.Is(err, ErrNil) {
+		// the connection is in an unknown state
+		c.conn.nc.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// Tx runs commands queued by fn in a MULTI/EXEC transaction and returns the replies of the commands,
+// no other command of the client is sent in between
+func (c *Client) Tx(fn func(queue func(args ...any) error) error) ([]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.do("MULTI"); err != nil {
+		return nil, err
+	}
+
+	queue := func(args ...any) error {
+		_, err := c.do(args...)
+		return err
+	}
+	if err := fn(queue); err != nil {
+		if _, dErr := c.do("DISCARD"); dErr != nil {
+			return nil, errors.Join(err, dErr)
+		}
+		return nil, err
+	}
+
+	reply, err := c.do("EXEC")
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]any)
+	if !ok {
+		return nil, errors.New("transaction aborted")
+	}
+	return replies, nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.nc.Close()
+	c.conn = nil
+	return err
+}
+
+// Subscribe opens a dedicated connection subscribed to the channel and calls fn with every message published
+// to it until the returned close function is called, a dropped connection is redialed and resubscribed,
+// notify is called with the error of every drop and failed attempt and with nil once subscribed again,
+// messages published in between are lost
+func (c *Client) Subscribe(channel string, fn func(payload []byte), notify func(err error)) (func() error, error) {
+	cn, err := c.subscribe(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &subscription{cn: cn, done: make(chan struct{})}
+	go s.run(c, channel, fn, notify)
+	return s.close, nil
+}
+
+// subscribe dials a connection subscribed to the channel
+func (c *Client) subscribe(channel string) (*conn, error) {
+	cn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := cn.do("SUBSCRIBE", channel); err != nil {
+		cn.nc.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+const (
+	minResubscribeBackoff = 100 * time.Millisecond
+	maxResubscribeBackoff = 10 * time.Second
+)
+
+type subscription struct {
+	mu     sync.Mutex
+	cn     *conn
+	closed bool
+	done   chan struct{}
+}
+
+func (s *subscription) run(c *Client, channel string, fn func(payload []byte), notify func(err error)) {
+	cn := s.cn
+	for {
+		err := cn.receive(fn)
+		if s.isClosed() {
+			return
+		}
+		notify(fmt.Errorf("subscription to %s lost: %w", channel, err))
+
+		backoff := minResubscribeBackoff
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(backoff):
+			}
+
+			cn, err = c.subscribe(channel)
+			if err == nil {
+				break
+			}
+			notify(fmt.Errorf("error resubscribing to %s: %w", channel, err))
+			backoff = min(backoff*2, maxResubscribeBackoff)
+		}
+
+		if !s.swap(cn) {
+			cn.nc.Close()
+			return
+		}
+		notify(nil)
+	}
+}
+
+func (s *subscription) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// swap replaces the connection of the subscription, false if the subscription was closed
+func (s *subscription) swap(cn *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.cn = cn
+	return true
+}
+
+func (s *subscription) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	return s.cn.nc.Close()
+}
+
+// receive calls fn with messages of the subscribed connection until it fails, the connection is pinged
+// so a server that stopped responding is told apart from a quiet channel
+func (cn *conn) receive(fn func(payload []byte)) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(cn.timeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			cn.nc.SetWriteDeadline(time.Now().Add(cn.timeout))
+			if err := cn.write("PING"); err != nil {
+				cn.nc.Close()
+				return
+			}
+		}
+	}()
+
+	defer cn.nc.Close()
+	for {
+		cn.nc.SetReadDeadline(time.Now().Add(2 * cn.timeout))
+		reply, err := cn.read()
+		if err != nil {
+			return err
+		}
+		msg, ok := reply.([]any)
+		if !ok || len(msg) != 3 || String(msg[0]) != "message" {
+			continue
+		}
+		if payload, ok := msg[2].([]byte); ok {
+			fn(payload)
+		}
+	}
+}
+
+func (cn *conn) do(args ...any) (any, error) {
+	if cn.timeout > 0 {
+		cn.nc.SetDeadline(time.Now().Add(cn.timeout))
+	}
+	if err := cn.write(args...); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+func (cn *conn) write(args ...any) error {
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		b, err := bulk(arg)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n", len(b))
+		cn.w.Write(b)
+		cn.w.WriteString("\r\n")
+	}
+	return cn.w.Flush()
+}
+
+func bulk(arg any) ([]byte, error) {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	return nil, fmt.Errorf("unsupported argument type %T", arg)
+}
+
+func (cn *conn) read() (any, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			v, err := cn.read()
+			var respErr Error
+			switch {
+			case errors.As(err, &respErr):
+				// errors of transaction commands are elements of the EXEC reply
+				arr[i] = respErr
+			case errors.Is(err, ErrNil):
+			case err != nil:
+				return nil, err
+			default:
+				arr[i] = v
+			}
+		}
+		return arr, nil
+	}
+
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
+
+// String converts simple string and bulk replies to string
+func String(reply any) string {
+	switch v := reply.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Strings converts array reply to strings
+func Strings(reply any) []string {
+	arr, _ := reply.([]any)
+	strs := make([]string, 0, len(arr))
+	for _, v := range arr {
+		strs = append(strs, String(v))
+	}
+	return strs
+}
+
+// Int converts integer reply to int64
+func Int(reply any) int64 {
+	n, _ := reply.(int64)
+	return n
+}
@@ -0,0 +1,183 @@
+package resp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub/internal/resp/resptest"
+)
+
+func newServer(t *testing.T) *resptest.Server {
+	t.Helper()
+	s, err := resptest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(t *testing.T, s *resptest.Server, timeout time.Duration) *Client {
+	t.Helper()
+	c, err := Dial(Options{Addr: s.Addr(), Timeout: timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestDo(t *testing.T) {
+	c := dial(t, newServer(t), 0)
+
+	if _, err := c.Do("SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := c.Do("GET", "k")
+	if err != nil || String(reply) != "v" {
+		t.Fatalf("GET returned %v %v, want v", reply, err)
+	}
+	if _, err := c.Do("GET", "missing"); !errors.Is(err, ErrNil) {
+		t.Errorf("GET of a missing key returned %v, want ErrNil", err)
+	}
+
+	var respErr Error
+	if _, err := c.Do("NOPE"); !errors.As(err, &respErr) {
+		t.Errorf("unknown command returned %v, want an error reply", err)
+	}
+	// error replies keep the connection usable
+	if reply, err := c.Do("GET", "k"); err != nil || String(reply) != "v" {
+		t.Errorf("GET after an error reply returned %v %v", reply, err)
+	}
+}
+
+func TestTx(t *testing.T) {
+	c := dial(t, newServer(t), 0)
+
+	replies, err := c.Tx(func(queue func(args ...any) error) error {
+		if err := queue("RPUSH", "l", "a"); err != nil {
+			return err
+		}
+		return queue("LRANGE", "l", 0, -1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 || Int(replies[0]) != 1 || len(Strings(replies[1])) != 1 {
+		t.Errorf("transaction replied %v", replies)
+	}
+}
+
+func TestDoTimesOut(t *testing.T) {
+	s := newServer(t)
+	c := dial(t, s, 100*time.Millisecond)
+
+	s.Stall()
+	start := time.Now()
+	_, err := c.Do("PING")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("command to a stalled server returned %v, want a timeout", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("command took %s to time out", time.Since(start))
+	}
+	s.Resume()
+
+	// the timed out connection is replaced
+	if _, err := c.Do("SET", "k", "v"); err != nil {
+		t.Errorf("command after a timeout returned %v", err)
+	}
+}
+
+func TestSubscribeRecovers(t *testing.T) {
+	s := newServer(t)
+	c := dial(t, s, 100*time.Millisecond)
+
+	payloads := make(chan string, 10)
+	notified := make(chan error, 10)
+	unsubscribe, err := c.Subscribe("ch", func(payload []byte) {
+		payloads <- string(payload)
+	}, func(err error) {
+		notified <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	publish := func(payload string) {
+		t.Helper()
+		if _, err := c.Do("PUBLISH", "ch", payload); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-payloads:
+			if got != payload {
+				t.Fatalf("received %q, want %q", got, payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q wasn't received", payload)
+		}
+	}
+
+	publish("before")
+
+	s.DropConns()
+	select {
+	case err := <-notified:
+		if err == nil {
+			t.Fatal("resubscribed before the drop was reported")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("dropped subscription wasn't reported")
+	}
+	select {
+	case err := <-notified:
+		if err != nil {
+			t.Fatalf("resubscribing failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription wasn't restored")
+	}
+
+	// the command connection was dropped as well, the first command fails and redials it
+	c.Do("PING")
+	publish("after")
+
+	unsubscribe()
+	deadline := time.Now().Add(time.Second)
+	for s.Subscribers("ch") > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.Subscribers("ch"); n != 0 {
+		t.Errorf("%d subscribers left after unsubscribing", n)
+	}
+}
+
+func TestSubscriptionDetectsStalledServer(t *testing.T) {
+	s := newServer(t)
+	c := dial(t, s, 50*time.Millisecond)
+
+	notified := make(chan error, 10)
+	unsubscribe, err := c.Subscribe("ch", func([]byte) {}, func(err error) {
+		notified <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	s.Stall()
+	defer s.Resume()
+	select {
+	case err := <-notified:
+		if err == nil {
+			t.Fatal("resubscribed to a stalled server")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stalled subscription wasn't reported")
+	}
+}
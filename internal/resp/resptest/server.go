@@ -0,0 +1,623 @@
+// Package resptest provides an in-process server speaking RESP for tests of code using the resp client
+package resptest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Script stands in for a Lua script, call executes a command like redis.call and returns its reply as
+// an int64, a string, nil, a slice of replies or an error, the script returns an int64, a string, nil or an error
+type Script func(call func(args ...string) any, keys, argv []string) any
+
+// status is a simple string reply
+type status string
+
+// errReply is an error reply
+type errReply string
+
+// Server keeps strings, hashes, sets and lists in memory, runs scripts registered as Go functions and supports
+// pub/sub, commands of all connections are executed one at a time
+type Server struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	strs   map[string]entry
+	hashes map[string]map[string]string
+	sets   map[string]map[string]bool
+	lists  map[string][]string
+	subs   map[string]map[*conn]bool
+	conns  map[*conn]bool
+	// scripts are keyed by the SHA1 digest of their source
+	scripts map[string]Script
+	stalled chan struct{}
+	wg      sync.WaitGroup
+}
+
+type entry struct {
+	val     string
+	expires time.Time
+}
+
+type conn struct {
+	nc net.Conn
+	// mu serializes writes of replies and published messages
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewServer starts a server listening on a random local port
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		l:       l,
+		strs:    map[string]entry{},
+		hashes:  map[string]map[string]string{},
+		sets:    map[string]map[string]bool{},
+		lists:   map[string][]string{},
+		subs:    map[string]map[*conn]bool{},
+		conns:   map[*conn]bool{},
+		scripts: map[string]Script{},
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and drops its connections
+func (s *Server) Close() error {
+	err := s.l.Close()
+	s.DropConns()
+	s.Resume()
+	s.wg.Wait()
+	return err
+}
+
+// DropConns closes all current connections, the server keeps accepting new ones
+func (s *Server) DropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+}
+
+// Stall makes the server stop replying until Resume is called
+func (s *Server) Stall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stalled == nil {
+		s.stalled = make(chan struct{})
+	}
+}
+
+func (s *Server) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stalled != nil {
+		close(s.stalled)
+		s.stalled = nil
+	}
+}
+
+// Subscribers returns the number of connections subscribed to the channel
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs[channel])
+}
+
+// Get returns the string stored under the key
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.str(key)
+	return e.val, ok
+}
+
+// HGet returns the field of the hash stored under the key
+func (s *Server) HGet(key, field string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.hashes[key][field]
+	return v, ok
+}
+
+// Set stores the string under the key
+func (s *Server) Set(key, val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strs[key] = entry{val: val}
+}
+
+// HSet stores the field of the hash under the key
+func (s *Server) HSet(key, field, val string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exec([]string{"HSET", key, field, val})
+}
+
+// Script runs fn in place of the script with the source src when it's evaluated
+func (s *Server) Script(src string, fn Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[scriptSHA(src)] = fn
+}
+
+func scriptSHA(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{nc: nc, w: bufio.NewWriter(nc)}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		for _, subs := range s.subs {
+			delete(subs, c)
+		}
+		s.mu.Unlock()
+		c.nc.Close()
+	}()
+
+	r := bufio.NewReader(c.nc)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		stalled := s.stalled
+		s.mu.Unlock()
+		if stalled != nil {
+			<-stalled
+		}
+
+		s.mu.Lock()
+		var reply any
+		switch strings.ToUpper(args[0]) {
+		case "MULTI":
+			inMulti, queued = true, nil
+			reply = status("OK")
+		case "EXEC":
+			replies := make([]any, len(queued))
+			for i, q := range queued {
+				replies[i] = s.exec(q)
+			}
+			inMulti, queued = false, nil
+			reply = replies
+		case "DISCARD":
+			inMulti, queued = false, nil
+			reply = status("OK")
+		case "SUBSCRIBE":
+			if s.subs[args[1]] == nil {
+				s.subs[args[1]] = map[*conn]bool{}
+			}
+			s.subs[args[1]][c] = true
+			reply = []any{[]byte("subscribe"), []byte(args[1]), int64(1)}
+		default:
+			if inMulti {
+				queued = append(queued, args)
+				reply = status("QUEUED")
+			} else {
+				reply = s.exec(args)
+			}
+		}
+		s.mu.Unlock()
+
+		if err := c.write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) write(reply any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeReply(c.w, reply)
+	return c.w.Flush()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command length %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(l[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case errReply:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	case nil:
+		w.WriteString("$-1\r\n")
+	}
+}
+
+func (s *Server) str(key string) (entry, bool) {
+	e, ok := s.strs[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.strs, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func wrongArgs(cmd string) errReply {
+	return errReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+// exec executes a command, s.mu has to be held
+func (s *Server) exec(args []string) any {
+	cmd := strings.ToUpper(args[0])
+	// minimal number of arguments, including the command
+	minArgs := map[string]int{
+		"AUTH": 2, "SELECT": 2, "GET": 2, "SET": 3, "DEL": 2, "EXISTS": 2,
+		"HGET": 3, "HSET": 4, "HSETNX": 4, "HDEL": 3, "HEXISTS": 3, "HVALS": 2, "HMGET": 3,
+		"SADD": 3, "SREM": 3, "SMEMBERS": 2, "RPUSH": 3, "LRANGE": 4, "LREM": 4,
+		"PUBLISH": 3, "EVAL": 3, "EVALSHA": 3,
+	}
+	if n, ok := minArgs[cmd]; ok && len(args) < n {
+		return wrongArgs(cmd)
+	}
+
+	switch cmd {
+	case "PING":
+		return status("PONG")
+	case "AUTH", "SELECT":
+		return status("OK")
+	case "GET":
+		e, ok := s.str(args[1])
+		if !ok {
+			return nil
+		}
+		return []byte(e.val)
+	case "SET":
+		e := entry{val: args[2]}
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "PX", "EX":
+				if i+1 >= len(args) {
+					return errReply("ERR syntax error")
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n <= 0 {
+					return errReply("ERR invalid expire time in 'set' command")
+				}
+				unit := time.Millisecond
+				if strings.ToUpper(args[i]) == "EX" {
+					unit = time.Second
+				}
+				e.expires = time.Now().Add(time.Duration(n) * unit)
+				i++
+			default:
+				return errReply("ERR syntax error")
+			}
+		}
+		s.strs[args[1]] = e
+		return status("OK")
+	case "DEL":
+		n := int64(0)
+		for _, k := range args[1:] {
+			if s.exists(k) {
+				n++
+			}
+			delete(s.strs, k)
+			delete(s.hashes, k)
+			delete(s.sets, k)
+			delete(s.lists, k)
+		}
+		return n
+	case "EXISTS":
+		n := int64(0)
+		for _, k := range args[1:] {
+			if s.exists(k) {
+				n++
+			}
+		}
+		return n
+	case "HGET":
+		v, ok := s.hashes[args[1]][args[2]]
+		if !ok {
+			return nil
+		}
+		return []byte(v)
+	case "HSET":
+		if len(args)%2 != 0 {
+			return wrongArgs(cmd)
+		}
+		h := s.hash(args[1])
+		n := int64(0)
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				n++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return n
+	case "HSETNX":
+		h := s.hash(args[1])
+		if _, ok := h[args[2]]; ok {
+			return int64(0)
+		}
+		h[args[2]] = args[3]
+		return int64(1)
+	case "HDEL":
+		n := int64(0)
+		for _, f := range args[2:] {
+			if _, ok := s.hashes[args[1]][f]; ok {
+				delete(s.hashes[args[1]], f)
+				n++
+			}
+		}
+		if len(s.hashes[args[1]]) == 0 {
+			delete(s.hashes, args[1])
+		}
+		return n
+	case "HEXISTS":
+		if _, ok := s.hashes[args[1]][args[2]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "HVALS":
+		fields := make([]string, 0, len(s.hashes[args[1]]))
+		for f := range s.hashes[args[1]] {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		vals := make([]any, len(fields))
+		for i, f := range fields {
+			vals[i] = []byte(s.hashes[args[1]][f])
+		}
+		return vals
+	case "HMGET":
+		vals := make([]any, len(args)-2)
+		for i, f := range args[2:] {
+			if v, ok := s.hashes[args[1]][f]; ok {
+				vals[i] = []byte(v)
+			}
+		}
+		return vals
+	case "SADD":
+		if s.sets[args[1]] == nil {
+			s.sets[args[1]] = map[string]bool{}
+		}
+		n := int64(0)
+		for _, m := range args[2:] {
+			if !s.sets[args[1]][m] {
+				s.sets[args[1]][m] = true
+				n++
+			}
+		}
+		return n
+	case "SREM":
+		n := int64(0)
+		for _, m := range args[2:] {
+			if s.sets[args[1]][m] {
+				delete(s.sets[args[1]], m)
+				n++
+			}
+		}
+		if len(s.sets[args[1]]) == 0 {
+			delete(s.sets, args[1])
+		}
+		return n
+	case "SMEMBERS":
+		members := make([]string, 0, len(s.sets[args[1]]))
+		for m := range s.sets[args[1]] {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		vals := make([]any, len(members))
+		for i, m := range members {
+			vals[i] = []byte(m)
+		}
+		return vals
+	case "RPUSH":
+		s.lists[args[1]] = append(s.lists[args[1]], args[2:]...)
+		return int64(len(s.lists[args[1]]))
+	case "LRANGE":
+		l := s.lists[args[1]]
+		start, err1 := strconv.Atoi(args[2])
+		stop, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			return errReply("ERR value is not an integer or out of range")
+		}
+		if start < 0 {
+			start = max(len(l)+start, 0)
+		}
+		if stop < 0 {
+			stop = len(l) + stop
+		}
+		stop = min(stop, len(l)-1)
+		vals := []any{}
+		for i := start; i <= stop; i++ {
+			vals = append(vals, []byte(l[i]))
+		}
+		return vals
+	case "LREM":
+		count, err := strconv.Atoi(args[2])
+		if err != nil || count < 0 {
+			return errReply("ERR only non-negative counts are supported")
+		}
+		kept := []string{}
+		n := 0
+		for _, v := range s.lists[args[1]] {
+			if v == args[3] && (count == 0 || n < count) {
+				n++
+				continue
+			}
+			kept = append(kept, v)
+		}
+		if len(kept) == 0 {
+			delete(s.lists, args[1])
+		} else {
+			s.lists[args[1]] = kept
+		}
+		return int64(n)
+	case "PUBLISH":
+		msg := []any{[]byte("message"), []byte(args[1]), []byte(args[2])}
+		n := int64(0)
+		for c := range s.subs[args[1]] {
+			if c.write(msg) == nil {
+				n++
+			}
+		}
+		return n
+	case "EVAL":
+		return s.eval(scriptSHA(args[1]), args[2:])
+	case "EVALSHA":
+		return s.eval(strings.ToLower(args[1]), args[2:])
+	}
+
+	return errReply(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+}
+
+func (s *Server) exists(key string) bool {
+	if _, ok := s.str(key); ok {
+		return true
+	}
+	_, h := s.hashes[key]
+	_, set := s.sets[key]
+	_, l := s.lists[key]
+	return h || set || l
+}
+
+func (s *Server) hash(key string) map[string]string {
+	if s.hashes[key] == nil {
+		s.hashes[key] = map[string]string{}
+	}
+	return s.hashes[key]
+}
+
+// eval runs the script registered under the SHA1 digest with keys and arguments the way redis does,
+// call executes commands directly
+func (s *Server) eval(sha string, args []string) any {
+	script, ok := s.scripts[sha]
+	if !ok {
+		return errReply("NOSCRIPT No matching script")
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || 1+numKeys > len(args) {
+		return errReply("ERR invalid number of keys")
+	}
+
+	call := func(args ...string) any {
+		return fromReply(s.exec(args))
+	}
+	return toReply(script(call, args[1:1+numKeys], args[1+numKeys:]))
+}
+
+// fromReply converts a reply for a script, bulk strings to strings, status replies to their strings
+// and error replies to errors
+func fromReply(reply any) any {
+	switch v := reply.(type) {
+	case status:
+		return string(v)
+	case errReply:
+		return errors.New(string(v))
+	case []byte:
+		return string(v)
+	case []any:
+		vals := make([]any, len(v))
+		for i, e := range v {
+			vals[i] = fromReply(e)
+		}
+		return vals
+	}
+	return reply
+}
+
+// toReply converts a value returned by a script to a reply
+func toReply(v any) any {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case error:
+		return errReply(v.Error())
+	}
+	return nil
+}
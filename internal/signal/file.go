@@ -0,0 +1,452 @@
+package sig
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/H3Cki/peerhub/internal/wal"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	opCreateOffer            = "create_offer"
+	opDeleteOffer            = "delete_offer"
+	opDeleteExpiredOffers    = "delete_expired_offers"
+	opCreateAnswer           = "create_answer"
+	opAnswerOffer            = "answer_offer"
+	opDeleteAnswer           = "delete_answer"
+	opDeleteExpiredAnswers   = "delete_expired_answers"
+	opCreateCandidate        = "create_candidate"
+	opPopCandidates          = "pop_candidates"
+	opCreateCooldown         = "create_cooldown"
+	opDeleteExpiredCooldowns = "delete_expired_cooldowns"
+	opCreateMail             = "create_mail"
+	opPopMail                = "pop_mail"
+	opDeleteExpiredMail      = "delete_expired_mail"
+)
+
+// FileService keeps signaling state in memory and persists every change to a write-ahead log in the data directory,
+// operations are logged with their arguments and replayed in the same order on start
+type FileService struct {
+	// mu keeps the order of logged changes the same as the order they were applied in
+	mu  sync.Mutex
+	mem *InMemoryService
+	log *wal.Log
+}
+
+type fileSnapshot struct {
+	Offers     []peerhub.Offer     `json:"offers"`
+	Answers    []peerhub.Answer    `json:"answers"`
+	Candidates []peerhub.Candidate `json:"candidates"`
+	Cooldowns  []peerhub.Cooldown  `json:"cooldowns"`
+	Mail       []peerhub.Mail      `json:"mail"`
+}
+
+type popCandidatesArgs struct {
+	OfferID  string `json:"offerid"`
+	PeerName string `json:"peername"`
+}
+
+type popMailArgs struct {
+	Role peerhub.PeerRole `json:"role"`
+	Name string           `json:"name"`
+}
+
+// NewFileService restores signaling state stored in dir
+func NewFileService(dir string) (*FileService, error) {
+	log, err := wal.Open(dir, "signal", wal.DefaultSnapshotEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileService{
+		mu:  sync.Mutex{},
+		mem: NewInMemoryService(),
+		log: log,
+	}
+
+	if err := log.Replay(s.restore, s.apply); err != nil {
+		log.Close()
+		return nil, fmt.Errorf("error replaying signal log: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *FileService) restore(state json.RawMessage) error {
+	snap := fileSnapshot{}
+	if err := json.Unmarshal(state, &snap); err != nil {
+		return err
+	}
+	for _, o := range snap.Offers {
+		s.mem.offers[o.ID] = o
+	}
+	for _, a := range snap.Answers {
+		s.mem.answers[a.ID] = a
+	}
+	for _, c := range snap.Candidates {
+		s.mem.candidates[c.OfferID] = append(s.mem.candidates[c.OfferID], c)
+	}
+	for _, c := range snap.Cooldowns {
+		s.mem.cooldowns[cooldownKey{op: c.OfferingPeer, ap: c.AnsweringPeer}] = c
+	}
+	for _, m := range snap.Mail {
+		k := mailKey{role: m.RecipientRole, name: m.Recipient}
+		s.mem.mail[k] = append(s.mem.mail[k], m)
+	}
+	return nil
+}
+
+func (s *FileService) snapshot() fileSnapshot {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	snap := fileSnapshot{
+		Offers:     maps.Values(s.mem.offers),
+		Answers:    maps.Values(s.mem.answers),
+		Candidates: []peerhub.Candidate{},
+		Cooldowns:  maps.Values(s.mem.cooldowns),
+		Mail:       []peerhub.Mail{},
+	}
+	for _, cands := range s.mem.candidates {
+		snap.Candidates = append(snap.Candidates, cands...)
+	}
+	for _, mails := range s.mem.mail {
+		snap.Mail = append(snap.Mail, mails...)
+	}
+	return snap
+}
+
+func (s *FileService) apply(rec wal.Record) error {
+	var err error
+	switch rec.Op {
+	case opCreateOffer:
+		o := peerhub.Offer{}
+		if err := json.Unmarshal(rec.Data, &o); err != nil {
+			return err
+		}
+		err = s.mem.CreateOffer(o)
+	case opDeleteOffer:
+		id := ""
+		if err := json.Unmarshal(rec.Data, &id); err != nil {
+			return err
+		}
+		err = s.mem.DeleteOffer(id)
+	case opDeleteExpiredOffers:
+		now := time.Time{}
+		if err := json.Unmarshal(rec.Data, &now); err != nil {
+			return err
+		}
+		_, err = s.mem.DeleteExpiredOffers(now)
+	case opCreateAnswer:
+		a := peerhub.Answer{}
+		if err := json.Unmarshal(rec.Data, &a); err != nil {
+			return err
+		}
+		err = s.mem.CreateAnswer(a)
+	case opAnswerOffer:
+		a := peerhub.Answer{}
+		if err := json.Unmarshal(rec.Data, &a); err != nil {
+			return err
+		}
+		_, err = s.mem.AnswerOffer(a)
+	case opDeleteAnswer:
+		id := ""
+		if err := json.Unmarshal(rec.Data, &id); err != nil {
+			return err
+		}
+		err = s.mem.DeleteAnswer(id)
+	case opDeleteExpiredAnswers:
+		now := time.Time{}
+		if err := json.Unmarshal(rec.Data, &now); err != nil {
+			return err
+		}
+		_, err = s.mem.DeleteExpiredAnswers(now)
+	case opCreateCandidate:
+		c := peerhub.Candidate{}
+		if err := json.Unmarshal(rec.Data, &c); err != nil {
+			return err
+		}
+		err = s.mem.CreateCandidate(c)
+	case opPopCandidates:
+		args := popCandidatesArgs{}
+		if err := json.Unmarshal(rec.Data, &args); err != nil {
+			return err
+		}
+		_, err = s.mem.PopCandidates(args.OfferID, args.PeerName)
+	case opCreateCooldown:
+		c := peerhub.Cooldown{}
+		if err := json.Unmarshal(rec.Data, &c); err != nil {
+			return err
+		}
+		err = s.mem.CreateCooldown(c)
+	case opDeleteExpiredCooldowns:
+		now := time.Time{}
+		if err := json.Unmarshal(rec.Data, &now); err != nil {
+			return err
+		}
+		_, err = s.mem.DeleteExpiredCooldowns(now)
+	case opCreateMail:
+		m := peerhub.Mail{}
+		if err := json.Unmarshal(rec.Data, &m); err != nil {
+			return err
+		}
+		err = s.mem.CreateMail(m)
+	case opPopMail:
+		args := popMailArgs{}
+		if err := json.Unmarshal(rec.Data, &args); err != nil {
+			return err
+		}
+		_, err = s.mem.PopMail(args.Role, args.Name)
+	case opDeleteExpiredMail:
+		now := time.Time{}
+		if err := json.Unmarshal(rec.Data, &now); err != nil {
+			return err
+		}
+		_, err = s.mem.DeleteExpiredMail(now)
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	return err
+}
+
+// write logs the change and applies it in memory once it's synced to disk, check reports whether the change
+// does anything and rejects changes the store wouldn't make, it must not change anything itself,
+// changes that do nothing are applied without being logged, the log is compacted once it grows large enough
+func (s *FileService) write(op string, data any, check func() (bool, error), apply func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed, err := check()
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return apply()
+	}
+
+	compact, err := s.log.Append(op, data)
+	if err != nil {
+		return fmt.Errorf("error logging %s: %w", op, err)
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	if !compact {
+		return nil
+	}
+
+	if err := s.log.Snapshot(s.snapshot()); err != nil {
+		// the change is already logged, the snapshot is retried with the next one
+		fmt.Println(fmt.Errorf("error snapshotting signal state: %w", err))
+	}
+
+	return nil
+}
+
+// peek reads the state in memory for checks of changes
+func (s *FileService) peek(f func() bool) bool {
+	s.mem.mu.Lock()
+	defer s.mem.mu.Unlock()
+	return f()
+}
+
+func always() (bool, error) { return true, nil }
+
+func (s *FileService) Close() error {
+	return s.log.Close()
+}
+
+func (s *FileService) CreateOffer(o peerhub.Offer) error {
+	return s.write(opCreateOffer, o, always, func() error {
+		return s.mem.CreateOffer(o)
+	})
+}
+
+func (s *FileService) GetOffer(offerID string) (peerhub.Offer, error) {
+	return s.mem.GetOffer(offerID)
+}
+
+func (s *FileService) GetOffers() ([]peerhub.Offer, error) {
+	return s.mem.GetOffers()
+}
+
+func (s *FileService) GetOffersByOfferingPeer(name string) ([]peerhub.Offer, error) {
+	return s.mem.GetOffersByOfferingPeer(name)
+}
+
+func (s *FileService) GetOffersByAnsweringPeer(name string) ([]peerhub.Offer, error) {
+	return s.mem.GetOffersByAnsweringPeer(name)
+}
+
+func (s *FileService) DeleteOffer(offerID string) error {
+	return s.write(opDeleteOffer, offerID, always, func() error {
+		return s.mem.DeleteOffer(offerID)
+	})
+}
+
+func (s *FileService) DeleteExpiredOffers(now time.Time) (expired []peerhub.Offer, err error) {
+	err = s.write(opDeleteExpiredOffers, now, func() (bool, error) {
+		return s.peek(func() bool {
+			for _, o := range s.mem.offers {
+				if o.Expired(now) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}, func() error {
+		expired, err = s.mem.DeleteExpiredOffers(now)
+		return err
+	})
+	return expired, err
+}
+
+func (s *FileService) CreateAnswer(a peerhub.Answer) error {
+	return s.write(opCreateAnswer, a, always, func() error {
+		return s.mem.CreateAnswer(a)
+	})
+}
+
+func (s *FileService) AnswerOffer(a peerhub.Answer) (offer peerhub.Offer, err error) {
+	err = s.write(opAnswerOffer, a, func() (bool, error) {
+		o, err := s.mem.GetOffer(a.OfferID)
+		if err != nil {
+			return false, err
+		}
+		if o.State != peerhub.OfferStatePending {
+			return false, peerhub.ErrOfferAlreadyAnswered
+		}
+		return true, nil
+	}, func() error {
+		offer, err = s.mem.AnswerOffer(a)
+		return err
+	})
+	return offer, err
+}
+
+func (s *FileService) GetAnswer(answerID string) (peerhub.Answer, error) {
+	return s.mem.GetAnswer(answerID)
+}
+
+func (s *FileService) GetAnswersByOffer(offerID string) ([]peerhub.Answer, error) {
+	return s.mem.GetAnswersByOffer(offerID)
+}
+
+func (s *FileService) DeleteAnswer(answerID string) error {
+	return s.write(opDeleteAnswer, answerID, always, func() error {
+		return s.mem.DeleteAnswer(answerID)
+	})
+}
+
+func (s *FileService) DeleteExpiredAnswers(now time.Time) (expired []peerhub.Answer, err error) {
+	err = s.write(opDeleteExpiredAnswers, now, func() (bool, error) {
+		return s.peek(func() bool {
+			for _, a := range s.mem.answers {
+				if a.Expired(now) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}, func() error {
+		expired, err = s.mem.DeleteExpiredAnswers(now)
+		return err
+	})
+	return expired, err
+}
+
+func (s *FileService) CreateCandidate(c peerhub.Candidate) error {
+	return s.write(opCreateCandidate, c, func() (bool, error) {
+		_, err := s.mem.GetOffer(c.OfferID)
+		return err == nil, err
+	}, func() error {
+		return s.mem.CreateCandidate(c)
+	})
+}
+
+func (s *FileService) PopCandidates(offerID, peerName string) (popped []peerhub.Candidate, err error) {
+	args := popCandidatesArgs{OfferID: offerID, PeerName: peerName}
+	err = s.write(opPopCandidates, args, func() (bool, error) {
+		return s.peek(func() bool {
+			for _, c := range s.mem.candidates[offerID] {
+				if c.To == peerName {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}, func() error {
+		popped, err = s.mem.PopCandidates(offerID, peerName)
+		return err
+	})
+	return popped, err
+}
+
+func (s *FileService) CreateCooldown(c peerhub.Cooldown) error {
+	return s.write(opCreateCooldown, c, always, func() error {
+		return s.mem.CreateCooldown(c)
+	})
+}
+
+func (s *FileService) GetCooldown(opName, apName string) (peerhub.Cooldown, error) {
+	return s.mem.GetCooldown(opName, apName)
+}
+
+func (s *FileService) DeleteExpiredCooldowns(now time.Time) (expired []peerhub.Cooldown, err error) {
+	err = s.write(opDeleteExpiredCooldowns, now, func() (bool, error) {
+		return s.peek(func() bool {
+			for _, c := range s.mem.cooldowns {
+				if c.Expired(now) {
+					return true
+				}
+			}
+			return false
+		}), nil
+	}, func() error {
+		expired, err = s.mem.DeleteExpiredCooldowns(now)
+		return err
+	})
+	return expired, err
+}
+
+func (s *FileService) CreateMail(m peerhub.Mail) error {
+	return s.write(opCreateMail, m, always, func() error {
+		return s.mem.CreateMail(m)
+	})
+}
+
+func (s *FileService) PopMail(role peerhub.PeerRole, name string) (mails []peerhub.Mail, err error) {
+	args := popMailArgs{Role: role, Name: name}
+	err = s.write(opPopMail, args, func() (bool, error) {
+		return s.peek(func() bool {
+			return len(s.mem.mail[mailKey{role: role, name: name}]) > 0
+		}), nil
+	}, func() error {
+		mails, err = s.mem.PopMail(role, name)
+		return err
+	})
+	return mails, err
+}
+
+func (s *FileService) DeleteExpiredMail(now time.Time) (expired []peerhub.Mail, err error) {
+	err = s.write(opDeleteExpiredMail, now, func() (bool, error) {
+		return s.peek(func() bool {
+			for _, mails := range s.mem.mail {
+				for _, m := range mails {
+					if m.Expired(now) {
+						return true
+					}
+				}
+			}
+			return false
+		}), nil
+	}, func() error {
+		expired, err = s.mem.DeleteExpiredMail(now)
+		return err
+	})
+	return expired, err
+}
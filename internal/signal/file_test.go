@@ -0,0 +1,152 @@
+package sig
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func TestFileServiceRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	if err := s.CreateOffer(o); err != nil {
+		t.Fatal(err)
+	}
+	a := peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)
+	if _, err := s.AnswerOffer(a); err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"ap", "ap", "op"} {
+		if err := s.CreateCandidate(peerhub.Candidate{OfferID: o.ID, To: to}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if popped, _ := s.PopCandidates(o.ID, "ap"); len(popped) != 2 {
+		t.Fatalf("popped %d candidates, want 2", len(popped))
+	}
+	s.Close()
+
+	s, err = NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	restored, err := s.GetOffer(o.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.State != peerhub.OfferStateAnswered || restored.AnswerID != a.ID {
+		t.Errorf("restored offer %+v, want it answered with %s", restored, a.ID)
+	}
+	if _, err := s.GetAnswer(a.ID); err != nil {
+		t.Errorf("answer wasn't restored: %v", err)
+	}
+	if popped, _ := s.PopCandidates(o.ID, "ap"); len(popped) != 0 {
+		t.Errorf("popped candidates were restored: %+v", popped)
+	}
+	if popped, _ := s.PopCandidates(o.ID, "op"); len(popped) != 1 {
+		t.Errorf("restored %d candidates of op, want 1", len(popped))
+	}
+}
+
+func TestFileServiceAnswerOffer(t *testing.T) {
+	s, err := NewFileService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a := peerhub.NewAnswer("missing", "op", "ap", "sdp", time.Minute)
+	if _, err := s.AnswerOffer(a); !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("answered a missing offer, err %v", err)
+	}
+	if _, err := s.GetAnswer(a.ID); !errors.Is(err, peerhub.ErrAnswerNotFound) {
+		t.Errorf("answer of a missing offer was stored, err %v", err)
+	}
+
+	o := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	if err := s.CreateOffer(o); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AnswerOffer(peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AnswerOffer(peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)); !errors.Is(err, peerhub.ErrOfferAlreadyAnswered) {
+		t.Errorf("answered the offer twice, err %v", err)
+	}
+}
+
+func TestFileServiceDeletesExpired(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expired := peerhub.NewOffer("op", "sdp", "ap", time.Second)
+	kept := peerhub.NewOffer("op", "sdp", "ap", time.Hour)
+	s.CreateOffer(expired)
+	s.CreateOffer(kept)
+	s.CreateCooldown(peerhub.Cooldown{OfferingPeer: "op", AnsweringPeer: "ap", ExpiresAt: now})
+	s.CreateMail(peerhub.Mail{ID: "m", Recipient: "ap", ExpiresAt: now})
+
+	later := now.Add(time.Minute)
+	if offers, _ := s.DeleteExpiredOffers(later); len(offers) != 1 || offers[0].ID != expired.ID {
+		t.Errorf("deleted offers %+v, want %s", offers, expired.ID)
+	}
+	if cooldowns, _ := s.DeleteExpiredCooldowns(later); len(cooldowns) != 1 {
+		t.Errorf("deleted %d cooldowns, want 1", len(cooldowns))
+	}
+	if mails, _ := s.DeleteExpiredMail(later); len(mails) != 1 {
+		t.Errorf("deleted %d mails, want 1", len(mails))
+	}
+	if offers, _ := s.DeleteExpiredOffers(later); len(offers) != 0 {
+		t.Errorf("deleted offers twice: %+v", offers)
+	}
+	s.Close()
+
+	s, err = NewFileService(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.GetOffer(expired.ID); !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("expired offer was restored, err %v", err)
+	}
+	if _, err := s.GetOffer(kept.ID); err != nil {
+		t.Errorf("offer wasn't restored: %v", err)
+	}
+	if _, err := s.GetCooldown("op", "ap"); !errors.Is(err, peerhub.ErrCooldownNotFound) {
+		t.Errorf("expired cooldown was restored, err %v", err)
+	}
+}
+
+func TestFileServiceDoesNotApplyUnloggedChanges(t *testing.T) {
+	s, err := NewFileService(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := peerhub.NewOffer("op", "sdp", "ap", time.Minute)
+	if err := s.CreateOffer(o); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := s.AnswerOffer(peerhub.NewAnswer(o.ID, "op", "ap", "sdp", time.Minute)); err == nil {
+		t.Fatal("answered an offer without a log")
+	}
+	restored, _ := s.GetOffer(o.ID)
+	if restored.State != peerhub.OfferStatePending {
+		t.Errorf("offer answered without a log is %s", restored.State)
+	}
+}
@@ -2,21 +2,39 @@ package sig
 
 import (
 	"sync"
+	"time"
 
 	"github.com/H3Cki/peerhub"
+	"golang.org/x/exp/maps"
 )
 
 type InMemoryService struct {
-	mu      sync.Mutex
-	offers  map[string]peerhub.Offer
-	answers map[string]peerhub.Answer
+	mu         sync.Mutex
+	offers     map[string]peerhub.Offer
+	answers    map[string]peerhub.Answer
+	candidates map[string][]peerhub.Candidate
+	cooldowns  map[cooldownKey]peerhub.Cooldown
+	mail       map[mailKey][]peerhub.Mail
+}
+
+type mailKey struct {
+	role peerhub.PeerRole
+	name string
+}
+
+type cooldownKey struct {
+	op string
+	ap string
 }
 
 func NewInMemoryService() *InMemoryService {
 	return &InMemoryService{
-		mu:      sync.Mutex{},
-		offers:  map[string]peerhub.Offer{},
-		answers: map[string]peerhub.Answer{},
+		mu:         sync.Mutex{},
+		offers:     map[string]peerhub.Offer{},
+		answers:    map[string]peerhub.Answer{},
+		candidates: map[string][]peerhub.Candidate{},
+		cooldowns:  map[cooldownKey]peerhub.Cooldown{},
+		mail:       map[mailKey][]peerhub.Mail{},
 	}
 }
 
@@ -37,13 +55,58 @@ func (s *InMemoryService) GetOffer(offerID string) (peerhub.Offer, error) {
 	return o, nil
 }
 
+func (s *InMemoryService) GetOffers() ([]peerhub.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Values(s.offers), nil
+}
+
+func (s *InMemoryService) GetOffersByOfferingPeer(name string) ([]peerhub.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offers := []peerhub.Offer{}
+	for _, o := range s.offers {
+		if o.OfferingPeer == name {
+			offers = append(offers, o)
+		}
+	}
+	return offers, nil
+}
+
+func (s *InMemoryService) GetOffersByAnsweringPeer(name string) ([]peerhub.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offers := []peerhub.Offer{}
+	for _, o := range s.offers {
+		if o.AnsweringPeer == name {
+			offers = append(offers, o)
+		}
+	}
+	return offers, nil
+}
+
 func (s *InMemoryService) DeleteOffer(offerID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.offers, offerID)
+	delete(s.candidates, offerID)
 	return nil
 }
 
+func (s *InMemoryService) DeleteExpiredOffers(now time.Time) ([]peerhub.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := []peerhub.Offer{}
+	for id, o := range s.offers {
+		if o.Expired(now) {
+			expired = append(expired, o)
+			delete(s.offers, id)
+			delete(s.candidates, id)
+		}
+	}
+	return expired, nil
+}
+
 func (s *InMemoryService) CreateAnswer(a peerhub.Answer) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -51,6 +114,23 @@ func (s *InMemoryService) CreateAnswer(a peerhub.Answer) error {
 	return nil
 }
 
+func (s *InMemoryService) AnswerOffer(a peerhub.Answer) (peerhub.Offer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.offers[a.OfferID]
+	if !ok {
+		return peerhub.Offer{}, peerhub.ErrOfferNotFound
+	}
+	if o.State != peerhub.OfferStatePending {
+		return peerhub.Offer{}, peerhub.ErrOfferAlreadyAnswered
+	}
+	o.State = peerhub.OfferStateAnswered
+	o.AnswerID = a.ID
+	s.offers[o.ID] = o
+	s.answers[a.ID] = a
+	return o, nil
+}
+
 func (s *InMemoryService) GetAnswer(answerID string) (peerhub.Answer, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -61,9 +141,136 @@ func (s *InMemoryService) GetAnswer(answerID string) (peerhub.Answer, error) {
 	return a, nil
 }
 
+func (s *InMemoryService) GetAnswersByOffer(offerID string) ([]peerhub.Answer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answers := []peerhub.Answer{}
+	for _, a := range s.answers {
+		if a.OfferID == offerID {
+			answers = append(answers, a)
+		}
+	}
+	return answers, nil
+}
+
 func (s *InMemoryService) DeleteAnswer(answerID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	delete(s.answers, answerID)
 	return nil
 }
+
+func (s *InMemoryService) DeleteExpiredAnswers(now time.Time) ([]peerhub.Answer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := []peerhub.Answer{}
+	for id, a := range s.answers {
+		if a.Expired(now) {
+			expired = append(expired, a)
+			delete(s.answers, id)
+		}
+	}
+	return expired, nil
+}
+
+func (s *InMemoryService) CreateCandidate(c peerhub.Candidate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.offers[c.OfferID]; !ok {
+		return peerhub.ErrOfferNotFound
+	}
+	s.candidates[c.OfferID] = append(s.candidates[c.OfferID], c)
+	return nil
+}
+
+func (s *InMemoryService) PopCandidates(offerID, peerName string) ([]peerhub.Candidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	popped := []peerhub.Candidate{}
+	kept := []peerhub.Candidate{}
+	for _, c := range s.candidates[offerID] {
+		if c.To == peerName {
+			popped = append(popped, c)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	if len(kept) == 0 {
+		delete(s.candidates, offerID)
+	} else {
+		s.candidates[offerID] = kept
+	}
+	return popped, nil
+}
+
+func (s *InMemoryService) CreateCooldown(c peerhub.Cooldown) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cooldowns[cooldownKey{op: c.OfferingPeer, ap: c.AnsweringPeer}] = c
+	return nil
+}
+
+func (s *InMemoryService) GetCooldown(opName, apName string) (peerhub.Cooldown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cooldowns[cooldownKey{op: opName, ap: apName}]
+	if !ok {
+		return peerhub.Cooldown{}, peerhub.ErrCooldownNotFound
+	}
+	return c, nil
+}
+
+func (s *InMemoryService) DeleteExpiredCooldowns(now time.Time) ([]peerhub.Cooldown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := []peerhub.Cooldown{}
+	for k, c := range s.cooldowns {
+		if c.Expired(now) {
+			expired = append(expired, c)
+			delete(s.cooldowns, k)
+		}
+	}
+	return expired, nil
+}
+
+func (s *InMemoryService) CreateMail(m peerhub.Mail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := mailKey{role: m.RecipientRole, name: m.Recipient}
+	s.mail[k] = append(s.mail[k], m)
+	return nil
+}
+
+func (s *InMemoryService) PopMail(role peerhub.PeerRole, name string) ([]peerhub.Mail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := mailKey{role: role, name: name}
+	mails, ok := s.mail[k]
+	if !ok {
+		return []peerhub.Mail{}, nil
+	}
+	delete(s.mail, k)
+	return mails, nil
+}
+
+func (s *InMemoryService) DeleteExpiredMail(now time.Time) ([]peerhub.Mail, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expired := []peerhub.Mail{}
+	for k, mails := range s.mail {
+		kept := []peerhub.Mail{}
+		for _, m := range mails {
+			if m.Expired(now) {
+				expired = append(expired, m)
+				continue
+			}
+			kept = append(kept, m)
+		}
+		if len(kept) == 0 {
+			delete(s.mail, k)
+		} else {
+			s.mail[k] = kept
+		}
+	}
+	return expired, nil
+}
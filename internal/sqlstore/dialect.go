@@ -0,0 +1,84 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts queries, written with ? placeholders, and migrations to the database behind the driver
+type Dialect struct {
+	Name string
+	// Placeholder returns the n-th (starting at 1) bind parameter
+	Placeholder func(n int) string
+	// Types are the column types migrations are written with
+	Types ColumnTypes
+	// TransactionalDDL is false for databases committing schema changes implicitly,
+	// migrations of those aren't atomic and one failing half way has to be finished by hand
+	TransactionalDDL bool
+}
+
+// ColumnTypes are names of column types of the database, migrations refer to them as {{.Name}}
+type ColumnTypes struct {
+	// Key is a string short enough to be indexed
+	Key string
+	// Text is a string of any length, it's never indexed
+	Text string
+	Bool string
+	// Int is a 64-bit integer
+	Int string
+}
+
+var (
+	Postgres = Dialect{
+		Name:             "postgres",
+		Placeholder:      func(n int) string { return "$" + strconv.Itoa(n) },
+		Types:            ColumnTypes{Key: "VARCHAR(255)", Text: "TEXT", Bool: "BOOLEAN", Int: "BIGINT"},
+		TransactionalDDL: true,
+	}
+	// MySQL's TEXT holds up to 64KiB, which SDPs with many candidates can exceed
+	MySQL = Dialect{
+		Name:             "mysql",
+		Placeholder:      func(int) string { return "?" },
+		Types:            ColumnTypes{Key: "VARCHAR(255)", Text: "MEDIUMTEXT", Bool: "BOOLEAN", Int: "BIGINT"},
+		TransactionalDDL: false,
+	}
+	SQLite = Dialect{
+		Name:             "sqlite",
+		Placeholder:      func(int) string { return "?" },
+		Types:            ColumnTypes{Key: "TEXT", Text: "TEXT", Bool: "INTEGER", Int: "INTEGER"},
+		TransactionalDDL: true,
+	}
+)
+
+// DialectFor returns the dialect of the database/sql driver name
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "postgres", "pgx":
+		return Postgres, nil
+	case "mysql":
+		return MySQL, nil
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	}
+	return Dialect{}, fmt.Errorf("no sql dialect for driver %q", driver)
+}
+
+// Rebind replaces ? placeholders of the query with the dialect's ones
+func (d Dialect) Rebind(query string) string {
+	if d.Placeholder == nil {
+		return query
+	}
+
+	b := strings.Builder{}
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString(d.Placeholder(n))
+	}
+	return b.String()
+}
@@ -0,0 +1,176 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeResult is what the fake database answers a statement with
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// fakeHandler answers statements, queries have their whitespace collapsed
+type fakeHandler func(query string, args []driver.Value) fakeResult
+
+// fakeDB is a database of the fake driver, it records statements and transaction boundaries in order
+type fakeDB struct {
+	mu     sync.Mutex
+	handle fakeHandler
+	log    []string
+	args   [][]driver.Value
+}
+
+var fakeDBs sync.Map
+
+func init() {
+	sql.Register("sqlstore_fake", fakeDriver{})
+}
+
+// openFake opens a database of the fake driver answering statements with handle
+func openFake(t *testing.T, handle fakeHandler) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fdb := &fakeDB{handle: handle}
+	fakeDBs.Store(t.Name(), fdb)
+	t.Cleanup(func() { fakeDBs.Delete(t.Name()) })
+
+	db, err := sql.Open("sqlstore_fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fdb
+}
+
+func (db *fakeDB) run(query string, named []driver.NamedValue) fakeResult {
+	query = strings.Join(strings.Fields(query), " ")
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+
+	db.mu.Lock()
+	db.log = append(db.log, query)
+	db.args = append(db.args, args)
+	db.mu.Unlock()
+
+	if db.handle == nil {
+		return fakeResult{}
+	}
+	return db.handle(query, args)
+}
+
+func (db *fakeDB) record(event string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, event)
+	db.args = append(db.args, nil)
+}
+
+// statements returns logged statements starting with the prefix along their arguments
+func (db *fakeDB) statements(prefix string) ([]string, [][]driver.Value) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stmts, args := []string{}, [][]driver.Value{}
+	for i, q := range db.log {
+		if strings.HasPrefix(q, prefix) {
+			stmts = append(stmts, q)
+			args = append(args, db.args[i])
+		}
+	}
+	return stmts, args
+}
+
+func (db *fakeDB) logged(event string) bool {
+	stmts, _ := db.statements(event)
+	return len(stmts) > 0
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(name)
+	if !ok {
+		return nil, errors.New("no fake database " + name)
+	}
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return fakeTx{db: c.db}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.db.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &fakeRows{columns: res.columns, rows: res.rows}, nil
+}
+
+type fakeTx struct {
+	db *fakeDB
+}
+
+func (tx fakeTx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// rows returns a result of rows of the columns
+func rows(columns string, values ...[]driver.Value) fakeResult {
+	return fakeResult{columns: strings.Split(columns, ", "), rows: values}
+}
@@ -0,0 +1,156 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ErrPartialMigration is returned when a migration of a database without transactional DDL fails after
+// some of its statements were applied, the rest of it has to be applied by hand before it's recorded
+var ErrPartialMigration = errors.New("migration partially applied")
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// Migrate applies migrations that were not applied to the database yet, each one in its own transaction
+// if the dialect supports transactional DDL, migration files are named <version>_<name>.sql
+// and are templates of the dialect's column types
+func Migrate(ctx context.Context, db *sql.DB, d Dialect) (int, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS peerhub_migrations (version `+d.Types.Int+` NOT NULL PRIMARY KEY)`); err != nil {
+		return 0, fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	ms, err := loadMigrations(d)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range ms {
+		if applied[m.version] {
+			continue
+		}
+		if err := applyMigration(ctx, db, d, m); err != nil {
+			return n, fmt.Errorf("error applying migration %s: %w", m.name, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM peerhub_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		v := 0
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func loadMigrations(d Dialect) ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	ms := []migration{}
+	for _, e := range entries {
+		version, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name %q", e.Name())
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration name %q: %w", e.Name(), err)
+		}
+
+		tmpl, err := template.ParseFS(migrations, "migrations/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		script := strings.Builder{}
+		if err := tmpl.Execute(&script, d.Types); err != nil {
+			return nil, fmt.Errorf("error rendering migration %s: %w", e.Name(), err)
+		}
+
+		ms = append(ms, migration{version: v, name: e.Name(), stmts: splitStatements(script.String())})
+	}
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].version < ms[j].version })
+	return ms, nil
+}
+
+// splitStatements splits the script on semicolons, not every driver executes multiple statements at once
+func splitStatements(script string) []string {
+	stmts := []string{}
+	for _, stmt := range strings.Split(script, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, d Dialect, m migration) error {
+	if !d.TransactionalDDL {
+		return applyMigrationStatements(ctx, db, d, m)
+	}
+
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		for _, stmt := range m.stmts {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, d.Rebind(`INSERT INTO peerhub_migrations (version) VALUES (?)`), m.version)
+		return err
+	})
+}
+
+// applyMigrationStatements applies the migration one statement at a time, for databases which would commit
+// a transaction with every statement anyway
+func applyMigrationStatements(ctx context.Context, db *sql.DB, d Dialect, m migration) error {
+	for i, stmt := range m.stmts {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			if i == 0 {
+				return err
+			}
+			return fmt.Errorf("%w, %d of %d statements were applied: %w", ErrPartialMigration, i, len(m.stmts), err)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, d.Rebind(`INSERT INTO peerhub_migrations (version) VALUES (?)`), m.version); err != nil {
+		return fmt.Errorf("%w, all statements were applied but not recorded: %w", ErrPartialMigration, err)
+	}
+	return nil
+}
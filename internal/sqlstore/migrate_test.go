@@ -0,0 +1,127 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// migrationsApplied answers the query of applied migrations with the versions
+func migrationsApplied(versions ...int64) fakeHandler {
+	return func(query string, _ []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "SELECT version FROM peerhub_migrations") {
+			res := rows("version")
+			for _, v := range versions {
+				res.rows = append(res.rows, []driver.Value{v})
+			}
+			return res
+		}
+		return fakeResult{}
+	}
+}
+
+func TestMigrationsRender(t *testing.T) {
+	for _, d := range []Dialect{Postgres, MySQL, SQLite} {
+		ms, err := loadMigrations(d)
+		if err != nil {
+			t.Fatalf("%s: %v", d.Name, err)
+		}
+		if len(ms) == 0 {
+			t.Fatalf("%s: no migrations", d.Name)
+		}
+		for i, m := range ms {
+			if i > 0 && m.version <= ms[i-1].version {
+				t.Errorf("%s: migration %s is out of order", d.Name, m.name)
+			}
+			for _, stmt := range m.stmts {
+				if strings.Contains(stmt, "{{") {
+					t.Errorf("%s: statement of %s isn't rendered: %s", d.Name, m.name, stmt)
+				}
+			}
+		}
+	}
+}
+
+func TestMigrateAppliesPendingMigrations(t *testing.T) {
+	db, fdb := openFake(t, migrationsApplied(1, 3))
+
+	n, err := Migrate(context.Background(), db, Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("applied %d migrations, want 1", n)
+	}
+
+	if fdb.logged("CREATE TABLE answering_peers") {
+		t.Error("applied migration was applied again")
+	}
+	alters, _ := fdb.statements("ALTER TABLE")
+	if len(alters) != 2 || !strings.Contains(alters[0], "BIGINT") {
+		t.Errorf("ran %q, want both statements of migration 2 with postgres types", alters)
+	}
+	_, args := fdb.statements("INSERT INTO peerhub_migrations")
+	if len(args) != 1 || args[0][0] != int64(2) {
+		t.Errorf("recorded migrations %v, want version 2", args)
+	}
+	if !fdb.logged("COMMIT") {
+		t.Error("migration wasn't committed")
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	failure := errors.New("alter failed")
+	db, fdb := openFake(t, func(query string, args []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "ALTER TABLE offering_peers") {
+			return fakeResult{err: failure}
+		}
+		return migrationsApplied(1)(query, args)
+	})
+
+	n, err := Migrate(context.Background(), db, Postgres)
+	if !errors.Is(err, failure) {
+		t.Fatalf("migrate returned %v, want the failure", err)
+	}
+	if n != 0 {
+		t.Errorf("applied %d migrations, want 0", n)
+	}
+	if fdb.logged("INSERT INTO peerhub_migrations") {
+		t.Error("failed migration was recorded")
+	}
+	if !fdb.logged("ROLLBACK") || fdb.logged("COMMIT") {
+		t.Error("failed migration wasn't rolled back")
+	}
+}
+
+func TestMigrateWithoutTransactionalDDL(t *testing.T) {
+	failure := errors.New("alter failed")
+	db, fdb := openFake(t, func(query string, args []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "ALTER TABLE offering_peers") {
+			return fakeResult{err: failure}
+		}
+		return migrationsApplied(1)(query, args)
+	})
+
+	_, err := Migrate(context.Background(), db, MySQL)
+	if !errors.Is(err, ErrPartialMigration) || !errors.Is(err, failure) {
+		t.Fatalf("migrate returned %v, want a partial migration", err)
+	}
+	if fdb.logged("BEGIN") {
+		t.Error("mysql migration ran in a transaction")
+	}
+	if fdb.logged("INSERT INTO peerhub_migrations") {
+		t.Error("partial migration was recorded")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	q := `SELECT a FROM t WHERE b = ? AND c = ?`
+	if got := Postgres.Rebind(q); got != `SELECT a FROM t WHERE b = $1 AND c = $2` {
+		t.Errorf("postgres rebound %q", got)
+	}
+	if got := MySQL.Rebind(q); got != q {
+		t.Errorf("mysql rebound %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+CREATE TABLE answering_peers (
+	name {{.Key}} NOT NULL PRIMARY KEY,
+	access_keys {{.Text}} NOT NULL,
+	management_key {{.Text}} NOT NULL,
+	online {{.Bool}} NOT NULL
+);
+
+CREATE TABLE offering_peers (
+	name {{.Key}} NOT NULL PRIMARY KEY,
+	target_name {{.Key}} NOT NULL,
+	target_access_key {{.Text}} NOT NULL,
+	management_key {{.Text}} NOT NULL,
+	sdp {{.Text}} NOT NULL,
+	del {{.Bool}} NOT NULL,
+	ignore_not_found {{.Bool}} NOT NULL,
+	online {{.Bool}} NOT NULL
+);
+
+CREATE INDEX offering_peers_target_name ON offering_peers (target_name);
+
+CREATE TABLE offers (
+	id {{.Key}} NOT NULL PRIMARY KEY,
+	offering_peer {{.Key}} NOT NULL,
+	answering_peer {{.Key}} NOT NULL,
+	sdp {{.Text}} NOT NULL,
+	state {{.Key}} NOT NULL,
+	answer_id {{.Key}} NOT NULL,
+	created_at {{.Int}} NOT NULL,
+	expires_at {{.Int}} NOT NULL
+);
+
+CREATE INDEX offers_offering_peer ON offers (offering_peer);
+CREATE INDEX offers_answering_peer ON offers (answering_peer);
+CREATE INDEX offers_expires_at ON offers (expires_at);
+
+CREATE TABLE answers (
+	id {{.Key}} NOT NULL PRIMARY KEY,
+	offer_id {{.Key}} NOT NULL,
+	answering_peer {{.Key}} NOT NULL,
+	sdp {{.Text}} NOT NULL,
+	created_at {{.Int}} NOT NULL,
+	expires_at {{.Int}} NOT NULL
+);
+
+CREATE INDEX answers_offer_id ON answers (offer_id);
+CREATE INDEX answers_expires_at ON answers (expires_at);
+
+CREATE TABLE candidates (
+	id {{.Key}} NOT NULL PRIMARY KEY,
+	offer_id {{.Key}} NOT NULL,
+	recipient {{.Key}} NOT NULL,
+	created_at {{.Int}} NOT NULL,
+	data {{.Text}} NOT NULL
+);
+
+CREATE INDEX candidates_offer_id ON candidates (offer_id, recipient);
+
+CREATE TABLE cooldowns (
+	offering_peer {{.Key}} NOT NULL,
+	answering_peer {{.Key}} NOT NULL,
+	expires_at {{.Int}} NOT NULL,
+	PRIMARY KEY (offering_peer, answering_peer)
+);
+
+CREATE TABLE mail (
+	id {{.Key}} NOT NULL PRIMARY KEY,
+	recipient_role {{.Key}} NOT NULL,
+	recipient {{.Key}} NOT NULL,
+	created_at {{.Int}} NOT NULL,
+	expires_at {{.Int}} NOT NULL,
+	data {{.Text}} NOT NULL
+);
+
+CREATE INDEX mail_recipient ON mail (recipient_role, recipient);
+CREATE INDEX mail_expires_at ON mail (expires_at);
@@ -0,0 +1,3 @@
+ALTER TABLE answering_peers ADD COLUMN version {{.Int}} NOT NULL DEFAULT 0;
+
+ALTER TABLE offering_peers ADD COLUMN version {{.Int}} NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE answers ADD COLUMN offering_peer {{.Key}} NOT NULL DEFAULT '';
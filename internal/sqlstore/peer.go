@@ -0,0 +1,219 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/H3Cki/peerhub"
+)
+
+type PeerService struct {
+	db *sql.DB
+	d  Dialect
+}
+
+// NewPeerService returns peer service backed by the database, the schema has to be migrated with Migrate
+func NewPeerService(db *sql.DB, d Dialect) *PeerService {
+	return &PeerService{db: db, d: d}
+}
+
+const (
+	answeringPeerColumns = `name, access_keys, management_key, online, version`
+	offeringPeerColumns  = `name, target_name, target_access_key, management_key, sdp, del, ignore_not_found, online, version`
+)
+
+func scanAnsweringPeer(row scanner) (peerhub.AnsweringPeer, error) {
+	ap := peerhub.AnsweringPeer{}
+	accessKeys := ""
+	if err := row.Scan(&ap.Name, &accessKeys, &ap.ManagementKey, &ap.Online, &ap.Version); err != nil {
+		return peerhub.AnsweringPeer{}, err
+	}
+	if err := json.Unmarshal([]byte(accessKeys), &ap.AccessKeys); err != nil {
+		return peerhub.AnsweringPeer{}, err
+	}
+	return ap, nil
+}
+
+func scanOfferingPeer(row scanner) (peerhub.OfferingPeer, error) {
+	op := peerhub.OfferingPeer{}
+	err := row.Scan(&op.Name, &op.TargetName, &op.TargetAccessKey, &op.ManagementKey, &op.SDP, &op.Delete, &op.IgnoreNotFound, &op.Online, &op.Version)
+	return op, err
+}
+
+func (s *PeerService) GetAnsweringPeers(ctx context.Context) ([]peerhub.AnsweringPeer, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+answeringPeerColumns+` FROM answering_peers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aps := []peerhub.AnsweringPeer{}
+	for rows.Next() {
+		ap, err := scanAnsweringPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+		aps = append(aps, ap)
+	}
+	return aps, rows.Err()
+}
+
+// CreateAnsweringPeer creates the answering peer, the primary key keeps concurrent creates from both succeeding
+func (s *PeerService) CreateAnsweringPeer(ctx context.Context, ap peerhub.AnsweringPeer) error {
+	accessKeys, err := json.Marshal(ap.AccessKeys)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.d.Rebind(`INSERT INTO answering_peers (`+answeringPeerColumns+`) VALUES (?, ?, ?, ?, 1)`),
+		ap.Name, string(accessKeys), ap.ManagementKey, ap.Online)
+	if err == nil {
+		return nil
+	}
+
+	// drivers report key violations differently, a peer stored under the name tells it apart from other errors
+	if _, getErr := s.GetAnsweringPeer(ctx, ap.Name); getErr == nil {
+		return peerhub.ErrAnsweringPeerAlreadyExists
+	}
+	return err
+}
+
+// UpdateAnsweringPeer replaces the answering peer if it's still at the version of ap
+func (s *PeerService) UpdateAnsweringPeer(ctx context.Context, ap peerhub.AnsweringPeer) error {
+	accessKeys, err := json.Marshal(ap.AccessKeys)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, s.d.Rebind(`UPDATE answering_peers SET access_keys = ?, management_key = ?, online = ?, version = version + 1 WHERE name = ? AND version = ?`),
+		string(accessKeys), ap.ManagementKey, ap.Online, ap.Name, ap.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil || updated > 0 {
+		return err
+	}
+
+	if _, err := s.GetAnsweringPeer(ctx, ap.Name); err != nil {
+		return err
+	}
+	return peerhub.ErrAnsweringPeerConflict
+}
+
+func (s *PeerService) GetAnsweringPeer(ctx context.Context, name string) (peerhub.AnsweringPeer, error) {
+	row := s.db.QueryRowContext(ctx, s.d.Rebind(`SELECT `+answeringPeerColumns+` FROM answering_peers WHERE name = ?`), name)
+	ap, err := scanAnsweringPeer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return peerhub.AnsweringPeer{}, peerhub.ErrAnsweringPeerNotFound
+	}
+	return ap, err
+}
+
+// DeleteAnsweringPeer deletes the answering peer if it's still at the version of ap
+func (s *PeerService) DeleteAnsweringPeer(ctx context.Context, ap peerhub.AnsweringPeer) error {
+	res, err := s.db.ExecContext(ctx, s.d.Rebind(`DELETE FROM answering_peers WHERE name = ? AND version = ?`), ap.Name, ap.Version)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil || deleted > 0 {
+		return err
+	}
+
+	if _, err := s.GetAnsweringPeer(ctx, ap.Name); err != nil {
+		return err
+	}
+	return peerhub.ErrAnsweringPeerConflict
+}
+
+// CreateOfferingPeer creates the offering peer, the primary key keeps concurrent creates from both succeeding
+func (s *PeerService) CreateOfferingPeer(ctx context.Context, op peerhub.OfferingPeer) error {
+	_, err := s.db.ExecContext(ctx, s.d.Rebind(`INSERT INTO offering_peers (`+offeringPeerColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)`),
+		op.Name, op.TargetName, op.TargetAccessKey, op.ManagementKey, op.SDP, op.Delete, op.IgnoreNotFound, op.Online)
+	if err == nil {
+		return nil
+	}
+
+	// drivers report key violations differently, a peer stored under the name tells it apart from other errors
+	if _, getErr := s.GetOfferingPeer(ctx, op.Name); getErr == nil {
+		return peerhub.ErrOfferingPeerAlreadyExists
+	}
+	return err
+}
+
+// UpdateOfferingPeer replaces the offering peer if it's still at the version of op
+func (s *PeerService) UpdateOfferingPeer(ctx context.Context, op peerhub.OfferingPeer) error {
+	res, err := s.db.ExecContext(ctx, s.d.Rebind(`UPDATE offering_peers SET target_name = ?, target_access_key = ?, management_key = ?, sdp = ?, del = ?, ignore_not_found = ?, online = ?, version = version + 1 WHERE name = ? AND version = ?`),
+		op.TargetName, op.TargetAccessKey, op.ManagementKey, op.SDP, op.Delete, op.IgnoreNotFound, op.Online, op.Name, op.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil || updated > 0 {
+		return err
+	}
+
+	if _, err := s.GetOfferingPeer(ctx, op.Name); err != nil {
+		return err
+	}
+	return peerhub.ErrOfferingPeerConflict
+}
+
+func (s *PeerService) GetOfferingPeer(ctx context.Context, name string) (peerhub.OfferingPeer, error) {
+	row := s.db.QueryRowContext(ctx, s.d.Rebind(`SELECT `+offeringPeerColumns+` FROM offering_peers WHERE name = ?`), name)
+	op, err := scanOfferingPeer(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return peerhub.OfferingPeer{}, peerhub.ErrOfferingPeerNotFound
+	}
+	return op, err
+}
+
+func (s *PeerService) GetOfferingPeers(ctx context.Context) ([]peerhub.OfferingPeer, error) {
+	return s.queryOfferingPeers(ctx, `SELECT `+offeringPeerColumns+` FROM offering_peers`)
+}
+
+func (s *PeerService) GetOfferingPeersByTarget(ctx context.Context, name string) ([]peerhub.OfferingPeer, error) {
+	return s.queryOfferingPeers(ctx, s.d.Rebind(`SELECT `+offeringPeerColumns+` FROM offering_peers WHERE target_name = ?`), name)
+}
+
+func (s *PeerService) queryOfferingPeers(ctx context.Context, query string, args ...any) ([]peerhub.OfferingPeer, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []peerhub.OfferingPeer{}
+	for rows.Next() {
+		op, err := scanOfferingPeer(rows)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// DeleteOfferingPeer deletes the offering peer if it's still at the version of op
+func (s *PeerService) DeleteOfferingPeer(ctx context.Context, op peerhub.OfferingPeer) error {
+	res, err := s.db.ExecContext(ctx, s.d.Rebind(`DELETE FROM offering_peers WHERE name = ? AND version = ?`), op.Name, op.Version)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil || deleted > 0 {
+		return err
+	}
+
+	if _, err := s.GetOfferingPeer(ctx, op.Name); err != nil {
+		return err
+	}
+	return peerhub.ErrOfferingPeerConflict
+}
@@ -0,0 +1,169 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func answeringPeerRow(name string, version int64) []driver.Value {
+	return []driver.Value{name, "[]", "", false, version}
+}
+
+func offeringPeerRow(name string, version int64) []driver.Value {
+	return []driver.Value{name, "ap", "", "", "", false, false, false, version}
+}
+
+func TestUpdateAnsweringPeer(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		stored   bool
+		want     error
+	}{
+		{name: "at version", affected: 1, stored: true},
+		{name: "stale version", stored: true, want: peerhub.ErrAnsweringPeerConflict},
+		{name: "missing", want: peerhub.ErrAnsweringPeerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+				switch {
+				case strings.HasPrefix(query, "UPDATE answering_peers"):
+					return fakeResult{affected: tt.affected}
+				case strings.HasPrefix(query, "SELECT") && tt.stored:
+					return rows(answeringPeerColumns, answeringPeerRow("ap", 4))
+				}
+				return rows(answeringPeerColumns)
+			})
+			s := NewPeerService(db, Postgres)
+
+			err := s.UpdateAnsweringPeer(context.Background(), peerhub.AnsweringPeer{Name: "ap", Version: 3})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("update returned %v, want %v", err, tt.want)
+			}
+
+			updates, args := fdb.statements("UPDATE answering_peers")
+			if len(updates) != 1 || !strings.HasSuffix(updates[0], "WHERE name = $4 AND version = $5") {
+				t.Fatalf("ran %q, want an update conditional on the version", updates)
+			}
+			if args[0][3] != "ap" || args[0][4] != int64(3) {
+				t.Errorf("updated with %v, want the name and version of the peer", args[0])
+			}
+		})
+	}
+}
+
+func TestUpdateOfferingPeer(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		stored   bool
+		want     error
+	}{
+		{name: "at version", affected: 1, stored: true},
+		{name: "stale version", stored: true, want: peerhub.ErrOfferingPeerConflict},
+		{name: "missing", want: peerhub.ErrOfferingPeerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+				switch {
+				case strings.HasPrefix(query, "UPDATE offering_peers"):
+					return fakeResult{affected: tt.affected}
+				case strings.HasPrefix(query, "SELECT") && tt.stored:
+					return rows(offeringPeerColumns, offeringPeerRow("op", 4))
+				}
+				return rows(offeringPeerColumns)
+			})
+			s := NewPeerService(db, Postgres)
+
+			err := s.UpdateOfferingPeer(context.Background(), peerhub.OfferingPeer{Name: "op", Version: 3})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("update returned %v, want %v", err, tt.want)
+			}
+
+			_, args := fdb.statements("UPDATE offering_peers")
+			if len(args) != 1 || args[0][7] != "op" || args[0][8] != int64(3) {
+				t.Errorf("updated with %v, want the name and version of the peer", args)
+			}
+		})
+	}
+}
+
+func TestDeleteAnsweringPeer(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		stored   bool
+		want     error
+	}{
+		{name: "at version", affected: 1, stored: true},
+		{name: "stale version", stored: true, want: peerhub.ErrAnsweringPeerConflict},
+		{name: "missing", want: peerhub.ErrAnsweringPeerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+				switch {
+				case strings.HasPrefix(query, "DELETE FROM answering_peers"):
+					return fakeResult{affected: tt.affected}
+				case strings.HasPrefix(query, "SELECT") && tt.stored:
+					return rows(answeringPeerColumns, answeringPeerRow("ap", 4))
+				}
+				return rows(answeringPeerColumns)
+			})
+			s := NewPeerService(db, Postgres)
+
+			err := s.DeleteAnsweringPeer(context.Background(), peerhub.AnsweringPeer{Name: "ap", Version: 3})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("delete returned %v, want %v", err, tt.want)
+			}
+
+			deletes, args := fdb.statements("DELETE FROM answering_peers")
+			if len(deletes) != 1 || !strings.HasSuffix(deletes[0], "WHERE name = $1 AND version = $2") {
+				t.Fatalf("ran %q, want a delete conditional on the version", deletes)
+			}
+			if args[0][0] != "ap" || args[0][1] != int64(3) {
+				t.Errorf("deleted with %v, want the name and version of the peer", args[0])
+			}
+		})
+	}
+}
+
+func TestCreateAnsweringPeerAlreadyExists(t *testing.T) {
+	db, _ := openFake(t, func(query string, _ []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "INSERT") {
+			return fakeResult{err: errors.New("duplicate key")}
+		}
+		return rows(answeringPeerColumns, answeringPeerRow("ap", 1))
+	})
+	s := NewPeerService(db, Postgres)
+
+	err := s.CreateAnsweringPeer(context.Background(), peerhub.AnsweringPeer{Name: "ap"})
+	if !errors.Is(err, peerhub.ErrAnsweringPeerAlreadyExists) {
+		t.Errorf("create returned %v, want already exists", err)
+	}
+}
+
+func TestGetAnsweringPeer(t *testing.T) {
+	db, _ := openFake(t, func(string, []driver.Value) fakeResult {
+		return rows(answeringPeerColumns, []driver.Value{"ap", `["key"]`, "mk", true, int64(2)})
+	})
+	s := NewPeerService(db, Postgres)
+
+	ap, err := s.GetAnsweringPeer(context.Background(), "ap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ap.AccessKeys) != 1 || ap.AccessKeys[0] != "key" || !ap.Online || ap.Version != 2 {
+		t.Errorf("scanned %+v", ap)
+	}
+}
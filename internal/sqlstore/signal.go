@@ -0,0 +1,408 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+	"github.com/google/uuid"
+)
+
+type SignalService struct {
+	db *sql.DB
+	d  Dialect
+}
+
+// NewSignalService returns signal service backed by the database, the schema has to be migrated with Migrate
+func NewSignalService(db *sql.DB, d Dialect) *SignalService {
+	return &SignalService{db: db, d: d}
+}
+
+const (
+	offerColumns  = `id, offering_peer, answering_peer, sdp, state, answer_id, created_at, expires_at`
+	answerColumns = `id, offer_id, offering_peer, answering_peer, sdp, created_at, expires_at`
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanOffer(row scanner) (peerhub.Offer, error) {
+	o := peerhub.Offer{}
+	var createdAt, expiresAt int64
+	err := row.Scan(&o.ID, &o.OfferingPeer, &o.AnsweringPeer, &o.SDP, &o.State, &o.AnswerID, &createdAt, &expiresAt)
+	o.CreatedAt, o.ExpiresAt = fromUnix(createdAt), fromUnix(expiresAt)
+	return o, err
+}
+
+func scanAnswer(row scanner) (peerhub.Answer, error) {
+	a := peerhub.Answer{}
+	var createdAt, expiresAt int64
+	err := row.Scan(&a.ID, &a.OfferID, &a.OfferingPeer, &a.AnsweringPeer, &a.SDP, &createdAt, &expiresAt)
+	a.CreatedAt, a.ExpiresAt = fromUnix(createdAt), fromUnix(expiresAt)
+	return a, err
+}
+
+func queryOffers(ctx context.Context, q querier, query string, args ...any) ([]peerhub.Offer, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []peerhub.Offer{}
+	for rows.Next() {
+		o, err := scanOffer(rows)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+func queryAnswers(ctx context.Context, q querier, query string, args ...any) ([]peerhub.Answer, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := []peerhub.Answer{}
+	for rows.Next() {
+		a, err := scanAnswer(rows)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, a)
+	}
+	return answers, rows.Err()
+}
+
+// queryData returns json encoded data column of the rows in order
+func queryData(ctx context.Context, q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data := []string{}
+	for rows.Next() {
+		d := ""
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		data = append(data, d)
+	}
+	return data, rows.Err()
+}
+
+func (s *SignalService) insertOffer(ctx context.Context, q querier, o peerhub.Offer) error {
+	_, err := q.ExecContext(ctx, s.d.Rebind(`INSERT INTO offers (`+offerColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		o.ID, o.OfferingPeer, o.AnsweringPeer, o.SDP, o.State, o.AnswerID, toUnix(o.CreatedAt), toUnix(o.ExpiresAt))
+	return err
+}
+
+func (s *SignalService) insertAnswer(ctx context.Context, q querier, a peerhub.Answer) error {
+	_, err := q.ExecContext(ctx, s.d.Rebind(`INSERT INTO answers (`+answerColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		a.ID, a.OfferID, a.OfferingPeer, a.AnsweringPeer, a.SDP, toUnix(a.CreatedAt), toUnix(a.ExpiresAt))
+	return err
+}
+
+// deleteOffer deletes the offer with its candidates
+func (s *SignalService) deleteOffer(ctx context.Context, q querier, offerID string) error {
+	if _, err := q.ExecContext(ctx, s.d.Rebind(`DELETE FROM candidates WHERE offer_id = ?`), offerID); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, s.d.Rebind(`DELETE FROM offers WHERE id = ?`), offerID)
+	return err
+}
+
+func (s *SignalService) CreateOffer(ctx context.Context, o peerhub.Offer) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM offers WHERE id = ?`), o.ID); err != nil {
+			return err
+		}
+		return s.insertOffer(ctx, tx, o)
+	})
+}
+
+func (s *SignalService) GetOffer(ctx context.Context, offerID string) (peerhub.Offer, error) {
+	o, err := scanOffer(s.db.QueryRowContext(ctx, s.d.Rebind(`SELECT `+offerColumns+` FROM offers WHERE id = ?`), offerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return peerhub.Offer{}, peerhub.ErrOfferNotFound
+	}
+	return o, err
+}
+
+func (s *SignalService) GetOffers(ctx context.Context) ([]peerhub.Offer, error) {
+	return queryOffers(ctx, s.db, `SELECT `+offerColumns+` FROM offers`)
+}
+
+func (s *SignalService) GetOffersByOfferingPeer(ctx context.Context, name string) ([]peerhub.Offer, error) {
+	return queryOffers(ctx, s.db, s.d.Rebind(`SELECT `+offerColumns+` FROM offers WHERE offering_peer = ?`), name)
+}
+
+func (s *SignalService) GetOffersByAnsweringPeer(ctx context.Context, name string) ([]peerhub.Offer, error) {
+	return queryOffers(ctx, s.db, s.d.Rebind(`SELECT `+offerColumns+` FROM offers WHERE answering_peer = ?`), name)
+}
+
+func (s *SignalService) DeleteOffer(ctx context.Context, offerID string) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		return s.deleteOffer(ctx, tx, offerID)
+	})
+}
+
+func (s *SignalService) DeleteExpiredOffers(ctx context.Context, now time.Time) (expired []peerhub.Offer, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		expired, err = queryOffers(ctx, tx, s.d.Rebind(`SELECT `+offerColumns+` FROM offers WHERE expires_at <= ?`), toUnix(now))
+		if err != nil {
+			return err
+		}
+		for _, o := range expired {
+			if err := s.deleteOffer(ctx, tx, o.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return expired, err
+}
+
+func (s *SignalService) CreateAnswer(ctx context.Context, a peerhub.Answer) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM answers WHERE id = ?`), a.ID); err != nil {
+			return err
+		}
+		return s.insertAnswer(ctx, tx, a)
+	})
+}
+
+// AnswerOffer moves the offer from pending to answered with a conditional update,
+// so only one of concurrent answers succeeds
+func (s *SignalService) AnswerOffer(ctx context.Context, a peerhub.Answer) (offer peerhub.Offer, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.d.Rebind(`UPDATE offers SET state = ?, answer_id = ? WHERE id = ? AND state = ?`),
+			peerhub.OfferStateAnswered, a.ID, a.OfferID, peerhub.OfferStatePending)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		offer, err = scanOffer(tx.QueryRowContext(ctx, s.d.Rebind(`SELECT `+offerColumns+` FROM offers WHERE id = ?`), a.OfferID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return peerhub.ErrOfferNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return peerhub.ErrOfferAlreadyAnswered
+		}
+
+		return s.insertAnswer(ctx, tx, a)
+	})
+	if err != nil {
+		return peerhub.Offer{}, err
+	}
+	return offer, nil
+}
+
+func (s *SignalService) GetAnswer(ctx context.Context, answerID string) (peerhub.Answer, error) {
+	a, err := scanAnswer(s.db.QueryRowContext(ctx, s.d.Rebind(`SELECT `+answerColumns+` FROM answers WHERE id = ?`), answerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return peerhub.Answer{}, peerhub.ErrAnswerNotFound
+	}
+	return a, err
+}
+
+func (s *SignalService) GetAnswersByOffer(ctx context.Context, offerID string) ([]peerhub.Answer, error) {
+	return queryAnswers(ctx, s.db, s.d.Rebind(`SELECT `+answerColumns+` FROM answers WHERE offer_id = ?`), offerID)
+}
+
+func (s *SignalService) DeleteAnswer(ctx context.Context, answerID string) error {
+	_, err := s.db.ExecContext(ctx, s.d.Rebind(`DELETE FROM answers WHERE id = ?`), answerID)
+	return err
+}
+
+func (s *SignalService) DeleteExpiredAnswers(ctx context.Context, now time.Time) (expired []peerhub.Answer, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		expired, err = queryAnswers(ctx, tx, s.d.Rebind(`SELECT `+answerColumns+` FROM answers WHERE expires_at <= ?`), toUnix(now))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM answers WHERE expires_at <= ?`), toUnix(now))
+		return err
+	})
+	return expired, err
+}
+
+func (s *SignalService) CreateCandidate(ctx context.Context, c peerhub.Candidate) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		exists := 0
+		err := tx.QueryRowContext(ctx, s.d.Rebind(`SELECT COUNT(*) FROM offers WHERE id = ?`), c.OfferID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return peerhub.ErrOfferNotFound
+		}
+
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`INSERT INTO candidates (id, offer_id, recipient, created_at, data) VALUES (?, ?, ?, ?, ?)`),
+			uuid.NewString(), c.OfferID, c.To, toUnix(time.Now()), string(data))
+		return err
+	})
+}
+
+// PopCandidates returns and deletes candidates for the peer in the order they were created
+func (s *SignalService) PopCandidates(ctx context.Context, offerID, peerName string) (cands []peerhub.Candidate, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		data, err := queryData(ctx, tx, s.d.Rebind(`SELECT data FROM candidates WHERE offer_id = ? AND recipient = ? ORDER BY created_at, id`), offerID, peerName)
+		if err != nil {
+			return err
+		}
+
+		cands = []peerhub.Candidate{}
+		for _, d := range data {
+			c := peerhub.Candidate{}
+			if err := json.Unmarshal([]byte(d), &c); err != nil {
+				return err
+			}
+			cands = append(cands, c)
+		}
+
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM candidates WHERE offer_id = ? AND recipient = ?`), offerID, peerName)
+		return err
+	})
+	return cands, err
+}
+
+func (s *SignalService) CreateCooldown(ctx context.Context, c peerhub.Cooldown) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM cooldowns WHERE offering_peer = ? AND answering_peer = ?`), c.OfferingPeer, c.AnsweringPeer)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`INSERT INTO cooldowns (offering_peer, answering_peer, expires_at) VALUES (?, ?, ?)`),
+			c.OfferingPeer, c.AnsweringPeer, toUnix(c.ExpiresAt))
+		return err
+	})
+}
+
+func (s *SignalService) GetCooldown(ctx context.Context, opName, apName string) (peerhub.Cooldown, error) {
+	c := peerhub.Cooldown{OfferingPeer: opName, AnsweringPeer: apName}
+	var expiresAt int64
+	err := s.db.QueryRowContext(ctx, s.d.Rebind(`SELECT expires_at FROM cooldowns WHERE offering_peer = ? AND answering_peer = ?`), opName, apName).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return peerhub.Cooldown{}, peerhub.ErrCooldownNotFound
+	}
+	if err != nil {
+		return peerhub.Cooldown{}, err
+	}
+	c.ExpiresAt = fromUnix(expiresAt)
+	return c, nil
+}
+
+func (s *SignalService) DeleteExpiredCooldowns(ctx context.Context, now time.Time) (expired []peerhub.Cooldown, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, s.d.Rebind(`SELECT offering_peer, answering_peer, expires_at FROM cooldowns WHERE expires_at <= ?`), toUnix(now))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		expired = []peerhub.Cooldown{}
+		for rows.Next() {
+			c := peerhub.Cooldown{}
+			var expiresAt int64
+			if err := rows.Scan(&c.OfferingPeer, &c.AnsweringPeer, &expiresAt); err != nil {
+				return err
+			}
+			c.ExpiresAt = fromUnix(expiresAt)
+			expired = append(expired, c)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM cooldowns WHERE expires_at <= ?`), toUnix(now))
+		return err
+	})
+	return expired, err
+}
+
+func (s *SignalService) CreateMail(ctx context.Context, m peerhub.Mail) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.d.Rebind(`INSERT INTO mail (id, recipient_role, recipient, created_at, expires_at, data) VALUES (?, ?, ?, ?, ?, ?)`),
+		m.ID, m.RecipientRole, m.Recipient, toUnix(m.CreatedAt), toUnix(m.ExpiresAt), string(data))
+	return err
+}
+
+// PopMail returns and deletes mail queued for the peer in the order it was created
+func (s *SignalService) PopMail(ctx context.Context, role peerhub.PeerRole, name string) (mails []peerhub.Mail, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		data, err := queryData(ctx, tx, s.d.Rebind(`SELECT data FROM mail WHERE recipient_role = ? AND recipient = ? ORDER BY created_at, id`), role, name)
+		if err != nil {
+			return err
+		}
+
+		mails, err = decodeMail(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM mail WHERE recipient_role = ? AND recipient = ?`), role, name)
+		return err
+	})
+	return mails, err
+}
+
+func (s *SignalService) DeleteExpiredMail(ctx context.Context, now time.Time) (expired []peerhub.Mail, err error) {
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		data, err := queryData(ctx, tx, s.d.Rebind(`SELECT data FROM mail WHERE expires_at <= ? ORDER BY created_at, id`), toUnix(now))
+		if err != nil {
+			return err
+		}
+
+		expired, err = decodeMail(data)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, s.d.Rebind(`DELETE FROM mail WHERE expires_at <= ?`), toUnix(now))
+		return err
+	})
+	return expired, err
+}
+
+func decodeMail(data []string) ([]peerhub.Mail, error) {
+	mails := []peerhub.Mail{}
+	for _, d := range data {
+		m := peerhub.Mail{}
+		if err := json.Unmarshal([]byte(d), &m); err != nil {
+			return nil, err
+		}
+		mails = append(mails, m)
+	}
+	return mails, nil
+}
@@ -0,0 +1,167 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/H3Cki/peerhub"
+)
+
+func offerRow(id string, state peerhub.OfferState) []driver.Value {
+	return []driver.Value{id, "op", "ap", "sdp", string(state), "", int64(1), int64(2)}
+}
+
+func TestAnswerOffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		stored   peerhub.OfferState
+		want     error
+	}{
+		{name: "pending", affected: 1, stored: peerhub.OfferStateAnswered},
+		{name: "answered", stored: peerhub.OfferStateAnswered, want: peerhub.ErrOfferAlreadyAnswered},
+		{name: "missing", want: peerhub.ErrOfferNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+				switch {
+				case strings.HasPrefix(query, "UPDATE offers"):
+					return fakeResult{affected: tt.affected}
+				case strings.HasPrefix(query, "SELECT") && tt.stored != "":
+					return rows(offerColumns, offerRow("o", tt.stored))
+				}
+				return rows(offerColumns)
+			})
+			s := NewSignalService(db, Postgres)
+
+			a := peerhub.NewAnswer("o", "op", "ap", "sdp", time.Minute)
+			offer, err := s.AnswerOffer(context.Background(), a)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("answer returned %v, want %v", err, tt.want)
+			}
+
+			updates, args := fdb.statements("UPDATE offers")
+			if len(updates) != 1 || !strings.HasSuffix(updates[0], "WHERE id = $3 AND state = $4") {
+				t.Fatalf("ran %q, want an update conditional on the state", updates)
+			}
+			if args[0][3] != string(peerhub.OfferStatePending) {
+				t.Errorf("updated offers in state %v, want pending", args[0][3])
+			}
+
+			inserted := fdb.logged("INSERT INTO answers")
+			if tt.want != nil {
+				if inserted || !fdb.logged("ROLLBACK") || fdb.logged("COMMIT") {
+					t.Error("answer of an offer that can't be answered was stored")
+				}
+				return
+			}
+			if !inserted || !fdb.logged("COMMIT") {
+				t.Error("answer wasn't stored")
+			}
+			if offer.ID != "o" || offer.State != peerhub.OfferStateAnswered {
+				t.Errorf("answered %+v", offer)
+			}
+		})
+	}
+}
+
+func TestDeleteExpiredOffers(t *testing.T) {
+	db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "SELECT") {
+			return rows(offerColumns, offerRow("a", peerhub.OfferStatePending), offerRow("b", peerhub.OfferStateAnswered))
+		}
+		return fakeResult{affected: 1}
+	})
+	s := NewSignalService(db, Postgres)
+
+	now := time.Unix(100, 0)
+	expired, err := s.DeleteExpiredOffers(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 2 || expired[0].ID != "a" || expired[1].ID != "b" {
+		t.Fatalf("expired %+v, want a and b", expired)
+	}
+
+	selects, args := fdb.statements("SELECT")
+	if len(selects) != 1 || !strings.HasSuffix(selects[0], "WHERE expires_at <= $1") || args[0][0] != toUnix(now) {
+		t.Errorf("selected expired offers with %q %v", selects, args)
+	}
+	for _, table := range []string{"candidates", "offers"} {
+		_, args := fdb.statements("DELETE FROM " + table)
+		if len(args) != 2 || args[0][0] != "a" || args[1][0] != "b" {
+			t.Errorf("deleted %s of %v, want of a and b", table, args)
+		}
+	}
+	if !fdb.logged("COMMIT") {
+		t.Error("sweep wasn't committed")
+	}
+}
+
+func TestDeleteExpiredMail(t *testing.T) {
+	m := peerhub.Mail{ID: "m", Recipient: "ap", RecipientRole: peerhub.PeerRoleAnswering}
+	data, _ := json.Marshal(m)
+	db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "SELECT") {
+			return rows("data", []driver.Value{string(data)})
+		}
+		return fakeResult{affected: 1}
+	})
+	s := NewSignalService(db, Postgres)
+
+	now := time.Unix(100, 0)
+	expired, err := s.DeleteExpiredMail(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != "m" {
+		t.Fatalf("expired %+v, want m", expired)
+	}
+	_, args := fdb.statements("DELETE FROM mail WHERE expires_at <= $1")
+	if len(args) != 1 || args[0][0] != toUnix(now) {
+		t.Errorf("deleted mail with %v, want expired at %d", args, toUnix(now))
+	}
+}
+
+func TestDeleteExpiredCooldowns(t *testing.T) {
+	db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+		if strings.HasPrefix(query, "SELECT") {
+			return rows("offering_peer, answering_peer, expires_at", []driver.Value{"op", "ap", int64(5)})
+		}
+		return fakeResult{affected: 1}
+	})
+	s := NewSignalService(db, Postgres)
+
+	expired, err := s.DeleteExpiredCooldowns(context.Background(), time.Unix(100, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].OfferingPeer != "op" || !expired[0].ExpiresAt.Equal(fromUnix(5)) {
+		t.Fatalf("expired %+v", expired)
+	}
+	if !fdb.logged("DELETE FROM cooldowns WHERE expires_at <= $1") {
+		t.Error("expired cooldowns weren't deleted")
+	}
+}
+
+func TestCreateCandidateOfMissingOffer(t *testing.T) {
+	db, fdb := openFake(t, func(query string, _ []driver.Value) fakeResult {
+		return rows("count", []driver.Value{int64(0)})
+	})
+	s := NewSignalService(db, Postgres)
+
+	err := s.CreateCandidate(context.Background(), peerhub.Candidate{OfferID: "o", To: "ap"})
+	if !errors.Is(err, peerhub.ErrOfferNotFound) {
+		t.Errorf("create returned %v, want offer not found", err)
+	}
+	if fdb.logged("INSERT") {
+		t.Error("candidate of a missing offer was stored")
+	}
+}
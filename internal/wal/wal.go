@@ -0,0 +1,273 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrCorrupted = errors.New("log corrupted")
+
+// DefaultSnapshotEvery is the number of records appended before the log is compacted into a snapshot
+const DefaultSnapshotEvery = 1000
+
+// Record is a single logged operation, Seq grows with every record and survives snapshots
+type Record struct {
+	Seq  uint64          `json:"seq"`
+	Op   string          `json:"op"`
+	Data json.RawMessage `json:"data"`
+}
+
+type snapshot struct {
+	Seq   uint64          `json:"seq"`
+	State json.RawMessage `json:"state"`
+}
+
+// Log is an append-only write-ahead log with snapshots, records are synced to disk before Append returns
+// and snapshots are replaced atomically, so the state survives abrupt termination
+type Log struct {
+	mu            sync.Mutex
+	walPath       string
+	snapshotPath  string
+	f             *os.File
+	seq           uint64
+	sinceSnapshot int
+	snapshotEvery int
+	// broken is set if a failed append couldn't be rolled back, nothing is appended after it
+	broken error
+}
+
+// Open opens log named name in dir, creating the dir if it doesn't exist
+func Open(dir, name string, snapshotEvery int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+
+	return &Log{
+		walPath:       filepath.Join(dir, name+".wal"),
+		snapshotPath:  filepath.Join(dir, name+".snapshot"),
+		snapshotEvery: snapshotEvery,
+	}, nil
+}
+
+// Replay loads the last snapshot into restore and applies records logged after it,
+// a torn record at the end of the log, left by a crash in the middle of a write, is discarded
+func (l *Log) Replay(restore func(state json.RawMessage) error, apply func(Record) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	snap := snapshot{}
+	data, err := os.ReadFile(l.snapshotPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("error reading snapshot: %w", err)
+		}
+		if err := restore(snap.State); err != nil {
+			return fmt.Errorf("error restoring snapshot: %w", err)
+		}
+	}
+	l.seq = snap.Seq
+
+	f, err := os.OpenFile(l.walPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	valid, err := l.replayRecords(f, apply)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	// drop the torn tail so new records follow the last valid one
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	return nil
+}
+
+// replayRecords applies records newer than the snapshot and returns the size of the valid part of the log
+func (l *Log) replayRecords(f *os.File, apply func(Record) error) (int64, error) {
+	r := bufio.NewReader(f)
+	var valid int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a record without the trailing newline was not fully written
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		rec := Record{}
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			if _, peekErr := r.Peek(1); errors.Is(peekErr, io.EOF) {
+				return valid, nil
+			}
+			return 0, fmt.Errorf("%w: record at offset %d: %w", ErrCorrupted, valid, err)
+		}
+		valid += int64(len(line))
+
+		// records up to the snapshot's seq are already part of it
+		if rec.Seq <= l.seq {
+			continue
+		}
+		if err := apply(rec); err != nil {
+			return 0, fmt.Errorf("error applying record %d: %w", rec.Seq, err)
+		}
+		l.seq = rec.Seq
+		l.sinceSnapshot++
+	}
+}
+
+// Append logs the operation and syncs it to disk, it reports whether the log should be compacted with Snapshot
+func (l *Log) Append(op string, data any) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return false, errors.New("log is not open, replay it first")
+	}
+	if l.broken != nil {
+		return false, fmt.Errorf("log is broken: %w", l.broken)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	line, err := json.Marshal(Record{Seq: l.seq + 1, Op: op, Data: raw})
+	if err != nil {
+		return false, err
+	}
+
+	offset, err := l.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = l.f.Write(append(line, '\n'))
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		return false, errors.Join(err, l.rollback(offset))
+	}
+
+	l.seq++
+	l.sinceSnapshot++
+	return l.sinceSnapshot >= l.snapshotEvery, nil
+}
+
+// rollback cuts a partially written record off the log so the next record doesn't follow a torn one
+func (l *Log) rollback(offset int64) error {
+	err := l.f.Truncate(offset)
+	if err == nil {
+		_, err = l.f.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.broken = err
+		return fmt.Errorf("error rolling back torn record: %w", err)
+	}
+	return nil
+}
+
+// Snapshot atomically replaces the snapshot with state and empties the log,
+// state has to include every record appended so far
+func (l *Log) Snapshot(state any) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot{Seq: l.seq, State: raw})
+	if err != nil {
+		return err
+	}
+
+	tmp := l.snapshotPath + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.snapshotPath); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(l.snapshotPath)); err != nil {
+		return err
+	}
+
+	// a crash before the truncation is harmless, replay skips records included in the snapshot
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+
+	l.sinceSnapshot = 0
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
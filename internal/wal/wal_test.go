@@ -0,0 +1,173 @@
+package wal
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func openReplayed(t *testing.T, dir string, snapshotEvery int) (*Log, []Record, json.RawMessage) {
+	t.Helper()
+
+	l, err := Open(dir, "test", snapshotEvery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var state json.RawMessage
+	records := []Record{}
+	err = l.Replay(func(s json.RawMessage) error {
+		state = s
+		return nil
+	}, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, records, state
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	l, records, _ := openReplayed(t, dir, 0)
+	if len(records) != 0 {
+		t.Fatalf("replayed %d records of a new log", len(records))
+	}
+
+	for _, op := range []string{"a", "b", "c"} {
+		if _, err := l.Append(op, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	_, records, _ = openReplayed(t, dir, 0)
+	if len(records) != 3 {
+		t.Fatalf("replayed %d records, want 3", len(records))
+	}
+	for i, op := range []string{"a", "b", "c"} {
+		if records[i].Op != op || records[i].Seq != uint64(i+1) {
+			t.Errorf("record %d is %s/%d, want %s/%d", i, records[i].Op, records[i].Seq, op, i+1)
+		}
+	}
+}
+
+func TestReplayDiscardsTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	l, _, _ := openReplayed(t, dir, 0)
+	if _, err := l.Append("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	f, err := os.OpenFile(l.walPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"op":"b","da`)
+	f.Close()
+
+	l, records, _ := openReplayed(t, dir, 0)
+	if len(records) != 1 {
+		t.Fatalf("replayed %d records, want 1", len(records))
+	}
+
+	if _, err := l.Append("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	_, records, _ = openReplayed(t, dir, 0)
+	if len(records) != 2 || records[1].Op != "c" || records[1].Seq != 2 {
+		t.Fatalf("records appended after a torn one weren't replayed: %+v", records)
+	}
+}
+
+func TestReplayCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/test.wal", []byte("garbage\n{\"seq\":1,\"op\":\"a\",\"data\":1}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(dir, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	noop := func(json.RawMessage) error { return nil }
+	if err := l.Replay(noop, func(Record) error { return nil }); err == nil {
+		t.Fatal("replayed a log corrupted in the middle")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	l, _, _ := openReplayed(t, dir, 2)
+
+	if compact, _ := l.Append("a", 1); compact {
+		t.Fatal("compaction requested before snapshotEvery records")
+	}
+	compact, err := l.Append("b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compact {
+		t.Fatal("compaction not requested after snapshotEvery records")
+	}
+	if err := l.Snapshot([]int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	_, records, state := openReplayed(t, dir, 2)
+	if string(state) != "[1,2]" {
+		t.Errorf("restored state %s, want [1,2]", state)
+	}
+	if len(records) != 1 || records[0].Op != "c" || records[0].Seq != 3 {
+		t.Errorf("replayed %+v, want only record c at seq 3", records)
+	}
+}
+
+func TestFailedAppendIsNotLogged(t *testing.T) {
+	dir := t.TempDir()
+	l, _, _ := openReplayed(t, dir, 0)
+	if _, err := l.Append("a", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// a read-only file fails writes the way a full disk does
+	rw := l.f
+	ro, err := os.Open(l.walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ro.Seek(0, 2)
+	l.f = ro
+	if _, err := l.Append("b", 2); err == nil {
+		t.Fatal("append to a read-only log succeeded")
+	}
+	l.f = rw
+	ro.Close()
+
+	if l.seq != 1 {
+		t.Errorf("failed append advanced seq to %d", l.seq)
+	}
+	// the torn record couldn't be cut off the read-only file, nothing may follow it
+	if _, err := l.Append("c", 3); err == nil {
+		t.Error("appended to a log with a torn record")
+	}
+	l.Close()
+
+	_, records, _ := openReplayed(t, dir, 0)
+	if len(records) != 1 {
+		t.Fatalf("replayed %d records, want 1", len(records))
+	}
+}
@@ -0,0 +1,120 @@
+package peerhub
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var ErrInvalidKeyHash = errors.New("invalid key hash")
+
+// KeyHasher hashes access and management keys before they are stored and verifies keys against stored hashes
+type KeyHasher interface {
+	Hash(key string) (string, error)
+	Verify(hash, key string) (bool, error)
+	// IsHash tells if the stored value was produced by Hash, other values are treated as legacy plaintext keys
+	IsHash(stored string) bool
+}
+
+const argon2Prefix = "$argon2id$"
+
+// Argon2KeyHasher hashes keys with salted argon2id
+type Argon2KeyHasher struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+func NewArgon2KeyHasher() *Argon2KeyHasher {
+	return &Argon2KeyHasher{
+		Time:    2,
+		Memory:  19 * 1024,
+		Threads: 1,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+func (kh *Argon2KeyHasher) Hash(key string) (string, error) {
+	salt := make([]byte, kh.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	hash := argon2.IDKey([]byte(key), salt, kh.Time, kh.Memory, kh.Threads, kh.KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, kh.Memory, kh.Time, kh.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+func (kh *Argon2KeyHasher) Verify(hash, key string) (bool, error) {
+	// ["", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash]
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || !kh.IsHash(hash) {
+		return false, ErrInvalidKeyHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrInvalidKeyHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrInvalidKeyHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrInvalidKeyHash
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrInvalidKeyHash
+	}
+
+	got := argon2.IDKey([]byte(key), salt, time, memory, threads, uint32(len(want)))
+
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+func (kh *Argon2KeyHasher) IsHash(stored string) bool {
+	return strings.HasPrefix(stored, argon2Prefix)
+}
+
+// verifyKey checks the key against a stored hash, legacy plaintext values are compared directly
+func verifyKey(kh KeyHasher, stored, key string) (bool, error) {
+	if !kh.IsHash(stored) {
+		return keysEqual(stored, key), nil
+	}
+	return kh.Verify(stored, key)
+}
+
+// hashKey hashes the key, empty key stays empty as it marks an unprotected peer
+func hashKey(kh KeyHasher, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	return kh.Hash(key)
+}
+
+func hashKeys(kh KeyHasher, keys []string) ([]string, error) {
+	hashed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		h, err := hashKey(kh, key)
+		if err != nil {
+			return nil, err
+		}
+		hashed = append(hashed, h)
+	}
+	return hashed, nil
+}
@@ -0,0 +1,77 @@
+package peerhub
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidSealedKey = errors.New("invalid sealed key")
+
+const sealedPrefix = "$sealed$"
+
+// keySealer encrypts target access keys of offering peers before they are stored, unlike other keys
+// they can't be hashed because they're verified against hashed access keys of answering peers registering later
+type keySealer struct {
+	aead cipher.AEAD
+}
+
+// newKeySealer derives the encryption key from the secret, an empty secret is replaced with a random one
+func newKeySealer(secret string) *keySealer {
+	key := sha256.Sum256([]byte(secret))
+	if secret == "" {
+		// fails only if the system's random source is broken, nothing can be protected then
+		if _, err := rand.Read(key[:]); err != nil {
+			panic(err)
+		}
+	}
+
+	// neither fails with a 32 byte key
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return &keySealer{aead: aead}
+}
+
+// seal encrypts the key of the peer, the sealed key can't be moved to another peer,
+// empty key stays empty
+func (ks *keySealer) seal(peer, key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, ks.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := ks.aead.Seal(nonce, nonce, []byte(key), []byte(peer))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the key of the peer, values which aren't sealed are legacy plaintext keys
+func (ks *keySealer) open(peer, stored string) (string, error) {
+	if !ks.isSealed(stored) {
+		return stored, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < ks.aead.NonceSize() {
+		return "", ErrInvalidSealedKey
+	}
+
+	nonce, ciphertext := sealed[:ks.aead.NonceSize()], sealed[ks.aead.NonceSize():]
+	key, err := ks.aead.Open(nil, nonce, ciphertext, []byte(peer))
+	if err != nil {
+		// sealed with another secret or for another peer
+		return "", ErrInvalidSealedKey
+	}
+	return string(key), nil
+}
+
+func (ks *keySealer) isSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
@@ -0,0 +1,39 @@
+package peerhub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeySealer(t *testing.T) {
+	ks := newKeySealer("secret")
+
+	sealed, err := ks.seal("op", "access key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ks.isSealed(sealed) || strings.Contains(sealed, "access key") {
+		t.Fatalf("key wasn't sealed: %s", sealed)
+	}
+
+	if key, err := ks.open("op", sealed); err != nil || key != "access key" {
+		t.Errorf("opened %q %v", key, err)
+	}
+	if key, err := newKeySealer("secret").open("op", sealed); err != nil || key != "access key" {
+		t.Errorf("hub with the same secret opened %q %v", key, err)
+	}
+	if _, err := ks.open("other", sealed); !errors.Is(err, ErrInvalidSealedKey) {
+		t.Errorf("key sealed for another peer was opened, err %v", err)
+	}
+	if _, err := newKeySealer("other").open("op", sealed); !errors.Is(err, ErrInvalidSealedKey) {
+		t.Errorf("key sealed with another secret was opened, err %v", err)
+	}
+
+	if key, err := ks.open("op", "legacy"); err != nil || key != "legacy" {
+		t.Errorf("plaintext key opened as %q %v", key, err)
+	}
+	if sealed, _ := ks.seal("op", ""); sealed != "" {
+		t.Errorf("empty key sealed as %q", sealed)
+	}
+}
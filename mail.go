@@ -0,0 +1,146 @@
+package peerhub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidPeerRole = errors.New("invalid peer role")
+
+const DefaultMailTTL = 5 * time.Minute
+
+type PeerRole string
+
+const (
+	PeerRoleAnswering PeerRole = "answering"
+	PeerRoleOffering  PeerRole = "offering"
+)
+
+// Mail is a message queued for a registered peer which had no connection when the message was sent,
+// it is delivered in order once the peer reconnects
+type Mail struct {
+	ID            string          `json:"id"`
+	RecipientRole PeerRole        `json:"recipientrole"`
+	Recipient     string          `json:"recipient"`
+	SenderRole    PeerRole        `json:"senderrole"`
+	Sender        string          `json:"sender"`
+	Type          string          `json:"type"`
+	Data          json.RawMessage `json:"data"`
+	CreatedAt     time.Time       `json:"createdat"`
+	ExpiresAt     time.Time       `json:"expiresat"`
+}
+
+func (m *Mail) Expired(now time.Time) bool {
+	return !now.Before(m.ExpiresAt)
+}
+
+// DeliveryReceipt is sent to the sender of a mail once it's delivered to the recipient
+type DeliveryReceipt struct {
+	MailID        string    `json:"mailid"`
+	Type          string    `json:"type"`
+	RecipientRole PeerRole  `json:"recipientrole"`
+	Recipient     string    `json:"recipient"`
+	DeliveredAt   time.Time `json:"deliveredat"`
+}
+
+func NewDeliveryReceipt(m Mail) DeliveryReceipt {
+	return DeliveryReceipt{
+		MailID:        m.ID,
+		Type:          m.Type,
+		RecipientRole: m.RecipientRole,
+		Recipient:     m.Recipient,
+		DeliveredAt:   time.Now(),
+	}
+}
+
+// QueueMail stores a message for the offline peer, the recipient has to be registered
+func (h *Hub) QueueMail(ctx context.Context, req QueueMailRequest) (Mail, error) {
+	var err error
+	switch req.RecipientRole {
+	case PeerRoleAnswering:
+		_, err = h.peerSvc.GetAnsweringPeer(ctx, req.Recipient)
+	case PeerRoleOffering:
+		_, err = h.peerSvc.GetOfferingPeer(ctx, req.Recipient)
+	default:
+		err = ErrInvalidPeerRole
+	}
+	if err != nil {
+		return Mail{}, err
+	}
+
+	data, err := json.Marshal(req.Data)
+	if err != nil {
+		return Mail{}, err
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = h.mailTTL
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	// mail carrying an offer or an answer is useless once they expire
+	switch d := req.Data.(type) {
+	case Offer:
+		if d.ExpiresAt.Before(expiresAt) {
+			expiresAt = d.ExpiresAt
+		}
+	case Answer:
+		if d.ExpiresAt.Before(expiresAt) {
+			expiresAt = d.ExpiresAt
+		}
+	}
+
+	m := Mail{
+		ID:            uuid.NewString(),
+		RecipientRole: req.RecipientRole,
+		Recipient:     req.Recipient,
+		SenderRole:    req.SenderRole,
+		Sender:        req.Sender,
+		Type:          req.Type,
+		Data:          data,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+	}
+
+	if err := h.dealSvc.CreateMail(ctx, m); err != nil {
+		return Mail{}, err
+	}
+
+	return m, nil
+}
+
+// TakeMail removes and returns unexpired mail queued for the peer in the order it was sent
+func (h *Hub) TakeMail(ctx context.Context, role PeerRole, name string) ([]Mail, error) {
+	mails, err := h.dealSvc.PopMail(ctx, role, name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	valid := []Mail{}
+	for _, m := range mails {
+		if !m.Expired(now) {
+			valid = append(valid, m)
+		}
+	}
+
+	return valid, nil
+}
+
+type QueueMailRequest struct {
+	RecipientRole PeerRole
+	Recipient     string
+	// SenderRole and Sender are empty for messages sent by the hub itself, such mail gets no receipt
+	SenderRole PeerRole
+	Sender     string
+	Type       string
+	Data       any
+	// TTL defaults to the hub's mail TTL, mail carrying an offer or an answer expires with it
+	TTL time.Duration
+}
@@ -1,57 +1,79 @@
 package peerhub
 
-import "errors"
+import (
+	"crypto/subtle"
+	"errors"
+)
 
 var (
 	ErrOfferingPeerNotFound       = errors.New("offering peer not found")
 	ErrOfferingPeerAlreadyExists  = errors.New("offering peer already exists")
+	ErrOfferingPeerConflict       = errors.New("offering peer was changed concurrently")
 	ErrAnsweringPeerNotFound      = errors.New("answering peer not found")
 	ErrAnsweringPeerAlreadyExists = errors.New("answering peer already exists")
+	ErrAnsweringPeerConflict      = errors.New("answering peer was changed concurrently")
 	ErrInvalidAccessKey           = errors.New("invalid access key")
 	ErrInvalidManagementKey       = errors.New("invalid management key")
+	ErrTooManyAccessKeys          = errors.New("too many access keys")
 )
 
+// PeerService stores peers, creates, updates and deletes are compare-and-swap operations on the peer's version,
+// create stores a new peer at version 1 and fails with Err*PeerAlreadyExists if the name is taken,
+// update stores the peer at the next version and delete removes it only if the stored one is still at the version
+// of the given peer, they fail with Err*PeerConflict if it's not and with Err*PeerNotFound if there is no stored peer
 type PeerService interface {
 	CreateAnsweringPeer(AnsweringPeer) error
 	UpdateAnsweringPeer(AnsweringPeer) error
 	GetAnsweringPeer(name string) (AnsweringPeer, error)
 	GetAnsweringPeers() ([]AnsweringPeer, error)
-	DeleteAnsweringPeer(name string) error
+	DeleteAnsweringPeer(AnsweringPeer) error
 
 	CreateOfferingPeer(OfferingPeer) error
 	UpdateOfferingPeer(OfferingPeer) error
 	GetOfferingPeer(name string) (OfferingPeer, error)
+	GetOfferingPeers() ([]OfferingPeer, error)
 	GetOfferingPeersByTarget(name string) ([]OfferingPeer, error)
-	DeleteOfferingPeer(name string) error
+	DeleteOfferingPeer(OfferingPeer) error
 }
 
 type AnsweringPeer struct {
 	Name          string
 	AccessKeys    []string
 	ManagementKey string
+	Online        bool
+	// Version is incremented by every update of the stored peer
+	Version int
 }
 
 type AnsweringPeerPreview struct {
 	Name      string
 	Protected bool
+	Online    bool
 }
 
-func (ap *AnsweringPeer) AccessKeyMatches(key string) bool {
+// AccessKeyMatches verifies the key against hashed access keys, a peer without access keys is public
+func (ap *AnsweringPeer) AccessKeyMatches(kh KeyHasher, key string) (bool, error) {
 	if len(ap.AccessKeys) == 0 {
-		return true
+		return true, nil
 	}
 
 	for _, accessKey := range ap.AccessKeys {
-		if accessKey == key {
-			return true
+		ok, err := verifyKey(kh, accessKey, key)
+		if err != nil || ok {
+			return ok, err
 		}
 	}
 
-	return false
+	return false, nil
 }
 
-func (ap *AnsweringPeer) ManagementKeyMatches(key string) bool {
-	return ap.ManagementKey != "" || (ap.ManagementKey == key)
+// ManagementKeyMatches verifies the key against hashed management key, a peer without a management key is unprotected
+// and can be managed by anyone
+func (ap *AnsweringPeer) ManagementKeyMatches(kh KeyHasher, key string) (bool, error) {
+	if ap.ManagementKey == "" {
+		return true, nil
+	}
+	return verifyKey(kh, ap.ManagementKey, key)
 }
 
 type OfferingPeer struct {
@@ -62,8 +84,21 @@ type OfferingPeer struct {
 	SDP             string
 	Delete          bool
 	IgnoreNotFound  bool
+	Online          bool
+	// Version is incremented by every update of the stored peer
+	Version int
+}
+
+// ManagementKeyMatches verifies the key against hashed management key, a peer without a management key is unprotected
+// and can be managed by anyone
+func (op *OfferingPeer) ManagementKeyMatches(kh KeyHasher, key string) (bool, error) {
+	if op.ManagementKey == "" {
+		return true, nil
+	}
+	return verifyKey(kh, op.ManagementKey, key)
 }
 
-func (op *OfferingPeer) ManagementKeyMatches(key string) bool {
-	return op.ManagementKey != "" || (op.ManagementKey == key)
+// keysEqual compares keys in constant time
+func keysEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
 }
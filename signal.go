@@ -2,59 +2,196 @@ package peerhub
 
 import (
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 var (
-	ErrOfferNotFound  = errors.New("offer not found")
-	ErrAnswerNotFound = errors.New("answer not found")
+	ErrOfferNotFound        = errors.New("offer not found")
+	ErrOfferAlreadyAnswered = errors.New("offer already answered")
+	ErrAnswerNotFound       = errors.New("answer not found")
+	ErrPeerNotInOffer       = errors.New("peer is not a party of the offer")
+	ErrCooldownNotFound     = errors.New("cooldown not found")
+	ErrOfferCooldown        = errors.New("offering peer is blocked from offering to the answering peer")
 )
 
 type SignalService interface {
 	CreateOffer(Offer) error
 	GetOffer(offerID string) (Offer, error)
+	GetOffers() ([]Offer, error)
+	GetOffersByOfferingPeer(name string) ([]Offer, error)
+	GetOffersByAnsweringPeer(name string) ([]Offer, error)
 	DeleteOffer(offerID string) error
+	// DeleteExpiredOffers deletes offers expired at the given time and returns them
+	DeleteExpiredOffers(now time.Time) ([]Offer, error)
 
 	CreateAnswer(Answer) error
+	// AnswerOffer stores the answer and moves its pending offer to the answered state in one step,
+	// ErrOfferAlreadyAnswered is returned if the offer is not pending anymore
+	AnswerOffer(Answer) (Offer, error)
 	GetAnswer(answerID string) (Answer, error)
+	GetAnswersByOffer(offerID string) ([]Answer, error)
 	DeleteAnswer(answerID string) error
+	// DeleteExpiredAnswers deletes answers expired at the given time and returns them
+	DeleteExpiredAnswers(now time.Time) ([]Answer, error)
+
+	CreateCandidate(Candidate) error
+	// PopCandidates returns and removes candidates of the offer addressed to the peer
+	PopCandidates(offerID, peerName string) ([]Candidate, error)
+
+	CreateCooldown(Cooldown) error
+	GetCooldown(opName, apName string) (Cooldown, error)
+	// DeleteExpiredCooldowns deletes cooldowns expired at the given time and returns them
+	DeleteExpiredCooldowns(now time.Time) ([]Cooldown, error)
+
+	CreateMail(Mail) error
+	// PopMail returns and removes mail queued for the peer in the order it was created
+	PopMail(role PeerRole, name string) ([]Mail, error)
+	// DeleteExpiredMail deletes mail expired at the given time and returns it
+	DeleteExpiredMail(now time.Time) ([]Mail, error)
 }
 
+type OfferState string
+
+const (
+	OfferStatePending  OfferState = "pending"
+	OfferStateAnswered OfferState = "answered"
+)
+
 type Offer struct {
-	ID            string `json:"id"`
-	OfferingPeer  string `json:"offeringpeer"`
-	AnsweringPeer string `json:"answeringpeer"`
-	SDP           string `json:"sdp"`
+	ID            string     `json:"id"`
+	OfferingPeer  string     `json:"offeringpeer"`
+	AnsweringPeer string     `json:"answeringpeer"`
+	SDP           string     `json:"sdp"`
+	State         OfferState `json:"state"`
+	AnswerID      string     `json:"answerid"`
+	CreatedAt     time.Time  `json:"createdat"`
+	ExpiresAt     time.Time  `json:"expiresat"`
 }
 
-func NewOffer(opName, sdp, apName string) Offer {
+func NewOffer(opName, sdp, apName string, ttl time.Duration) Offer {
+	now := time.Now()
 	return Offer{
 		ID:            uuid.NewString(),
 		OfferingPeer:  opName,
 		AnsweringPeer: apName,
 		SDP:           sdp,
+		State:         OfferStatePending,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
 	}
 }
 
+func (o *Offer) Expired(now time.Time) bool {
+	return !now.Before(o.ExpiresAt)
+}
+
 type Answer struct {
-	ID            string `json:"id"`
-	OfferID       string `json:"offerid"`
-	AnsweringPeer string `json:"answeringpeer"`
-	SDP           string `json:"sdp"`
+	ID            string    `json:"id"`
+	OfferID       string    `json:"offerid"`
+	OfferingPeer  string    `json:"offeringpeer"`
+	AnsweringPeer string    `json:"answeringpeer"`
+	SDP           string    `json:"sdp"`
+	CreatedAt     time.Time `json:"createdat"`
+	ExpiresAt     time.Time `json:"expiresat"`
 }
 
-func NewAnswer(offerID, apName, apSDP string) Answer {
+func NewAnswer(offerID, opName, apName, apSDP string, ttl time.Duration) Answer {
+	now := time.Now()
 	return Answer{
 		ID:            uuid.NewString(),
 		OfferID:       offerID,
+		OfferingPeer:  opName,
 		AnsweringPeer: apName,
 		SDP:           apSDP,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
 	}
 }
 
+func (a *Answer) Expired(now time.Time) bool {
+	return !now.Before(a.ExpiresAt)
+}
+
+// Candidate is a trickled ICE candidate sent by one party of an offer to the other,
+// EndOfCandidates marks that the sender has finished gathering.
+type Candidate struct {
+	OfferID          string  `json:"offerid"`
+	From             string  `json:"from"`
+	To               string  `json:"to"`
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpmid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpmlineindex,omitempty"`
+	UsernameFragment *string `json:"usernamefragment,omitempty"`
+	EndOfCandidates  bool    `json:"endofcandidates"`
+}
+
+func NewCandidate(offerID, from, to string, req CreateCandidateRequest) Candidate {
+	return Candidate{
+		OfferID:          offerID,
+		From:             from,
+		To:               to,
+		Candidate:        req.Candidate,
+		SDPMid:           req.SDPMid,
+		SDPMLineIndex:    req.SDPMLineIndex,
+		UsernameFragment: req.UsernameFragment,
+		EndOfCandidates:  req.EndOfCandidates,
+	}
+}
+
+type RejectReason string
+
+const (
+	RejectReasonDeclined    RejectReason = "declined"
+	RejectReasonBusy        RejectReason = "busy"
+	RejectReasonUnsupported RejectReason = "unsupported"
+	// RejectReasonError means the answering peer failed to produce an answer
+	RejectReasonError RejectReason = "error"
+)
+
+// RejectedOffer is sent to the offering peer when the answering peer declines the offer or fails to answer it
+type RejectedOffer struct {
+	OfferID       string       `json:"offerid"`
+	OfferingPeer  string       `json:"offeringpeer"`
+	AnsweringPeer string       `json:"answeringpeer"`
+	Reason        RejectReason `json:"reason"`
+	Message       string       `json:"message"`
+	// RetryAfter is set if the offering peer is blocked from offering to the answering peer until then
+	RetryAfter *time.Time `json:"retryafter,omitempty"`
+}
+
+// Cooldown blocks the offering peer from offering to the answering peer until it expires
+type Cooldown struct {
+	OfferingPeer  string    `json:"offeringpeer"`
+	AnsweringPeer string    `json:"answeringpeer"`
+	ExpiresAt     time.Time `json:"expiresat"`
+}
+
+func (c *Cooldown) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
+
+// FailedOffer is sent to both peers when an offer couldn't be made, Error is the reason in the same form
+// as error replies
 type FailedOffer struct {
-	OfferingPeer  string `json:"offeringpeer"`
-	AnsweringPeer string `json:"answeringpeer"`
-	Error         error  `json:"error"`
+	OfferingPeer  string    `json:"offeringpeer"`
+	AnsweringPeer string    `json:"answeringpeer"`
+	Error         ErrorData `json:"error"`
+}
+
+func NewFailedOffer(opName, apName string, err error) FailedOffer {
+	return FailedOffer{
+		OfferingPeer:  opName,
+		AnsweringPeer: apName,
+		Error:         NewErrorData(err),
+	}
+}
+
+// Err returns the error of the hub the code of the reason names, or an error with the message if it names none
+func (f FailedOffer) Err() error {
+	if err, ok := ErrorOf(f.Error.Code); ok {
+		return err
+	}
+	return errors.New(f.Error.Message)
 }